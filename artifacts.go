@@ -0,0 +1,161 @@
+package tftest
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// ArtifactStore is implemented by destinations for the artifacts collected
+// from the working directories of failed tests, such as an object storage
+// bucket, so that the evidence needed to debug a failure outlives an
+// ephemeral CI runner.
+//
+// This package includes only DirArtifactStore, so that it doesn't depend on
+// any particular cloud SDK, but an implementation for S3, GCS or similar needs
+// only to upload each artifact as an object named by its key.
+type ArtifactStore interface {
+	// StoreArtifact saves a single artifact. The key is a slash-separated
+	// path made of the run ID, the test name with any labels of the working
+	// directory, and the artifact's file name.
+	StoreArtifact(key string, content []byte) error
+}
+
+// DirArtifactStore returns an ArtifactStore which writes each artifact to a
+// file under the given local directory, named by its key.
+func DirArtifactStore(dir string) ArtifactStore {
+	return dirArtifactStore(dir)
+}
+
+type dirArtifactStore string
+
+func (s dirArtifactStore) StoreArtifact(key string, content []byte) error {
+	p := filepath.Join(string(s), filepath.FromSlash(key))
+	err := os.MkdirAll(filepath.Dir(p), 0755)
+	if err != nil {
+		return err
+	}
+	err = ioutil.WriteFile(p, content, 0644)
+	if err != nil {
+		return err
+	}
+	emitEvent(Event{Type: EventArtifactWritten, Path: p})
+	return nil
+}
+
+// SetArtifactStore makes working directories created by RequireNewWorkingDir
+// save their artifacts to the given store when they are closed after their
+// test has failed, as described for StoreArtifacts.
+//
+// Alternatively, set the environment variable TF_ACC_ARTIFACTS_DIR to have
+// AutoInitHelper or InitHelper use a DirArtifactStore for that directory.
+func (h *Helper) SetArtifactStore(store ArtifactStore) {
+	h.artifactStore = store
+}
+
+// RunID returns the identifier of the current test run, which is the first
+// element of the key of each artifact. It is taken from the environment
+// variable TF_ACC_RUN_ID if set, or otherwise generated from the time the
+// helper was initialized.
+func (h *Helper) RunID() string {
+	return h.runID
+}
+
+// newRunID returns the run ID for a new helper.
+func newRunID() string {
+	if id := os.Getenv("TF_ACC_RUN_ID"); id != "" {
+		return id
+	}
+	return fmt.Sprintf("%s-%d", now().UTC().Format("20060102T150405Z"), os.Getpid())
+}
+
+// StoreArtifacts saves the working directory's command history, along with
+// its configuration and state files, to the helper's artifact store.
+//
+// Working directories created with RequireNewWorkingDir do this automatically
+// when closed if their test has failed, so most callers don't need to call
+// this directly.
+func (wd *WorkingDir) StoreArtifacts() error {
+	store := wd.h.artifactStore
+	if store == nil {
+		return fmt.Errorf("no artifact store is configured")
+	}
+
+	prefix := wd.artifactPrefix()
+
+	artifacts := map[string][]byte{
+		"commands.log": []byte(wd.commandLog()),
+	}
+	if len(wd.labels) > 0 {
+		src, err := json.MarshalIndent(wd.labels, "", "  ")
+		if err != nil {
+			return err
+		}
+		artifacts["labels.json"] = src
+	}
+	files, err := ioutil.ReadDir(wd.baseDir)
+	if err != nil {
+		return err
+	}
+	for _, f := range files {
+		n := f.Name()
+		if !f.Mode().IsRegular() {
+			continue
+		}
+		if !strings.HasSuffix(n, ".tf") && !strings.HasSuffix(n, ".tf.json") && !strings.HasSuffix(n, ".tfvars.json") && !strings.HasPrefix(n, "terraform.tfstate") {
+			continue
+		}
+		src, err := ioutil.ReadFile(filepath.Join(wd.baseDir, n))
+		if err != nil {
+			return err
+		}
+		artifacts[n] = src
+	}
+
+	var problems []string
+	for n, content := range artifacts {
+		if err := store.StoreArtifact(path.Join(prefix, n), content); err != nil {
+			problems = append(problems, fmt.Sprintf("%s: %s", n, err))
+		}
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("failed to store artifacts:\n  %s", strings.Join(problems, "\n  "))
+	}
+	return nil
+}
+
+// artifactPrefix returns the prefix of the keys of the working directory's
+// artifacts, which is made of the run ID and the test name, followed by the
+// working directory's labels in brackets if it has any.
+func (wd *WorkingDir) artifactPrefix() string {
+	name := wd.testName
+	if name == "" {
+		name = filepath.Base(wd.baseDir)
+	}
+	if labels := wd.labelsString(); labels != "" {
+		name += "[" + labels + "]"
+	}
+	return path.Join(wd.h.runID, name)
+}
+
+// commandLog renders the working directory's command history as text.
+func (wd *WorkingDir) commandLog() string {
+	var b strings.Builder
+	for _, cmd := range wd.history {
+		fmt.Fprintf(&b, "=== terraform %s (started %s, took %s)\n", cmd.Name, cmd.Started.Format(time.RFC3339), cmd.Duration)
+		if cmd.Err != nil {
+			fmt.Fprintf(&b, "--- error\n%s\n", cmd.Err)
+		}
+		fmt.Fprintf(&b, "--- stdout\n%s\n", cmd.Stdout)
+		fmt.Fprintf(&b, "--- stderr\n%s\n", cmd.Stderr)
+		if cmd.ProviderOutput != "" {
+			fmt.Fprintf(&b, "--- provider output\n%s\n", cmd.ProviderOutput)
+		}
+	}
+	return b.String()
+}
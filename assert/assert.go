@@ -0,0 +1,237 @@
+// Package assert contains composable checks for the plans and state produced
+// by the tftest package, so that provider test suites don't each need to
+// maintain their own library of check helpers.
+//
+// A Check inspects a set of values as recorded in either the state or the
+// planned values of a plan. Pass any number of checks to State or Plan to run
+// them together, failing the test with a description of every check that
+// didn't pass:
+//
+//	state := wd.RequireState(t)
+//	assert.State(t, state,
+//	    assert.ResourceExists("null_resource.test"),
+//	    assert.AttrEquals("null_resource.test", "triggers.a", "b"),
+//	    assert.NoResourceOfType("null_data_source"),
+//	)
+package assert
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+
+	tfjson "github.com/hashicorp/terraform-json"
+	tftest "github.com/hashicorp/terraform-plugin-test/v2"
+)
+
+// Check is a single assertion about the resources and outputs in a state or
+// planned state, returning an error describing the problem if it doesn't
+// hold.
+type Check func(values *tfjson.StateValues) error
+
+// State runs the given checks against the given state, failing the test if
+// any of them return an error.
+func State(t tftest.TestControl, state *tfjson.State, checks ...Check) {
+	t.Helper()
+
+	var values *tfjson.StateValues
+	if state != nil {
+		values = state.Values
+	}
+	run(t, "state", values, checks)
+}
+
+// Plan runs the given checks against the planned values of the given plan,
+// which describe the state as it will be after the plan is applied, failing
+// the test if any of them return an error.
+func Plan(t tftest.TestControl, plan *tfjson.Plan, checks ...Check) {
+	t.Helper()
+
+	var values *tfjson.StateValues
+	if plan != nil {
+		values = plan.PlannedValues
+	}
+	run(t, "plan", values, checks)
+}
+
+func run(t tftest.TestControl, what string, values *tfjson.StateValues, checks []Check) {
+	t.Helper()
+
+	failed := false
+	for _, check := range checks {
+		if err := check(values); err != nil {
+			t.Log(fmt.Sprintf("%s check failed: %s", what, err))
+			failed = true
+		}
+	}
+	if failed {
+		t.FailNow()
+	}
+}
+
+// All combines the given checks into a single check which returns the error
+// from the first of them that fails.
+func All(checks ...Check) Check {
+	return func(values *tfjson.StateValues) error {
+		for _, check := range checks {
+			if err := check(values); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// ResourceExists returns a check that the resource instance with the given
+// absolute address, such as "module.foo.aws_instance.bar[0]", exists.
+func ResourceExists(address string) Check {
+	return func(values *tfjson.StateValues) error {
+		_, err := findResource(values, address)
+		return err
+	}
+}
+
+// NoResourceOfType returns a check that there are no resource instances of
+// the given type in any module.
+func NoResourceOfType(typeName string) Check {
+	return func(values *tfjson.StateValues) error {
+		var found []string
+		eachResource(values, func(r *tfjson.StateResource) {
+			if r.Type == typeName {
+				found = append(found, r.Address)
+			}
+		})
+		if len(found) > 0 {
+			return fmt.Errorf("expected no resources of type %s, but found %s", typeName, strings.Join(found, ", "))
+		}
+		return nil
+	}
+}
+
+// AttrSet returns a check that the given attribute of the resource instance
+// with the given address has a non-null value.
+//
+// The attribute is given as a path of attribute names, map keys and list
+// indices separated by periods, such as "tags.Name" or "disk.0.size".
+func AttrSet(address, attr string) Check {
+	return func(values *tfjson.StateValues) error {
+		v, err := attrValue(values, address, attr)
+		if err != nil {
+			return err
+		}
+		if v == nil {
+			return fmt.Errorf("%s: attribute %s is not set", address, attr)
+		}
+		return nil
+	}
+}
+
+// AttrEquals returns a check that the given attribute of the resource
+// instance with the given address has the given value. The attribute path is
+// interpreted as for AttrSet.
+//
+// The expected value is compared with the value decoded from Terraform's JSON
+// output, so it may be any value which encodes to the same JSON: for example,
+// an int is equal to the equivalent number in the state.
+func AttrEquals(address, attr string, want interface{}) Check {
+	return func(values *tfjson.StateValues) error {
+		got, err := attrValue(values, address, attr)
+		if err != nil {
+			return err
+		}
+		return compare(fmt.Sprintf("%s: attribute %s", address, attr), got, want)
+	}
+}
+
+// OutputEquals returns a check that the root module output value with the
+// given name has the given value, compared as for AttrEquals.
+func OutputEquals(name string, want interface{}) Check {
+	return func(values *tfjson.StateValues) error {
+		if values == nil || values.Outputs[name] == nil {
+			return fmt.Errorf("output %q does not exist", name)
+		}
+		return compare(fmt.Sprintf("output %q", name), values.Outputs[name].Value, want)
+	}
+}
+
+func compare(what string, got, want interface{}) error {
+	// Round-trip both values through JSON so that they have the same dynamic
+	// types, since numbers decoded by terraform-exec are json.Number while
+	// callers will typically give an int or float64.
+	wantSrc, wantV, err := roundTripJSON(want)
+	if err != nil {
+		return fmt.Errorf("%s: invalid expected value: %s", what, err)
+	}
+	gotSrc, gotV, err := roundTripJSON(got)
+	if err != nil {
+		return fmt.Errorf("%s: invalid value: %s", what, err)
+	}
+
+	if !reflect.DeepEqual(gotV, wantV) {
+		return fmt.Errorf("%s is %s, but expected %s", what, gotSrc, wantSrc)
+	}
+	return nil
+}
+
+func roundTripJSON(v interface{}) ([]byte, interface{}, error) {
+	src, err := json.Marshal(v)
+	if err != nil {
+		return nil, nil, err
+	}
+	var ret interface{}
+	err = json.Unmarshal(src, &ret)
+	return src, ret, err
+}
+
+func eachResource(values *tfjson.StateValues, f func(*tfjson.StateResource)) {
+	if values == nil || values.RootModule == nil {
+		return
+	}
+	modules := []*tfjson.StateModule{values.RootModule}
+	for len(modules) > 0 {
+		module := modules[0]
+		modules = append(modules[1:], module.ChildModules...)
+		for _, r := range module.Resources {
+			f(r)
+		}
+	}
+}
+
+func findResource(values *tfjson.StateValues, address string) (*tfjson.StateResource, error) {
+	var found *tfjson.StateResource
+	eachResource(values, func(r *tfjson.StateResource) {
+		if r.Address == address {
+			found = r
+		}
+	})
+	if found == nil {
+		return nil, fmt.Errorf("resource %s does not exist", address)
+	}
+	return found, nil
+}
+
+func attrValue(values *tfjson.StateValues, address, attr string) (interface{}, error) {
+	r, err := findResource(values, address)
+	if err != nil {
+		return nil, err
+	}
+
+	var v interface{} = r.AttributeValues
+	for _, step := range strings.Split(attr, ".") {
+		switch tv := v.(type) {
+		case map[string]interface{}:
+			v = tv[step]
+		case []interface{}:
+			i, err := strconv.Atoi(step)
+			if err != nil || i < 0 || i >= len(tv) {
+				return nil, fmt.Errorf("%s: attribute %s: no element %q", address, attr, step)
+			}
+			v = tv[i]
+		default:
+			return nil, nil
+		}
+	}
+	return v, nil
+}
@@ -0,0 +1,165 @@
+package tftest
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclparse"
+	"github.com/zclconf/go-cty/cty"
+)
+
+// AllowRemoteBackend disables the check that Init normally makes to ensure
+// that the working directory's configuration keeps its state within the
+// helper's own directories.
+//
+// The check exists to protect against test fixtures copied from real
+// projects, whose backend blocks would otherwise let a test read or
+// overwrite real state. The check can also be disabled for all working
+// directories by setting the environment variable
+// TF_ACC_ALLOW_REMOTE_BACKEND.
+func (wd *WorkingDir) AllowRemoteBackend() {
+	wd.allowRemoteBackend = true
+}
+
+var (
+	terraformBlockSchema = &hcl.BodySchema{
+		Blocks: []hcl.BlockHeaderSchema{{Type: "terraform"}},
+	}
+	backendBlockSchema = &hcl.BodySchema{
+		Blocks: []hcl.BlockHeaderSchema{
+			{Type: "backend", LabelNames: []string{"type"}},
+			{Type: "cloud"},
+		},
+	}
+	localBackendSchema = &hcl.BodySchema{
+		Attributes: []hcl.AttributeSchema{{Name: "path"}, {Name: "workspace_dir"}},
+	}
+)
+
+// checkBackend returns an error if the configuration in the working
+// directory selects a backend other than the local backend, or a local
+// backend whose state is outside the helper's base directory, unless remote
+// backends have been allowed.
+func (wd *WorkingDir) checkBackend() error {
+	if wd.allowRemoteBackend || os.Getenv("TF_ACC_ALLOW_REMOTE_BACKEND") != "" {
+		return nil
+	}
+
+	var filenames []string
+	for _, pattern := range []string{"*.tf", "*.tf.json"} {
+		matches, err := filepath.Glob(filepath.Join(wd.baseDir, pattern))
+		if err != nil {
+			return err
+		}
+		filenames = append(filenames, matches...)
+	}
+
+	parser := hclparse.NewParser()
+	for _, filename := range filenames {
+		var file *hcl.File
+		var diags hcl.Diagnostics
+		if strings.HasSuffix(filename, ".json") {
+			file, diags = parser.ParseJSONFile(filename)
+		} else {
+			file, diags = parser.ParseHCLFile(filename)
+		}
+		if diags.HasErrors() {
+			// Terraform will refuse to initialize with this file too, so
+			// leave it to report the errors.
+			continue
+		}
+		if err := wd.checkBackendFile(filepath.Base(filename), file.Body); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkBackendFile implements checkBackend for the configuration file with
+// the given name and body.
+func (wd *WorkingDir) checkBackendFile(name string, body hcl.Body) error {
+	refuse := func(reason string) error {
+		return fmt.Errorf("refusing to initialize %s, because it %s; call AllowRemoteBackend or set TF_ACC_ALLOW_REMOTE_BACKEND if this is intentional", name, reason)
+	}
+
+	content, _, _ := body.PartialContent(terraformBlockSchema)
+	for _, tfBlock := range content.Blocks {
+		tfContent, _, _ := tfBlock.Body.PartialContent(backendBlockSchema)
+		for _, block := range tfContent.Blocks {
+			switch {
+			case block.Type == "cloud":
+				return refuse("configures Terraform Cloud")
+			case block.Labels[0] != "local":
+				return refuse(fmt.Sprintf("configures the %s backend", block.Labels[0]))
+			}
+
+			attrs, _, _ := block.Body.PartialContent(localBackendSchema)
+			for _, attr := range attrs.Attributes {
+				v, diags := attr.Expr.Value(nil)
+				if diags.HasErrors() || v.IsNull() || !v.IsKnown() || v.Type() != cty.String {
+					// Terraform requires literal strings here.
+					continue
+				}
+				path := v.AsString()
+				if !filepath.IsAbs(path) {
+					path = filepath.Join(wd.baseDir, path)
+				}
+				inside, err := wd.h.managesPath(path)
+				if err != nil {
+					return err
+				}
+				if !inside {
+					return refuse(fmt.Sprintf("keeps local state at %s, outside of the test helper's directories", v.AsString()))
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// managesPath returns true if the given path, after resolving any symlinks
+// such as those to the source directory, is within the helper's base
+// directory.
+func (h *Helper) managesPath(path string) (bool, error) {
+	base, err := filepath.EvalSymlinks(h.baseDir)
+	if err != nil {
+		return false, err
+	}
+	path, err = evalSymlinksPrefix(path)
+	if err != nil {
+		return false, err
+	}
+	rel, err := filepath.Rel(base, path)
+	if err != nil {
+		return false, nil
+	}
+	return rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)), nil
+}
+
+// evalSymlinksPrefix is like filepath.EvalSymlinks, except that the path
+// need not exist: symlinks are resolved in its longest existing prefix.
+func evalSymlinksPrefix(path string) (string, error) {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+	rest := ""
+	for {
+		resolved, err := filepath.EvalSymlinks(path)
+		if err == nil {
+			return filepath.Join(resolved, rest), nil
+		}
+		if !os.IsNotExist(err) {
+			return "", err
+		}
+		parent := filepath.Dir(path)
+		if parent == path {
+			return filepath.Join(path, rest), nil
+		}
+		rest = filepath.Join(filepath.Base(path), rest)
+		path = parent
+	}
+}
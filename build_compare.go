@@ -0,0 +1,168 @@
+package tftest
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// BuildComparison is the result of CompareBuilds.
+type BuildComparison struct {
+	// StateDiffs are the differences between the attribute values of the
+	// resource instances in the two states. The first step of each path is
+	// the address of the resource instance, which can be matched in
+	// CompareOptions only by "*", so that for example "*.id" ignores the id
+	// attribute of every instance.
+	StateDiffs []Difference
+
+	// OutputDiffs are the differences between the root module output
+	// values, with paths starting with the output name.
+	OutputDiffs []Difference
+}
+
+// Empty returns true if the two builds produced equivalent results.
+func (c *BuildComparison) Empty() bool {
+	return len(c.StateDiffs) == 0 && len(c.OutputDiffs) == 0
+}
+
+func (c *BuildComparison) String() string {
+	var b strings.Builder
+	for _, d := range c.StateDiffs {
+		fmt.Fprintf(&b, "  state: %s\n", d)
+	}
+	for _, d := range c.OutputDiffs {
+		fmt.Fprintf(&b, "  output: %s\n", d)
+	}
+	return b.String()
+}
+
+// CompareBuilds applies the given configuration in two new working
+// directories, one using the previous release of the provider with the given
+// source address, as given by Config.PreviousPluginExec, and one using the
+// current build registered for it with AddProviderBinary. It then destroys
+// both and returns the differences between the resulting states and outputs,
+// with the previous build's results as the expected values.
+//
+// This catches unintended changes of behavior between provider releases,
+// such as a computed attribute that gets a different default. Attributes
+// that legitimately differ between any two applies, such as generated IDs,
+// should be ignored or normalized using opts.
+func (h *Helper) CompareBuilds(source, cfg string, opts *CompareOptions) (*BuildComparison, error) {
+	if h.previousPluginExec == "" {
+		return nil, fmt.Errorf("no previous provider executable to compare against: set Config.PreviousPluginExec")
+	}
+
+	prevState, prevOutputs, err := h.applyBuild(source, h.previousPluginExec, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("previous build: %s", err)
+	}
+	curState, curOutputs, err := h.applyBuild(source, "", cfg)
+	if err != nil {
+		return nil, fmt.Errorf("current build: %s", err)
+	}
+
+	ret := &BuildComparison{}
+	ret.StateDiffs, err = Compare(prevState, curState, opts)
+	if err != nil {
+		return nil, err
+	}
+	ret.OutputDiffs, err = Compare(prevOutputs, curOutputs, opts)
+	if err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+// RequireCompareBuilds is a variant of CompareBuilds that will fail the test
+// via the given TestControl, listing all of the differences, if the builds
+// cannot be compared or their results are not equivalent.
+func (h *Helper) RequireCompareBuilds(t TestControl, source, cfg string, opts *CompareOptions) {
+	t.Helper()
+	c, err := h.CompareBuilds(source, cfg, opts)
+	if err != nil {
+		t := testingT{t}
+		t.Fatalf("failed to compare provider builds: %s", err)
+		return
+	}
+	if !c.Empty() {
+		t := testingT{t}
+		t.Fatalf("current provider build behaves differently from the previous release:\n%s", c)
+	}
+}
+
+// applyBuild applies the given configuration in a new working directory
+// using the provider executable at the given path, or the registered one if
+// path is empty, and returns the attribute values of each resource instance
+// by address and the output values by name. The working directory is
+// destroyed and removed before returning.
+func (h *Helper) applyBuild(source, path, cfg string) (map[string]interface{}, map[string]interface{}, error) {
+	wd, err := h.NewWorkingDir()
+	if err != nil {
+		return nil, nil, err
+	}
+	defer wd.Close()
+
+	if path != "" {
+		err = wd.SwitchProviderExec(source, path)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	err = wd.SetConfig(cfg)
+	if err != nil {
+		return nil, nil, err
+	}
+	err = wd.Init()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	state, outputs, err := wd.applyAndRead()
+	if destroyErr := wd.Destroy(); err == nil && destroyErr != nil {
+		err = fmt.Errorf("failed to destroy: %s", destroyErr)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	return state, outputs, nil
+}
+
+// applyAndRead applies the configuration and returns the resulting resource
+// instance values and output values, as described for applyBuild.
+func (wd *WorkingDir) applyAndRead() (map[string]interface{}, map[string]interface{}, error) {
+	err := wd.Apply()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	raw, err := wd.RawState()
+	if err != nil {
+		return nil, nil, err
+	}
+	var state struct {
+		Values struct {
+			RootModule jsonStateModule `json:"root_module"`
+		} `json:"values"`
+	}
+	err = json.Unmarshal(raw, &state)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to decode state: %w", err)
+	}
+	values := map[string]interface{}{}
+	state.Values.RootModule.allResourceValues(values)
+
+	outputs, err := wd.Outputs()
+	if err != nil {
+		return nil, nil, err
+	}
+	outputValues := make(map[string]interface{}, len(outputs))
+	for name, output := range outputs {
+		var v interface{}
+		err = output.Unmarshal(&v)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to decode output %s: %w", name, err)
+		}
+		outputValues[name] = v
+	}
+	return values, outputValues, nil
+}
@@ -0,0 +1,285 @@
+package tftest
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// CleanupPolicy controls what happens when destroying the objects a test
+// created, or closing its working directory, fails, as set with
+// WorkingDir.SetCleanupPolicy or Helper.SetCleanupPolicy.
+//
+// The policy applies to RequireDestroy, RequireDestroyWithOptions,
+// RequireDestroyContext and RequireClose, and to the cleanup done by RunGroup.
+type CleanupPolicy int
+
+const (
+	// CleanupFailTest fails the test, after logging a warning that remote
+	// objects may still exist. This is the default.
+	CleanupFailTest CleanupPolicy = iota
+
+	// CleanupWarn logs the warning but lets the test pass, for tests whose
+	// remote objects are known to be cleaned up by other means.
+	CleanupWarn
+
+	// CleanupRecordLeak logs the warning, keeps the working directory, whose
+	// state is then the only record of the objects that may still exist, and
+	// records it in the leaked resources manifest, so that SweepLeaks can
+	// destroy the objects later. The test passes.
+	CleanupRecordLeak
+)
+
+// leakWarning is the consequence of a failed cleanup, included in the
+// warnings and errors that report one.
+const leakWarning = "remote objects may still exist and be subject to billing"
+
+// SetCleanupPolicy sets the policy for cleanup failures in the working
+// directory.
+func (wd *WorkingDir) SetCleanupPolicy(policy CleanupPolicy) {
+	wd.cleanupPolicy = policy
+}
+
+// SetCleanupPolicy sets the cleanup policy for the working directories created
+// by the helper from now on.
+func (h *Helper) SetCleanupPolicy(policy CleanupPolicy) {
+	h.cleanupPolicy = policy
+}
+
+// LeakedResources describes a working directory recorded in the leaked
+// resources manifest by CleanupRecordLeak.
+type LeakedResources struct {
+	Test       string `json:"test"`
+	WorkingDir string `json:"working_dir"`
+
+	// Addresses are the addresses of the managed resource instances that
+	// remained in state after the failure, if they could be determined.
+	Addresses []string `json:"addresses,omitempty"`
+
+	// Error is the message of the error from the failed cleanup.
+	Error string `json:"error"`
+}
+
+// handleCleanupFailure reacts to the given error from the cleanup step
+// described by what, such as "destroy", according to the cleanup policy.
+func (wd *WorkingDir) handleCleanupFailure(t TestControl, what string, err error) {
+	t.Helper()
+	tt := testingT{t}
+	switch wd.cleanupPolicy {
+	case CleanupWarn:
+		tt.Logf("WARNING: failed to %s, so %s: %s", what, leakWarning, err)
+	case CleanupRecordLeak:
+		wd.recordLeak(err)
+		tt.Logf("WARNING: failed to %s, so %s; recorded %s in the leaked resources manifest: %s", what, leakWarning, wd.baseDir, err)
+	default:
+		tt.Logf("WARNING: %s failed, so %s", what, leakWarning)
+		tt.Fatalf("failed to %s: %s", what, err)
+	}
+}
+
+// recordLeak keeps the working directory and adds it to the helper's leaked
+// resources.
+func (wd *WorkingDir) recordLeak(err error) {
+	// The state may not be readable if the failure was in closing the
+	// working directory, when there is just the directory to report.
+	addresses, _ := wd.managedAddresses()
+
+	wd.leaked = true
+	wd.h.persistDir(wd.baseDir)
+	wd.h.leaksMu.Lock()
+	defer wd.h.leaksMu.Unlock()
+	wd.h.leaks = append(wd.h.leaks, LeakedResources{
+		Test:       wd.testName,
+		WorkingDir: wd.baseDir,
+		Addresses:  addresses,
+		Error:      err.Error(),
+	})
+}
+
+// LeakedResources returns the working directories recorded by
+// CleanupRecordLeak so far.
+func (h *Helper) LeakedResources() []LeakedResources {
+	h.leaksMu.Lock()
+	defer h.leaksMu.Unlock()
+	ret := make([]LeakedResources, len(h.leaks))
+	copy(ret, h.leaks)
+	return ret
+}
+
+// LeakManifestPath returns the location of the leaked resources manifest,
+// which is the value of the environment variable TF_ACC_LEAK_MANIFEST_PATH if
+// set, or otherwise tftest-leaks.json in the system temporary directory.
+func LeakManifestPath() string {
+	if p := os.Getenv("TF_ACC_LEAK_MANIFEST_PATH"); p != "" {
+		return p
+	}
+	return filepath.Join(os.TempDir(), "tftest-leaks.json")
+}
+
+// readLeakManifest returns the entries of the manifest at the given path, or
+// none if it doesn't exist.
+func readLeakManifest(path string) ([]LeakedResources, error) {
+	src, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var ret []LeakedResources
+	if err := json.Unmarshal(src, &ret); err != nil {
+		return nil, fmt.Errorf("invalid leaked resources manifest %s: %s", path, err)
+	}
+	return ret, nil
+}
+
+// leakManifestLockTimeout is how long a lock on the leaked resources
+// manifest may be held before it is assumed that its holder crashed. Holders
+// only read and rewrite the manifest, so this is much shorter than the lock
+// on the Terraform CLI cache, which is held during a download.
+const leakManifestLockTimeout = time.Minute
+
+// lockLeakManifest takes the lock on the manifest at the given path, which
+// is shared between processes so that concurrent test runs don't overwrite
+// each other's entries. It returns a function that releases the lock.
+func lockLeakManifest(path string) (func(), error) {
+	unlock, err := lockFile(path+".lock", currentClock(), leakManifestLockTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("failed to lock leaked resources manifest: %w", err)
+	}
+	return unlock, nil
+}
+
+// writeLeakManifest writes the given entries to the manifest at the given
+// path, removing the manifest if there are none.
+func writeLeakManifest(path string, leaks []LeakedResources) error {
+	if len(leaks) == 0 {
+		err := os.Remove(path)
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	src, err := json.MarshalIndent(leaks, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, src, 0644)
+}
+
+// writeLeakReport writes a summary of the leaked resources to w, and adds
+// them to the leaked resources manifest, after any entries already there from
+// earlier runs.
+func (h *Helper) writeLeakReport(w io.Writer) error {
+	leaks := h.LeakedResources()
+	if len(leaks) == 0 {
+		return nil
+	}
+
+	fmt.Fprintf(w, "%d working directories may have leaked remote objects:\n", len(leaks))
+	for _, leak := range leaks {
+		fmt.Fprintf(w, "  %s: %s\n", leak.Test, leak.WorkingDir)
+		for _, addr := range leak.Addresses {
+			fmt.Fprintf(w, "    %s\n", addr)
+		}
+	}
+
+	p := LeakManifestPath()
+	unlock, err := lockLeakManifest(p)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	existing, err := readLeakManifest(p)
+	if err != nil {
+		return err
+	}
+	err = writeLeakManifest(p, append(existing, leaks...))
+	if err != nil {
+		return fmt.Errorf("failed to write leaked resources manifest: %w", err)
+	}
+	emitEvent(Event{Type: EventArtifactWritten, Path: p})
+	return nil
+}
+
+// SweepLeaks attempts to destroy the objects in each working directory
+// listed in the leaked resources manifest at LeakManifestPath, using the
+// configuration and state kept there, in the same way as CollectStaleDirs.
+// Each directory that is destroyed is removed, along with its entry in the
+// manifest, and the manifest itself is removed once it is empty.
+//
+// As with CollectStaleDirs, destroy won't succeed for a directory whose
+// configuration relies on a provider that was served by the test program
+// that leaked it.
+//
+// The manifest is locked only while it is read and rewritten, not during
+// the destroys, so entries added by concurrent test runs in the meantime are
+// kept for a later sweep.
+//
+// The return value is the list of directories that were removed.
+func (h *Helper) SweepLeaks() ([]string, error) {
+	p := LeakManifestPath()
+	unlock, err := lockLeakManifest(p)
+	if err != nil {
+		return nil, err
+	}
+	leaks, err := readLeakManifest(p)
+	unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	var removed []string
+	var problems []string
+	done := map[string]bool{}
+	for _, leak := range leaks {
+		if _, err := os.Stat(leak.WorkingDir); os.IsNotExist(err) {
+			// already cleaned up by other means
+			done[leak.WorkingDir] = true
+			continue
+		}
+		if err := h.destroyStaleDir(leak.WorkingDir); err != nil {
+			problems = append(problems, fmt.Sprintf("%s: %s", leak.WorkingDir, err))
+			continue
+		}
+		if err := os.RemoveAll(leak.WorkingDir); err != nil {
+			problems = append(problems, fmt.Sprintf("%s: %s", leak.WorkingDir, err))
+		}
+		done[leak.WorkingDir] = true
+		removed = append(removed, leak.WorkingDir)
+	}
+
+	if err := removeLeakManifestEntries(p, done); err != nil {
+		problems = append(problems, fmt.Sprintf("%s: %s", p, err))
+	}
+	if len(problems) > 0 {
+		return removed, fmt.Errorf("failed to sweep some leaked working directories, so %s:\n  %s", leakWarning, strings.Join(problems, "\n  "))
+	}
+	return removed, nil
+}
+
+// removeLeakManifestEntries removes the entries for the given working
+// directories from the manifest at the given path, keeping any others.
+func removeLeakManifestEntries(path string, dirs map[string]bool) error {
+	unlock, err := lockLeakManifest(path)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	leaks, err := readLeakManifest(path)
+	if err != nil {
+		return err
+	}
+	var remaining []LeakedResources
+	for _, leak := range leaks {
+		if !dirs[leak.WorkingDir] {
+			remaining = append(remaining, leak)
+		}
+	}
+	return writeLeakManifest(path, remaining)
+}
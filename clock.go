@@ -0,0 +1,88 @@
+package tftest
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Clock is the source of time used by this package for command timings,
+// timeouts, throttling, event timestamps and report generation. Downstream
+// frameworks built on this package can install a fake implementation with
+// SetClock to test their own timing-dependent logic deterministically.
+type Clock interface {
+	// Now returns the current time.
+	Now() time.Time
+
+	// After returns a channel which receives the current time once the
+	// given duration has elapsed.
+	After(d time.Duration) <-chan time.Time
+
+	// Sleep blocks until the given duration has elapsed.
+	Sleep(d time.Duration)
+}
+
+// realClock is the default Clock, which uses the system clock.
+type realClock struct{}
+
+func (realClock) Now() time.Time                         { return time.Now() }
+func (realClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+func (realClock) Sleep(d time.Duration)                  { time.Sleep(d) }
+
+var clock struct {
+	sync.Mutex
+	c Clock
+}
+
+// SetClock replaces the source of time used by this package. Pass nil to
+// restore the system clock.
+//
+// Terraform itself, and the providers it runs, always use the real system
+// clock, so a fake clock affects only the times measured and reported by
+// this package.
+func SetClock(c Clock) {
+	clock.Lock()
+	defer clock.Unlock()
+	clock.c = c
+}
+
+// currentClock returns the clock set with SetClock, or the system clock.
+func currentClock() Clock {
+	clock.Lock()
+	defer clock.Unlock()
+	if clock.c == nil {
+		return realClock{}
+	}
+	return clock.c
+}
+
+// now returns the current time according to the package's clock.
+func now() time.Time {
+	return currentClock().Now()
+}
+
+// since returns the time elapsed since t according to the package's clock.
+func since(t time.Time) time.Duration {
+	return now().Sub(t)
+}
+
+// withTimeout is like context.WithTimeout, but measures the timeout using the
+// package's clock. The returned function reports whether the context was
+// cancelled because the timeout elapsed.
+func withTimeout(parent context.Context, d time.Duration) (context.Context, context.CancelFunc, func() bool) {
+	ctx, cancel := context.WithCancel(parent)
+	var timedOut int32
+	timer := currentClock().After(d)
+	go func() {
+		select {
+		case <-timer:
+			atomic.StoreInt32(&timedOut, 1)
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, cancel, func() bool {
+		return atomic.LoadInt32(&timedOut) == 1
+	}
+}
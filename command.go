@@ -0,0 +1,430 @@
+package tftest
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"runtime/debug"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/terraform-exec/tfexec"
+)
+
+// interruptGracePeriod is how long runTerraform waits for Terraform to exit
+// after sending it an interrupt, before giving up and killing it. Terraform
+// uses this time to wait for in-flight provider operations and to persist
+// any partial state.
+const interruptGracePeriod = 5 * time.Minute
+
+// runTerraform runs the Terraform CLI directly in the working directory with
+// the given arguments.
+//
+// Most commands should be run via terraform-exec instead. This exists for the
+// situations where we need more control over the child process than
+// terraform-exec offers, such as interrupting it gracefully (rather than
+// abandoning it) when the given context is cancelled, or killing it when the
+// command timeout set with SetCommandTimeout elapses.
+func (wd *WorkingDir) runTerraform(ctx context.Context, args ...string) error {
+	stderr := newLimitedBuffer(wd.outputLimit)
+
+	timedOut := func() bool { return false }
+	if wd.commandTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel, timedOut = withTimeout(ctx, wd.commandTimeout)
+		defer cancel()
+	}
+
+	cmd, finishWrapper, err := wd.wrapCommand(args)
+	if err != nil {
+		return err
+	}
+	defer finishWrapper()
+	startProcessGroup(cmd)
+	cmd.Dir = wd.baseDir
+	cmd.Stdin = wd.runStdinR
+	cmd.Stdout = wd.runStdoutW
+	cmd.Stderr = stderr
+	if wd.runStderrW != nil {
+		cmd.Stderr = io.MultiWriter(stderr, wd.runStderrW)
+	}
+
+	env, err := wd.buildEnv()
+	if err != nil {
+		return err
+	}
+	cmd.Env = env
+	if !wd.resourceLimits.isZero() {
+		if err := limitCommand(cmd, wd.resourceLimits); err != nil {
+			return fmt.Errorf("failed to apply resource limits: %s", err)
+		}
+	}
+
+	err = cmd.Start()
+	if err != nil {
+		return err
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- cmd.Wait()
+	}()
+
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		if timedOut() {
+			killProcessTree(cmd)
+			<-done
+			return &TerraformError{
+				Subcommand: args[0],
+				Args:       args,
+				ExitCode:   -1,
+				Stderr:     stderr.String(),
+				Err: &CommandTimeoutError{
+					Command: args[0],
+					Timeout: wd.commandTimeout,
+					Stderr:  stderr.String(),
+				},
+			}
+		}
+
+		// Interrupting gives Terraform the opportunity to finish writing
+		// state, so that whatever was created so far can be cleaned up.
+		if interruptProcessTree(cmd) != nil {
+			// os.Interrupt is not supported on all platforms
+			killProcessTree(cmd)
+		}
+		select {
+		case <-done:
+		case <-currentClock().After(interruptGracePeriod):
+			killProcessTree(cmd)
+			<-done
+		}
+		// Terraform's own exit status is not interesting after an
+		// interruption, and callers need to recognize the cancellation.
+		err = ctx.Err()
+	}
+
+	if err != nil {
+		return &TerraformError{
+			Subcommand: args[0],
+			Args:       args,
+			ExitCode:   exitCode(err),
+			Stderr:     stderr.String(),
+			Err:        fmt.Errorf("%w\n\n%s", err, stderr.String()),
+		}
+	}
+	return nil
+}
+
+// Command describes a single Terraform CLI command run by a WorkingDir, as
+// recorded in its command history.
+type Command struct {
+	// Name is the Terraform subcommand that was run, such as "apply".
+	Name string
+
+	// Env is the complete environment the Terraform process was started
+	// with, after all of the helper's own adjustments, in "KEY=value" form.
+	Env []string
+
+	// Started is the time at which the command was started, and Duration is
+	// how long it took to complete.
+	Started  time.Time
+	Duration time.Duration
+
+	// Stdout and Stderr are everything the command wrote to its standard
+	// output and standard error streams.
+	Stdout string
+	Stderr string
+
+	// ProviderOutput is everything the provider plugin processes wrote to
+	// stderr during the command, if enabled with CaptureProviderOutput.
+	ProviderOutput string
+
+	// WrapperOutput is what the command wrapper set with SetCommandWrapper
+	// wrote to its output file during the command, if it was given one.
+	WrapperOutput string
+
+	// Err is the error the command returned, or nil if it succeeded.
+	Err error
+
+	// Labels are the labels attached to the working directory with
+	// SetLabel at the time the command was run.
+	Labels map[string]string
+}
+
+// CommandHistory returns a record of every Terraform CLI command run in the
+// working directory so far, in the order they were run.
+func (wd *WorkingDir) CommandHistory() []Command {
+	ret := make([]Command, len(wd.history))
+	copy(ret, wd.history)
+	return ret
+}
+
+// AddCommandHook registers a function to be called after each Terraform CLI
+// command in the working directory completes, with the same record that is
+// added to the command history.
+func (wd *WorkingDir) AddCommandHook(hook func(Command)) {
+	wd.commandHooks = append(wd.commandHooks, hook)
+}
+
+// AddPreCommandHook registers a function to be called before each Terraform
+// CLI command in the working directory starts. The given record has
+// only its Name and Env fields populated.
+//
+// If the hook returns an error then the command is not run, and the error is
+// returned from the method that would have run it. A hook may also block, for
+// example to wait for capacity to become available, and time spent in hooks
+// is not included in the command's recorded Duration.
+func (wd *WorkingDir) AddPreCommandHook(hook func(Command) error) {
+	wd.preCommandHooks = append(wd.preCommandHooks, hook)
+}
+
+// run calls f, which must run the Terraform subcommand with the given name,
+// and records the command in the working directory's history.
+func (wd *WorkingDir) run(name string, f func() error) error {
+	_, err := wd.runCommand(name, true, f)
+	return err
+}
+
+// runStdout is a variant of run which also returns everything the command
+// wrote to stdout, which is never truncated to the output limit.
+func (wd *WorkingDir) runStdout(name string, f func() error) (string, error) {
+	return wd.runCommand(name, false, f)
+}
+
+// runCommand implements run and runStdout, retrying the command as directed
+// by the working directory's retry policy. The command's stderr is always
+// truncated to the output limit, and its stdout only if limitStdout is set.
+func (wd *WorkingDir) runCommand(name string, limitStdout bool, f func() error) (string, error) {
+	if err := wd.ensureInitialized(name); err != nil {
+		return "", err
+	}
+
+	var firstErr error
+	for attempt := 1; ; attempt++ {
+		stdout, err := wd.runCommandOnce(name, limitStdout, f)
+		if err == nil && attempt > 1 {
+			wd.h.recordFlaky(FlakyCommand{
+				Test:       wd.testName,
+				Command:    name,
+				Attempts:   attempt,
+				FirstError: firstErr.Error(),
+			})
+		}
+		if err == nil || !wd.retryPolicy.retry(attempt, err) {
+			return stdout, err
+		}
+		if firstErr == nil {
+			firstErr = err
+		}
+		currentClock().Sleep(wd.retryPolicy.Delay)
+	}
+}
+
+// runCommandOnce runs a single attempt of a command for runCommand.
+func (wd *WorkingDir) runCommandOnce(name string, limitStdout bool, f func() error) (string, error) {
+	cmd := Command{
+		Name:   name,
+		Labels: wd.Labels(),
+	}
+
+	finishCapture, err := wd.startLogCapture(&cmd)
+	if err != nil {
+		return "", err
+	}
+
+	env, err := wd.buildEnv()
+	cmd.Env = env
+	if err == nil {
+		err = wd.checkPlanOnly(name)
+	}
+	for _, hook := range wd.preCommandHooks {
+		if err != nil {
+			break
+		}
+		err = hook(cmd)
+	}
+
+	stdout, stderr := newLimitedBuffer(0), newLimitedBuffer(wd.outputLimit)
+	if limitStdout {
+		stdout = newLimitedBuffer(wd.outputLimit)
+	}
+	stdoutW, stderrW, flushStreams := wd.streamOutput(stdout, stderr, limitStdout)
+	wd.runStdoutW, wd.runStderrW = stdoutW, stderrW
+	wd.tf.SetStdout(stdoutW)
+	wd.tf.SetStderr(stderrW)
+
+	cmd.Started = now()
+	emitEvent(Event{Time: cmd.Started, Type: EventCommandStarted, Test: wd.testName, Command: name, Labels: cmd.Labels})
+	if err == nil {
+		err = wd.checkDiskQuota()
+	}
+	if err == nil {
+		err = wd.applyEnv()
+	}
+	if err == nil {
+		err = wd.writeVariables()
+	}
+	if err == nil {
+		err = wd.applyWorkspace()
+	}
+	if err == nil {
+		stopFileWatch := wd.startFileWatch(name)
+		wd.wrapperOutput = ""
+		err = f()
+		stopFileWatch()
+		cmd.WrapperOutput = wd.wrapperOutput
+		if err != nil {
+			err = newTerraformError(name, err, stdout.String(), stderr.String())
+		}
+	}
+	if err == nil {
+		err = wd.checkDiskQuota()
+	}
+
+	wd.tf.SetStdout(ioutil.Discard)
+	wd.tf.SetStderr(ioutil.Discard)
+	wd.runStdoutW, wd.runStderrW = nil, nil
+	flushStreams()
+
+	err = limitError(err, wd.outputLimit)
+	cmd.Duration = since(cmd.Started)
+	if err != nil {
+		addDiagnostics(err)
+	}
+	cmd.Stdout = stdout.String()
+	cmd.Stderr = stderr.String()
+	cmd.Err = err
+	finishCapture()
+	finished := Event{Type: EventCommandFinished, Test: wd.testName, Command: name, Duration: cmd.Duration, Labels: cmd.Labels}
+	if err != nil {
+		finished.Message = err.Error()
+	}
+	emitEvent(finished)
+	wd.history = append(wd.history, cmd)
+	if err != nil {
+		wd.storeReproBundle(cmd)
+	}
+	wd.h.recordDeprecations(cmd.Stdout + cmd.Stderr)
+	wd.h.recordCommandMetrics(cmd)
+	for _, hook := range wd.commandHooks {
+		hook(cmd)
+	}
+
+	return cmd.Stdout, err
+}
+
+// baseEnv returns the environment variables that the working directory
+// passes to Terraform before terraform-exec adds its own settings.
+//
+// If no variables were set with Setenv then this is just the environment of
+// the current process. Otherwise, the extra variables are merged in and any
+// variables that terraform-exec insists on managing itself are removed, since
+// terraform-exec would otherwise reject the whole environment.
+func (wd *WorkingDir) baseEnv() map[string]string {
+	env := map[string]string{}
+	for _, kv := range os.Environ() {
+		parts := strings.SplitN(kv, "=", 2)
+		if len(parts) == 2 {
+			env[parts[0]] = parts[1]
+		}
+	}
+
+	if len(wd.env) == 0 {
+		return env
+	}
+
+	env = tfexec.CleanEnv(env)
+	for k, v := range wd.env {
+		env[k] = v
+	}
+	return env
+}
+
+// applyEnv passes the variables set with Setenv on to terraform-exec.
+func (wd *WorkingDir) applyEnv() error {
+	if len(wd.env) == 0 {
+		// explicit nil means terraform-exec copies os.Environ
+		return wd.tf.SetEnv(nil)
+	}
+	return wd.tf.SetEnv(wd.baseEnv())
+}
+
+// buildEnv returns the complete environment for a Terraform command run in the
+// working directory, mirroring the environment terraform-exec constructs.
+func (wd *WorkingDir) buildEnv() ([]string, error) {
+	env := wd.baseEnv()
+
+	if p := wd.logPath(); p != "" {
+		env["TF_LOG_PATH"] = p
+		env["TF_LOG"] = "TRACE"
+		if level := os.Getenv("TF_LOG"); level != "" && wd.commandLogPath == "" && wd.h.passthroughLogging() {
+			env["TF_LOG"] = level
+		}
+	} else if wd.h.passthroughLogging() {
+		env["TF_LOG_PATH"] = os.Getenv("TF_LOG_PATH")
+		env["TF_LOG"] = os.Getenv("TF_LOG")
+	} else {
+		// so logging can't pollute our stderr output
+		env["TF_LOG_PATH"] = ""
+		env["TF_LOG"] = ""
+	}
+
+	// terraform-exec always passes on CHECKPOINT_DISABLE and appends its
+	// own user agent
+	if _, ok := env["CHECKPOINT_DISABLE"]; !ok {
+		env["CHECKPOINT_DISABLE"] = os.Getenv("CHECKPOINT_DISABLE")
+	}
+	env["TF_APPEND_USER_AGENT"] = terraformExecUserAgent()
+
+	env["TF_IN_AUTOMATION"] = "1"
+	// terraform-exec always clears TF_WORKSPACE, and commands we run
+	// directly do the same so that both select the workspace through the
+	// file written by applyWorkspace.
+	env["TF_WORKSPACE"] = ""
+	env["TF_DISABLE_PLUGIN_TLS"] = "1"
+	env["TF_SKIP_PROVIDER_VERIFY"] = "1"
+
+	if wd.reattachInfo != nil {
+		reattachStr, err := json.Marshal(wd.reattachInfo)
+		if err != nil {
+			return nil, err
+		}
+		env["TF_REATTACH_PROVIDERS"] = string(reattachStr)
+	}
+
+	ret := make([]string, 0, len(env))
+	for k, v := range env {
+		ret = append(ret, k+"="+v)
+	}
+	sort.Strings(ret)
+	return ret, nil
+}
+
+// terraformExecUserAgent returns the value of TF_APPEND_USER_AGENT that
+// terraform-exec passes to Terraform: the value in the current environment,
+// followed by terraform-exec's own user agent.
+func terraformExecUserAgent() string {
+	ua := strings.TrimSpace(os.Getenv("TF_APPEND_USER_AGENT"))
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return ua
+	}
+	for _, dep := range info.Deps {
+		if dep.Path != "github.com/hashicorp/terraform-exec" {
+			continue
+		}
+		own := "HashiCorp-terraform-exec/" + strings.TrimPrefix(dep.Version, "v")
+		if ua == "" || ua == own {
+			return own
+		}
+		return ua + " " + own
+	}
+	return ua
+}
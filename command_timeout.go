@@ -0,0 +1,56 @@
+package tftest
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// SetCommandTimeout sets the longest time that each Terraform command which
+// can call into providers, such as init, plan, apply, destroy, refresh and
+// import, may run in the working directory before it is killed, so that a
+// stuck provider or remote API can't hang the whole test run. Pass zero to
+// remove the limit, which is the default.
+//
+// When a command times out, Terraform and any provider processes it started
+// are killed immediately, so any remote objects created by the command may
+// not have been recorded in the state. The command then returns a
+// *TerraformError wrapping a *CommandTimeoutError.
+//
+// While a timeout is set, these commands run Terraform directly rather than
+// via terraform-exec, in the same way as their Context variants.
+func (wd *WorkingDir) SetCommandTimeout(timeout time.Duration) {
+	wd.commandTimeout = timeout
+}
+
+// CommandTimeoutError is the error returned by a command that was killed
+// because it exceeded the timeout set with SetCommandTimeout. It is always
+// wrapped in a *TerraformError.
+type CommandTimeoutError struct {
+	// Command is the Terraform subcommand that timed out, such as "apply".
+	Command string
+
+	// Timeout is the timeout that was exceeded.
+	Timeout time.Duration
+
+	// Stderr is whatever the command wrote to stderr before it was killed.
+	Stderr string
+}
+
+func (e *CommandTimeoutError) Error() string {
+	return fmt.Sprintf("timed out after %s\n\n%s", e.Timeout, e.Stderr)
+}
+
+// Unwrap returns context.DeadlineExceeded, so that a timeout can be
+// recognized in the same way as for a command whose context expired.
+func (e *CommandTimeoutError) Unwrap() error {
+	return context.DeadlineExceeded
+}
+
+// runDirect returns true if commands which usually run via terraform-exec
+// must instead run Terraform directly, because terraform-exec can't enforce
+// the working directory's command timeout or resource limits, or run the
+// command wrapper.
+func (wd *WorkingDir) runDirect() bool {
+	return wd.commandTimeout > 0 || !wd.resourceLimits.isZero() || len(wd.commandWrapper) > 0
+}
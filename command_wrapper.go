@@ -0,0 +1,90 @@
+package tftest
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// WrapperOutputPlaceholder is replaced in the arguments given to
+// SetCommandWrapper with the path of a new file for each command, whose
+// content is then recorded in the command history as Command.WrapperOutput.
+const WrapperOutputPlaceholder = "{output}"
+
+// SetCommandWrapper sets a command line that is prefixed to each Terraform
+// command run in the working directory from now on, such as a profiler, a
+// syscall tracer or a sandboxing tool. Each command runs the given
+// executable with the given arguments followed by the path of the Terraform
+// executable and the Terraform command's own arguments. Call with no
+// arguments to remove the wrapper, which is the default.
+//
+// Anything the wrapper writes to stdout or stderr is captured along with
+// Terraform's output, so a wrapper that reports on stdout, or on stderr
+// when its report may be mistaken for diagnostics, should be told to write
+// to the file named by WrapperOutputPlaceholder instead, for example
+//
+//	wd.SetCommandWrapper("strace", "-f", "-o", tftest.WrapperOutputPlaceholder)
+//
+// Only commands that run Terraform directly rather than via terraform-exec
+// are wrapped. While a wrapper is set, that includes the commands which can
+// call into providers, such as plan and apply, in the same way as their
+// Context variants, along with the commands that always run Terraform
+// directly, such as Taint or Graph. The other commands, which only read the
+// state or a plan via terraform-exec, run without the wrapper.
+//
+// An interrupt, such as when the context given to ApplyContext is cancelled,
+// is sent to the wrapper's whole process group, so Terraform receives it even
+// if the wrapper doesn't forward signals. The ExitCode of a TerraformError
+// from a wrapped command is the wrapper's exit status, which is Terraform's
+// only if the wrapper passes it through, as strace and time do.
+func (wd *WorkingDir) SetCommandWrapper(command ...string) {
+	wd.commandWrapper = append([]string(nil), command...)
+}
+
+// SetCommandWrapper sets the command wrapper for the working directories
+// created by the helper from now on, as described for
+// WorkingDir.SetCommandWrapper.
+func (h *Helper) SetCommandWrapper(command ...string) {
+	h.commandWrapper = append([]string(nil), command...)
+}
+
+// wrapCommand returns the command to run Terraform with the given arguments,
+// under the working directory's command wrapper if any. The returned
+// function must be called once the command has completed, to read and
+// remove the wrapper's output file.
+func (wd *WorkingDir) wrapCommand(args []string) (*exec.Cmd, func(), error) {
+	if len(wd.commandWrapper) == 0 {
+		return exec.Command(wd.terraformExec, args...), func() {}, nil
+	}
+
+	outputPath := ""
+	wrapperArgs := make([]string, 0, len(wd.commandWrapper)+len(args))
+	for _, arg := range wd.commandWrapper[1:] {
+		if strings.Contains(arg, WrapperOutputPlaceholder) {
+			if outputPath == "" {
+				f, err := ioutil.TempFile("", "tftest-wrapper")
+				if err != nil {
+					return nil, nil, err
+				}
+				f.Close()
+				outputPath = f.Name()
+			}
+			arg = strings.Replace(arg, WrapperOutputPlaceholder, outputPath, -1)
+		}
+		wrapperArgs = append(wrapperArgs, arg)
+	}
+	wrapperArgs = append(wrapperArgs, wd.terraformExec)
+	wrapperArgs = append(wrapperArgs, args...)
+
+	finish := func() {
+		if outputPath == "" {
+			return
+		}
+		if out, err := ioutil.ReadFile(outputPath); err == nil {
+			wd.wrapperOutput += normalizeOutputEncoding(out)
+		}
+		os.Remove(outputPath)
+	}
+	return exec.Command(wd.commandWrapper[0], wrapperArgs...), finish, nil
+}
@@ -0,0 +1,270 @@
+package tftest
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// CompareOptions customizes how Compare decides whether two values are
+// equivalent, so that comparisons can tolerate attributes which legitimately
+// differ between otherwise-equivalent objects.
+//
+// The attribute paths given in the options are sequences of attribute names,
+// map keys and list indices separated by periods, such as "tags.Name" or
+// "disk.0.size". A step of "*" matches any single attribute, key or index.
+type CompareOptions struct {
+	// Ignore lists paths whose values are not compared at all, along with
+	// everything nested beneath them.
+	Ignore []string
+
+	// Normalize lists rewrites applied to string values on both sides
+	// before they are compared, such as to replace timestamps with a fixed
+	// placeholder.
+	Normalize []Normalization
+
+	// Unordered lists paths of lists whose elements may appear in any order,
+	// which are therefore compared as sets.
+	Unordered []string
+}
+
+// Normalization describes a rewrite applied to string values by Compare.
+type Normalization struct {
+	// Path restricts the rewrite to the values at a particular path,
+	// matched as for CompareOptions.Ignore. If empty, the rewrite applies to
+	// all string values.
+	Path string
+
+	// Pattern is matched against each string value, and each match is
+	// replaced with Replacement, as for regexp.ReplaceAllString.
+	Pattern     *regexp.Regexp
+	Replacement string
+}
+
+// Difference describes a single difference found by Compare.
+type Difference struct {
+	// Path is the path of the differing value, or an empty string if the
+	// top-level values themselves differ.
+	Path string
+
+	// Want and Got are the differing values, after normalization. One of
+	// them is nil if the value is present on only one side.
+	Want, Got interface{}
+}
+
+func (d Difference) String() string {
+	path := d.Path
+	if path == "" {
+		path = "value"
+	}
+	want, _ := json.Marshal(d.Want)
+	got, _ := json.Marshal(d.Got)
+	return fmt.Sprintf("%s: expected %s, got %s", path, want, got)
+}
+
+// Compare compares two values, such as the attribute values of two resource
+// instances, returning all of the differences between them, sorted by path.
+// The result is empty if the values are equivalent.
+//
+// The values are compared as they would be encoded to JSON, so for example an
+// int is equivalent to the equal number decoded from Terraform's JSON output.
+// opts may be nil to compare the values exactly.
+func Compare(want, got interface{}, opts *CompareOptions) ([]Difference, error) {
+	if opts == nil {
+		opts = &CompareOptions{}
+	}
+
+	want, err := normalizeJSON(want)
+	if err != nil {
+		return nil, fmt.Errorf("invalid expected value: %s", err)
+	}
+	got, err = normalizeJSON(got)
+	if err != nil {
+		return nil, fmt.Errorf("invalid actual value: %s", err)
+	}
+
+	c := comparer{opts: opts}
+	c.compare(nil, want, got)
+	sort.Slice(c.diffs, func(i, j int) bool {
+		return c.diffs[i].Path < c.diffs[j].Path
+	})
+	return c.diffs, nil
+}
+
+// RequireEquivalent is a variant of Compare that will fail the test via the
+// given TestControl, listing all of the differences, if the values are not
+// equivalent.
+func RequireEquivalent(t TestControl, want, got interface{}, opts *CompareOptions) {
+	t.Helper()
+	diffs, err := Compare(want, got, opts)
+	if err != nil {
+		t := testingT{t}
+		t.Fatalf("failed to compare values: %s", err)
+		return
+	}
+	if len(diffs) > 0 {
+		lines := make([]string, len(diffs))
+		for i, d := range diffs {
+			lines[i] = "  " + d.String()
+		}
+		t := testingT{t}
+		t.Fatalf("values are not equivalent:\n%s", strings.Join(lines, "\n"))
+	}
+}
+
+// normalizeJSON round-trips the given value through JSON, so that it has the
+// same dynamic types as values decoded from Terraform's JSON output.
+func normalizeJSON(v interface{}) (interface{}, error) {
+	src, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var ret interface{}
+	err = json.Unmarshal(src, &ret)
+	return ret, err
+}
+
+type comparer struct {
+	opts  *CompareOptions
+	diffs []Difference
+}
+
+func (c *comparer) compare(path []string, want, got interface{}) {
+	if matchesAnyPath(c.opts.Ignore, path) {
+		return
+	}
+	want, got = c.normalize(path, want), c.normalize(path, got)
+
+	switch tw := want.(type) {
+	case map[string]interface{}:
+		tg, ok := got.(map[string]interface{})
+		if !ok {
+			break
+		}
+		keys := map[string]struct{}{}
+		for k := range tw {
+			keys[k] = struct{}{}
+		}
+		for k := range tg {
+			keys[k] = struct{}{}
+		}
+		for k := range keys {
+			c.compare(appendPath(path, k), tw[k], tg[k])
+		}
+		return
+
+	case []interface{}:
+		tg, ok := got.([]interface{})
+		if !ok {
+			break
+		}
+		if matchesAnyPath(c.opts.Unordered, path) {
+			c.compareUnordered(path, tw, tg)
+			return
+		}
+		for i := 0; i < len(tw) || i < len(tg); i++ {
+			var we, ge interface{}
+			if i < len(tw) {
+				we = tw[i]
+			}
+			if i < len(tg) {
+				ge = tg[i]
+			}
+			c.compare(appendPath(path, strconv.Itoa(i)), we, ge)
+		}
+		return
+	}
+
+	if !reflect.DeepEqual(want, got) {
+		c.diffs = append(c.diffs, Difference{
+			Path: strings.Join(path, "."),
+			Want: want,
+			Got:  got,
+		})
+	}
+}
+
+// compareUnordered compares two lists as sets, pairing each expected element
+// with an equivalent actual element if there is one.
+func (c *comparer) compareUnordered(path []string, want, got []interface{}) {
+	used := make([]bool, len(got))
+	unmatched := []interface{}{}
+	for i, we := range want {
+		found := false
+		for j, ge := range got {
+			if used[j] {
+				continue
+			}
+			sub := comparer{opts: c.opts}
+			sub.compare(appendPath(path, strconv.Itoa(i)), we, ge)
+			if len(sub.diffs) == 0 {
+				used[j] = true
+				found = true
+				break
+			}
+		}
+		if !found {
+			unmatched = append(unmatched, we)
+		}
+	}
+
+	extra := []interface{}{}
+	for j, ge := range got {
+		if !used[j] {
+			extra = append(extra, ge)
+		}
+	}
+	if len(unmatched) > 0 || len(extra) > 0 {
+		c.diffs = append(c.diffs, Difference{
+			Path: strings.Join(path, "."),
+			Want: unmatched,
+			Got:  extra,
+		})
+	}
+}
+
+func (c *comparer) normalize(path []string, v interface{}) interface{} {
+	s, ok := v.(string)
+	if !ok {
+		return v
+	}
+	for _, n := range c.opts.Normalize {
+		if n.Path != "" && !matchesPath(n.Path, path) {
+			continue
+		}
+		s = n.Pattern.ReplaceAllString(s, n.Replacement)
+	}
+	return s
+}
+
+func appendPath(path []string, step string) []string {
+	ret := make([]string, len(path), len(path)+1)
+	copy(ret, path)
+	return append(ret, step)
+}
+
+func matchesAnyPath(patterns []string, path []string) bool {
+	for _, pattern := range patterns {
+		if matchesPath(pattern, path) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchesPath(pattern string, path []string) bool {
+	steps := strings.Split(pattern, ".")
+	if len(steps) != len(path) {
+		return false
+	}
+	for i, step := range steps {
+		if step != "*" && step != path[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -14,41 +14,141 @@ import (
 // DiscoverConfig, but this is exposed so that more complex scenarios can be
 // implemented by direct configuration.
 type Config struct {
+	// SourceDir is the directory containing the source code under test,
+	// whose subdirectories, such as testdata, are linked into each working
+	// directory. It may be empty, for example when the helper is used to
+	// test modules, backends or provisioners rather than a provider, in
+	// which case no provider needs to be installed either.
 	SourceDir          string
 	TerraformExec      string
 	execTempDir        string
 	PreviousPluginExec string
+
+	// RequireVerifiedTerraform makes InitHelper fail unless TerraformExec
+	// was downloaded from releases.hashicorp.com and its checksum and GPG
+	// signature were verified, as DiscoverConfig and InstallTerraform do.
+	// An executable found locally, such as in PATH, can't be verified.
+	RequireVerifiedTerraform bool
+
+	// terraformVerified records that TerraformExec was downloaded and
+	// verified
+	terraformVerified bool
+
+	// PluginVersions are the versions under which the auxiliary provider
+	// plugins found in TF_ACC_PROVIDER_ROOT_DIR are installed for Terraform
+	// versions before 0.13, which discover plugins by filenames of the form
+	// terraform-provider-NAME_vX.Y.Z. Each plugin is installed once per
+	// version. If empty, the plugins are installed without a version suffix.
+	PluginVersions []string
+
+	// PluginInstallStrategy selects whether provider plugin binaries are
+	// symlinked or copied into each working directory, as described for
+	// PluginInstallStrategy. DiscoverConfig sets it to PluginInstallCopy if
+	// the environment variable TF_ACC_PLUGIN_COPY is set.
+	PluginInstallStrategy PluginInstallStrategy
+
+	// LegacyTerraform allows TerraformExec to be Terraform v0.11, which is
+	// otherwise rejected, as described for Helper.LegacyMode.
+	// DiscoverConfig sets it if the environment variable
+	// TF_ACC_TERRAFORM_LEGACY is set.
+	LegacyTerraform bool
 }
 
 // DiscoverConfig uses environment variables and other means to automatically
 // discover a reasonable test helper configuration.
+//
+// If the environment variable TF_ACC_TERRAFORM_REQUIRE_VERIFIED is set then
+// the resulting configuration has RequireVerifiedTerraform set, and the
+// Terraform CLI is always downloaded, rather than taken from PATH, unless
+// TF_ACC_TERRAFORM_PATH is also set.
 func DiscoverConfig(sourceDir string) (*Config, error) {
 	tfVersion := os.Getenv("TF_ACC_TERRAFORM_VERSION")
 	tfPath := os.Getenv("TF_ACC_TERRAFORM_PATH")
+	requireVerified := os.Getenv("TF_ACC_TERRAFORM_REQUIRE_VERIFIED") != ""
 
 	tempDir := os.Getenv("TF_ACC_TEMP_DIR")
 	tfDir, err := ioutil.TempDir(tempDir, "tftest-terraform")
 	if err != nil {
 		return nil, fmt.Errorf("failed to create temp dir: %w", err)
 	}
+	err = writeOwnerMarker(tfDir, "")
+	if err != nil {
+		os.RemoveAll(tfDir)
+		return nil, err
+	}
+	config, err := discoverTerraform(sourceDir, tfDir, tfVersion, tfPath, requireVerified)
+	if err != nil {
+		os.RemoveAll(tfDir)
+		return nil, err
+	}
+	if os.Getenv("TF_ACC_PLUGIN_COPY") != "" {
+		config.PluginInstallStrategy = PluginInstallCopy
+	}
+	config.LegacyTerraform = os.Getenv("TF_ACC_TERRAFORM_LEGACY") != ""
+	return config, nil
+}
 
-	finders := []tfinstall.ExecPathFinder{}
+// discoverTerraform implements DiscoverConfig, installing Terraform CLI into
+// tfDir if necessary.
+func discoverTerraform(sourceDir, tfDir, tfVersion, tfPath string, requireVerified bool) (*Config, error) {
+	var tfExec string
+	var verified bool
+	var err error
 	switch {
 	case tfPath != "":
-		finders = append(finders, tfinstall.ExactPath(tfPath))
+		tfExec, err = tfinstall.Find(context.Background(), tfinstall.ExactPath(tfPath))
 	case tfVersion != "":
-		finders = append(finders, tfinstall.ExactVersion(tfVersion, tfDir))
+		tfExec, verified, err = installTerraformCached(tfVersion, tfDir)
 	default:
-		finders = append(finders, tfinstall.LookPath(), tfinstall.LatestVersion(tfDir, true))
+		if !requireVerified {
+			tfExec, err = tfinstall.Find(context.Background(), tfinstall.LookPath())
+		}
+		if tfExec == "" {
+			// tfinstall verifies everything it downloads
+			tfExec, err = tfinstall.Find(context.Background(), tfinstall.LatestVersion(tfDir, true))
+			verified = err == nil
+		}
 	}
-	tfExec, err := tfinstall.Find(context.Background(), finders...)
 	if err != nil {
 		return nil, err
 	}
 
 	return &Config{
-		SourceDir:     sourceDir,
-		TerraformExec: tfExec,
-		execTempDir:   tfDir,
+		SourceDir:                sourceDir,
+		TerraformExec:            tfExec,
+		execTempDir:              tfDir,
+		RequireVerifiedTerraform: requireVerified,
+		terraformVerified:        verified,
+	}, nil
+}
+
+// InstallTerraform downloads the official release of the given version of
+// Terraform CLI for the current platform from releases.hashicorp.com,
+// verifying its checksums and signature, unpacks it into the given directory,
+// and returns the path of the executable.
+//
+// DiscoverConfig does this automatically if TF_ACC_TERRAFORM_VERSION is set,
+// so this is needed only by test programs that construct their own Config,
+// for example to test against several Terraform versions in one run.
+//
+// To use the result with RequireVerifiedTerraform, construct the Config with
+// NewVerifiedConfig.
+func InstallTerraform(version, dir string) (string, error) {
+	return tfinstall.Find(context.Background(), tfinstall.ExactVersion(version, dir))
+}
+
+// NewVerifiedConfig downloads and verifies the given version of Terraform CLI
+// into dir, as described for InstallTerraform, and returns a configuration
+// which uses it and has RequireVerifiedTerraform set.
+func NewVerifiedConfig(sourceDir, version, dir string) (*Config, error) {
+	tfExec, err := InstallTerraform(version, dir)
+	if err != nil {
+		return nil, err
+	}
+	return &Config{
+		SourceDir:                sourceDir,
+		TerraformExec:            tfExec,
+		RequireVerifiedTerraform: true,
+		terraformVerified:        true,
 	}, nil
 }
@@ -0,0 +1,114 @@
+package tftest
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/go-version"
+)
+
+// ConfigDirModuleName is the name of the module call through which
+// SetConfigDir includes a configuration directory.
+const ConfigDirModuleName = "under_test"
+
+// moduleOutputsVersion is the first version of Terraform that can refer to
+// all of a module's outputs as a single object.
+var moduleOutputsVersion = version.Must(version.NewVersion("0.13.0"))
+
+// SetConfigDir sets the configuration for the working directory to be the
+// module in the given directory, which is used in place rather than copied,
+// so that provider repositories can test their published example modules
+// directly. The state, plugins and saved plans still live in the working
+// directory. Relative module sources within the directory work as usual.
+//
+// The directory is called as a child module named by ConfigDirModuleName,
+// so the addresses of its resources in plans and state have the prefix
+// "module.under_test.". The given inputs, if any, are passed as the module's
+// input variables, and must be values that can be encoded as JSON. With
+// Terraform v0.13 and later, the module's outputs are also available as the
+// single sensitive root module output named by ConfigDirModuleName.
+//
+// This otherwise behaves like SetConfig.
+func (wd *WorkingDir) SetConfigDir(dir string, inputs map[string]interface{}) error {
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return err
+	}
+	if info, err := os.Stat(abs); err != nil {
+		return err
+	} else if !info.IsDir() {
+		return fmt.Errorf("%s is not a directory", dir)
+	}
+
+	// Terraform uses only local paths starting with ./ or ../ in place. It
+	// treats others, including absolute paths, as remote sources to be
+	// copied into the working directory.
+	source := abs
+	if rel, err := filepath.Rel(wd.baseDir, abs); err == nil {
+		source = rel
+		if !strings.HasPrefix(rel, "..") {
+			source = "./" + rel
+		}
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "module %q {\n", ConfigDirModuleName)
+	fmt.Fprintf(&b, "  source = %s\n", hclLiteral(filepath.ToSlash(source)))
+	names := make([]string, 0, len(inputs))
+	for name := range inputs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		lit, err := hclValue(inputs[name])
+		if err != nil {
+			return fmt.Errorf("invalid value for input %q: %s", name, err)
+		}
+		fmt.Fprintf(&b, "  %s = %s\n", name, lit)
+	}
+	b.WriteString("}\n")
+	if !wd.h.terraformVersion.LessThan(moduleOutputsVersion) {
+		fmt.Fprintf(&b, "\noutput %q {\n  value     = module.%s\n  sensitive = true\n}\n", ConfigDirModuleName, ConfigDirModuleName)
+	}
+
+	return wd.SetConfig(b.String())
+}
+
+// RequireSetConfigDir is a variant of SetConfigDir that will fail the test
+// via the given TestControl if the configuration cannot be set.
+func (wd *WorkingDir) RequireSetConfigDir(t TestControl, dir string, inputs map[string]interface{}) {
+	t.Helper()
+	if err := wd.SetConfigDir(dir, inputs); err != nil {
+		t := testingT{t}
+		t.Fatalf("failed to set config: %s", err)
+	}
+}
+
+// hclValue returns a Terraform language expression for the given value,
+// which must be encodable as JSON. The JSON encoding is itself valid syntax
+// for tuple and object constructors and literals, apart from the template
+// sequences in strings, which are escaped.
+func hclValue(v interface{}) (string, error) {
+	src, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	return escapeTemplateSequences(string(src)), nil
+}
+
+// hclLiteral returns a Terraform language string literal for s.
+func hclLiteral(s string) string {
+	lit, _ := hclValue(s)
+	return lit
+}
+
+// escapeTemplateSequences escapes the interpolation and directive sequences
+// in the given quoted strings, so that they are taken literally.
+func escapeTemplateSequences(s string) string {
+	s = strings.Replace(s, "${", "$${", -1)
+	return strings.Replace(s, "%{", "%%{", -1)
+}
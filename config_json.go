@@ -0,0 +1,193 @@
+package tftest
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/hashicorp/go-version"
+)
+
+// ConfigFormatVersion is the version of the JSON representation of Config
+// produced by Config.MarshalJSON and accepted by LoadConfig. It changes only
+// if the representation changes incompatibly.
+const ConfigFormatVersion = "1"
+
+// configJSON is the JSON representation of Config.
+type configJSON struct {
+	FormatVersion            string   `json:"format_version"`
+	SourceDir                string   `json:"source_dir,omitempty"`
+	TerraformExec            string   `json:"terraform_exec"`
+	PreviousPluginExec       string   `json:"previous_plugin_exec,omitempty"`
+	RequireVerifiedTerraform bool     `json:"require_verified_terraform,omitempty"`
+	PluginVersions           []string `json:"plugin_versions,omitempty"`
+	PluginInstallStrategy    string   `json:"plugin_install_strategy,omitempty"`
+	LegacyTerraform          bool     `json:"legacy_terraform,omitempty"`
+}
+
+var pluginInstallStrategyNames = map[PluginInstallStrategy]string{
+	PluginInstallDefault: "default",
+	PluginInstallSymlink: "symlink",
+	PluginInstallCopy:    "copy",
+}
+
+// String returns the name of the strategy used in the JSON representation of
+// Config: "default", "symlink" or "copy".
+func (s PluginInstallStrategy) String() string {
+	if name, ok := pluginInstallStrategyNames[s]; ok {
+		return name
+	}
+	return fmt.Sprintf("PluginInstallStrategy(%d)", int(s))
+}
+
+// parsePluginInstallStrategy returns the strategy with the given name, where
+// the empty string is the default.
+func parsePluginInstallStrategy(name string) (PluginInstallStrategy, error) {
+	if name == "" {
+		return PluginInstallDefault, nil
+	}
+	for s, n := range pluginInstallStrategyNames {
+		if n == name {
+			return s, nil
+		}
+	}
+	return 0, fmt.Errorf("invalid plugin_install_strategy %q: must be \"default\", \"symlink\" or \"copy\"", name)
+}
+
+// MarshalJSON returns the JSON representation of the configuration, which is
+// an object with the following properties, of which only terraform_exec is
+// required by LoadConfig:
+//
+//	format_version             always ConfigFormatVersion
+//	source_dir                 Config.SourceDir
+//	terraform_exec             Config.TerraformExec
+//	previous_plugin_exec       Config.PreviousPluginExec
+//	require_verified_terraform Config.RequireVerifiedTerraform
+//	plugin_versions            Config.PluginVersions
+//	plugin_install_strategy    Config.PluginInstallStrategy, by name
+//	legacy_terraform           Config.LegacyTerraform
+//
+// This allows external tools, such as release pipelines generating test
+// matrices, to produce configurations for LoadConfig.
+func (c Config) MarshalJSON() ([]byte, error) {
+	if _, ok := pluginInstallStrategyNames[c.PluginInstallStrategy]; !ok {
+		return nil, fmt.Errorf("invalid plugin install strategy %s", c.PluginInstallStrategy)
+	}
+	return json.Marshal(configJSON{
+		FormatVersion:            ConfigFormatVersion,
+		SourceDir:                c.SourceDir,
+		TerraformExec:            c.TerraformExec,
+		PreviousPluginExec:       c.PreviousPluginExec,
+		RequireVerifiedTerraform: c.RequireVerifiedTerraform,
+		PluginVersions:           c.PluginVersions,
+		PluginInstallStrategy:    c.PluginInstallStrategy.String(),
+		LegacyTerraform:          c.LegacyTerraform,
+	})
+}
+
+// UnmarshalJSON decodes the JSON representation of a configuration described
+// for MarshalJSON, rejecting unknown properties.
+func (c *Config) UnmarshalJSON(src []byte) error {
+	dec := json.NewDecoder(bytes.NewReader(src))
+	dec.DisallowUnknownFields()
+	var raw configJSON
+	if err := dec.Decode(&raw); err != nil {
+		return err
+	}
+	if raw.FormatVersion != ConfigFormatVersion {
+		return fmt.Errorf("unsupported format_version %q: must be %q", raw.FormatVersion, ConfigFormatVersion)
+	}
+	strategy, err := parsePluginInstallStrategy(raw.PluginInstallStrategy)
+	if err != nil {
+		return err
+	}
+
+	*c = Config{
+		SourceDir:                raw.SourceDir,
+		TerraformExec:            raw.TerraformExec,
+		PreviousPluginExec:       raw.PreviousPluginExec,
+		RequireVerifiedTerraform: raw.RequireVerifiedTerraform,
+		PluginVersions:           raw.PluginVersions,
+		PluginInstallStrategy:    strategy,
+		LegacyTerraform:          raw.LegacyTerraform,
+	}
+	return nil
+}
+
+// Validate returns an error describing every problem with the configuration
+// that would prevent InitHelper from using it, without running Terraform.
+func (c *Config) Validate() error {
+	var problems []string
+	checkFile := func(field, path string, wantDir bool) {
+		info, err := os.Stat(path)
+		switch {
+		case err != nil:
+			problems = append(problems, fmt.Sprintf("%s: %s", field, err))
+		case wantDir && !info.IsDir():
+			problems = append(problems, fmt.Sprintf("%s: %s is not a directory", field, path))
+		case !wantDir && info.IsDir():
+			problems = append(problems, fmt.Sprintf("%s: %s is a directory", field, path))
+		}
+	}
+
+	if c.SourceDir != "" {
+		checkFile("SourceDir", c.SourceDir, true)
+	}
+	if c.TerraformExec == "" {
+		problems = append(problems, "TerraformExec: must be set")
+	} else {
+		checkFile("TerraformExec", c.TerraformExec, false)
+	}
+	if c.PreviousPluginExec != "" {
+		checkFile("PreviousPluginExec", c.PreviousPluginExec, false)
+	}
+	if c.RequireVerifiedTerraform && !c.terraformVerified {
+		problems = append(problems, "RequireVerifiedTerraform: the Terraform CLI executable was not downloaded and verified by this package")
+	}
+	for _, v := range c.PluginVersions {
+		if _, err := version.NewVersion(v); err != nil {
+			problems = append(problems, fmt.Sprintf("PluginVersions: %s", err))
+		}
+	}
+	if _, ok := pluginInstallStrategyNames[c.PluginInstallStrategy]; !ok {
+		problems = append(problems, fmt.Sprintf("PluginInstallStrategy: invalid value %d", int(c.PluginInstallStrategy)))
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("invalid helper configuration:\n  %s", strings.Join(problems, "\n  "))
+	}
+	return nil
+}
+
+// LoadConfig reads a configuration in the JSON representation described for
+// Config.MarshalJSON from the file at the given path, and validates it using
+// Config.Validate. Relative paths in the file are relative to the directory
+// containing it.
+//
+// A loaded configuration can't have RequireVerifiedTerraform set, because
+// the executable it refers to wasn't verified by this package.
+func LoadConfig(path string) (*Config, error) {
+	src, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read helper configuration: %s", err)
+	}
+	config := &Config{}
+	if err := json.Unmarshal(src, config); err != nil {
+		return nil, fmt.Errorf("invalid helper configuration %s: %s", path, err)
+	}
+
+	base := filepath.Dir(path)
+	for _, p := range []*string{&config.SourceDir, &config.TerraformExec, &config.PreviousPluginExec} {
+		if *p != "" && !filepath.IsAbs(*p) {
+			*p = filepath.Join(base, *p)
+		}
+	}
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
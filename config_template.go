@@ -0,0 +1,117 @@
+package tftest
+
+import (
+	"fmt"
+	"strings"
+	"text/template"
+)
+
+// SetTemplateValue registers a value available by the given name to the
+// configuration templates of every working directory the helper creates, as
+// described for WorkingDir.SetConfigTemplate. This allows values shared by
+// all tests, such as the URL of a backend, to be declared once in TestMain.
+func (h *Helper) SetTemplateValue(name string, value interface{}) {
+	h.templateMu.Lock()
+	defer h.templateMu.Unlock()
+	if h.templateValues == nil {
+		h.templateValues = map[string]interface{}{}
+	}
+	h.templateValues[name] = value
+}
+
+// SetTemplateEndpoint registers a mock endpoint which each working directory
+// starts, using the given function to create it, the first time it renders
+// a configuration template, with the endpoint's URL available to the
+// template by the given name. The endpoint is stopped when the working
+// directory is closed, as with WorkingDir.StartMockEndpoint.
+//
+// This allows the wiring of a mock server that each test needs its own
+// instance of to be declared once in TestMain.
+func (h *Helper) SetTemplateEndpoint(name string, newEndpoint func() MockEndpoint) {
+	h.templateMu.Lock()
+	defer h.templateMu.Unlock()
+	if h.templateEndpoints == nil {
+		h.templateEndpoints = map[string]func() MockEndpoint{}
+	}
+	h.templateEndpoints[name] = newEndpoint
+}
+
+// SetConfigTemplate renders the given text/template template and sets the
+// result as the working directory's configuration, as with SetConfig.
+//
+// The template's data is a map containing the values registered with
+// Helper.SetTemplateValue, the URLs of the endpoints registered with
+// Helper.SetTemplateEndpoint and then the given data, each overriding the
+// ones before, so that a template can refer to them as, for example,
+// {{ .MockEndpoint }}. Referring to a name with no value is an error.
+//
+// The function "hcl" renders any value that can be encoded as JSON as a
+// Terraform language expression, as in "tags = {{ hcl .Tags }}".
+func (wd *WorkingDir) SetConfigTemplate(tmpl string, data map[string]interface{}) error {
+	t, err := template.New(ConfigFileName).
+		Option("missingkey=error").
+		Funcs(template.FuncMap{"hcl": hclValue}).
+		Parse(tmpl)
+	if err != nil {
+		return fmt.Errorf("invalid configuration template: %s", err)
+	}
+
+	values, err := wd.templateData()
+	if err != nil {
+		return err
+	}
+	for k, v := range data {
+		values[k] = v
+	}
+
+	var b strings.Builder
+	if err := t.Execute(&b, values); err != nil {
+		return fmt.Errorf("failed to render configuration template: %s", err)
+	}
+	return wd.SetConfig(b.String())
+}
+
+// RequireSetConfigTemplate is a variant of SetConfigTemplate that will fail
+// the test via the given TestControl if the template cannot be rendered.
+func (wd *WorkingDir) RequireSetConfigTemplate(t TestControl, tmpl string, data map[string]interface{}) {
+	t.Helper()
+	if err := wd.SetConfigTemplate(tmpl, data); err != nil {
+		t := testingT{t}
+		t.Fatalf("failed to set config: %s", err)
+	}
+}
+
+// templateData returns the helper's template values along with the URLs of
+// the working directory's template endpoints, starting any that aren't yet
+// running.
+func (wd *WorkingDir) templateData() (map[string]interface{}, error) {
+	wd.h.templateMu.Lock()
+	values := make(map[string]interface{}, len(wd.h.templateValues)+len(wd.h.templateEndpoints))
+	for k, v := range wd.h.templateValues {
+		values[k] = v
+	}
+	endpoints := make(map[string]func() MockEndpoint, len(wd.h.templateEndpoints))
+	for k, v := range wd.h.templateEndpoints {
+		endpoints[k] = v
+	}
+	wd.h.templateMu.Unlock()
+
+	for name, newEndpoint := range endpoints {
+		url, ok := wd.templateURLs[name]
+		if !ok {
+			endpoint := newEndpoint()
+			var err error
+			url, err = endpoint.Start()
+			if err != nil {
+				return nil, fmt.Errorf("failed to start mock endpoint %s: %s", name, err)
+			}
+			wd.mockEndpoints = append(wd.mockEndpoints, endpoint)
+			if wd.templateURLs == nil {
+				wd.templateURLs = map[string]string{}
+			}
+			wd.templateURLs[name] = url
+		}
+		values[name] = url
+	}
+	return values, nil
+}
@@ -0,0 +1,193 @@
+package tftest
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+)
+
+// ChangeSummary summarizes the changes that an apply or destroy is about to
+// make, for confirmation callbacks registered with SetConfirmation.
+type ChangeSummary struct {
+	// Command is the Terraform subcommand about to run, "apply" or
+	// "destroy".
+	Command string
+
+	// Create, Update and Delete are the number of resource instances to be
+	// created, updated in-place and deleted. A replacement counts as both a
+	// create and a delete.
+	Create, Update, Delete int
+
+	// Addresses are the addresses of all of the resource instances to be
+	// changed.
+	Addresses []string
+}
+
+// Total returns the total number of resource instances to be changed.
+func (s ChangeSummary) Total() int {
+	return len(s.Addresses)
+}
+
+// ConfirmFunc is a callback which decides whether a large apply or destroy
+// may go ahead, returning an error to prevent it.
+type ConfirmFunc func(summary ChangeSummary) error
+
+// SetConfirmation registers a callback to be called before any apply or
+// destroy in the working directory that would change more than the given
+// number of resource instances. If the callback returns an error then the
+// command is not run, and the error is returned from the method that would
+// have run it. Pass a nil callback to remove it.
+//
+// This guards against a mistake, such as a typo in a count, causing an
+// interactive local run to create hundreds of expensive objects. The callback
+// might, for example, prompt on the terminal.
+//
+// To count the changes, Apply and its variants first create a saved plan if
+// there isn't one already, and then apply that plan, removing it afterwards
+// if they created it. Destroy counts all of the managed resource instances in
+// the state, even when the destroy is targeted.
+func (wd *WorkingDir) SetConfirmation(threshold int, confirm ConfirmFunc) {
+	wd.confirmThreshold = threshold
+	wd.confirm = confirm
+}
+
+// SetConfirmation sets the confirmation callback for the working directories
+// created by the helper from now on, as described for
+// WorkingDir.SetConfirmation.
+func (h *Helper) SetConfirmation(threshold int, confirm ConfirmFunc) {
+	h.confirmThreshold = threshold
+	h.confirm = confirm
+}
+
+// planForConfirmation creates a saved plan if a confirmation callback needs
+// one to count the changes an apply would make, returning true if it did.
+// The caller must then clear the plan once it has been applied, since the
+// test didn't ask for it and a later Apply would otherwise find it stale.
+func (wd *WorkingDir) planForConfirmation() (bool, error) {
+	if wd.confirm == nil || wd.HasSavedPlan() {
+		return false, nil
+	}
+	if err := wd.CreatePlan(); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// beforeApply runs the plan gates and confirmation callback, if any, for the
+// plan file at the given path.
+func (wd *WorkingDir) beforeApply(planPath string) error {
+	if err := wd.checkPlanGates(planPath); err != nil {
+		return err
+	}
+	if wd.confirm == nil {
+		return nil
+	}
+
+	summary, err := wd.planChangeSummary(planPath)
+	if err != nil {
+		return err
+	}
+	return wd.checkConfirmation(summary)
+}
+
+// planChangeSummary counts the resource changes in the plan file at the given
+// path.
+func (wd *WorkingDir) planChangeSummary(planPath string) (ChangeSummary, error) {
+	if err := wd.checkJSONOutput("reading the plan"); err != nil {
+		return ChangeSummary{}, err
+	}
+	raw, err := wd.runStdout("show", func() error {
+		return wd.runTerraform(context.Background(), "show", "-json", planPath)
+	})
+	if err != nil {
+		return ChangeSummary{}, err
+	}
+	plan, err := decodePlan([]byte(raw))
+	if err != nil {
+		return ChangeSummary{}, err
+	}
+
+	summary := ChangeSummary{Command: "apply"}
+	for _, rc := range plan.ResourceChanges {
+		if rc.Change == nil {
+			continue
+		}
+		changed := false
+		for _, action := range rc.Change.Actions {
+			switch action {
+			case "create":
+				summary.Create++
+				changed = true
+			case "update":
+				summary.Update++
+				changed = true
+			case "delete":
+				summary.Delete++
+				changed = true
+			}
+		}
+		if changed {
+			summary.Addresses = append(summary.Addresses, rc.Address)
+		}
+	}
+	return summary, nil
+}
+
+// beforeDestroy runs the confirmation callback, if any, for a destroy.
+func (wd *WorkingDir) beforeDestroy() error {
+	if wd.confirm == nil {
+		return nil
+	}
+
+	addresses, err := wd.managedAddresses()
+	if err != nil {
+		return err
+	}
+
+	summary := ChangeSummary{Command: "destroy", Addresses: addresses}
+	summary.Delete = len(summary.Addresses)
+	return wd.checkConfirmation(summary)
+}
+
+// managedAddresses returns the addresses of all of the managed resource
+// instances in the current state.
+func (wd *WorkingDir) managedAddresses() ([]string, error) {
+	raw, err := wd.RawState()
+	if err != nil {
+		return nil, err
+	}
+	var state struct {
+		Values struct {
+			RootModule jsonStateModule `json:"root_module"`
+		} `json:"values"`
+	}
+	err = json.Unmarshal(raw, &state)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode state: %s", err)
+	}
+
+	var ret []string
+	modules := []jsonStateModule{state.Values.RootModule}
+	for len(modules) > 0 {
+		module := modules[0]
+		modules = append(modules[1:], module.ChildModules...)
+		for _, r := range module.Resources {
+			if r.Mode == "managed" {
+				ret = append(ret, r.Address)
+			}
+		}
+	}
+	return ret, nil
+}
+
+// checkConfirmation calls the confirmation callback if the summary exceeds
+// the threshold.
+func (wd *WorkingDir) checkConfirmation(summary ChangeSummary) error {
+	if summary.Total() <= wd.confirmThreshold {
+		return nil
+	}
+	if err := wd.confirm(summary); err != nil {
+		return fmt.Errorf("%s of %d resource instances was not confirmed: %w", summary.Command, summary.Total(), err)
+	}
+	return nil
+}
@@ -0,0 +1,45 @@
+package tftest
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// Eval evaluates the given expression with "terraform console" against the
+// configuration and the current state of the working directory, and returns
+// the value as Terraform renders it, such as `"foo"` for a string or
+// `tolist([...])` for a list, without the trailing newline.
+//
+// This allows testing provider-defined functions and interpolation behavior
+// against real resources without adding outputs to the configuration.
+//
+// The expression must be on a single line, since each line of input is
+// evaluated separately. If it is invalid or cannot be evaluated, Eval returns
+// a *TerraformError with the diagnostics.
+func (wd *WorkingDir) Eval(expr string) (string, error) {
+	if strings.ContainsAny(expr, "\r\n") {
+		return "", fmt.Errorf("expression to evaluate must be on a single line")
+	}
+	out, err := wd.runStdout("console", func() error {
+		wd.runStdinR = strings.NewReader(expr + "\n")
+		defer func() { wd.runStdinR = nil }()
+		return wd.runTerraform(context.Background(), "console", "-no-color")
+	})
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(out, "\r\n"), nil
+}
+
+// RequireEval is a variant of Eval that will fail the test via the given
+// TestControl if the expression cannot be evaluated.
+func (wd *WorkingDir) RequireEval(t TestControl, expr string) string {
+	t.Helper()
+	ret, err := wd.Eval(expr)
+	if err != nil {
+		t := testingT{t}
+		t.Fatalf("failed to evaluate %s: %s", expr, err)
+	}
+	return ret
+}
@@ -0,0 +1,191 @@
+package tftest
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+)
+
+// The methods in this file are variants of the WorkingDir commands which run
+// Terraform until the given context is cancelled, for example a context whose
+// deadline is set a little before that of the test, so that a hanging command
+// can be stopped before "go test" kills the whole test binary.
+//
+// When the context is cancelled, Terraform is interrupted and given the
+// opportunity to finish persisting state for any objects it already created,
+// so that they can still be destroyed afterwards. The command then returns an
+// error wrapping the context's error.
+
+// InitContext is a variant of Init which interrupts Terraform when the given
+// context is cancelled.
+func (wd *WorkingDir) InitContext(ctx context.Context) error {
+	if _, err := os.Stat(wd.configFilename()); err != nil {
+		return fmt.Errorf("must call SetConfig before Init")
+	}
+	if wd.initCurrent() {
+		return nil
+	}
+	if err := wd.checkBackend(); err != nil {
+		return err
+	}
+
+	err := wd.run("init", func() error {
+		return wd.runTerraform(ctx, "init", "-no-color", "-input=false")
+	})
+	if err == nil {
+		wd.markInitialized()
+	}
+	return err
+}
+
+// RequireInitContext is a variant of InitContext that will fail the test via
+// the given TestControl if init fails.
+func (wd *WorkingDir) RequireInitContext(ctx context.Context, t TestControl) {
+	t.Helper()
+	if err := wd.InitContext(ctx); err != nil {
+		t := testingT{t}
+		t.Fatalf("init failed: %s", err)
+	}
+}
+
+// CreatePlanContext is a variant of CreatePlan which interrupts Terraform
+// when the given context is cancelled.
+func (wd *WorkingDir) CreatePlanContext(ctx context.Context) error {
+	return wd.runPlan(func() error {
+		return wd.runTerraform(ctx, "plan", "-no-color", "-input=false", "-refresh=false", "-out="+PlanFileName)
+	})
+}
+
+// RequireCreatePlanContext is a variant of CreatePlanContext that will fail
+// the test via the given TestControl if plan creation fails.
+func (wd *WorkingDir) RequireCreatePlanContext(ctx context.Context, t TestControl) {
+	t.Helper()
+	if err := wd.CreatePlanContext(ctx); err != nil {
+		t := testingT{t}
+		t.Fatalf("failed to create plan: %s", err)
+	}
+}
+
+// CreateDestroyPlanContext is a variant of CreateDestroyPlan which interrupts
+// Terraform when the given context is cancelled.
+func (wd *WorkingDir) CreateDestroyPlanContext(ctx context.Context) error {
+	return wd.runPlan(func() error {
+		return wd.runTerraform(ctx, "plan", "-no-color", "-input=false", "-refresh=false", "-destroy", "-out="+PlanFileName)
+	})
+}
+
+// ApplyContext is a variant of Apply which interrupts Terraform when the
+// given context is cancelled.
+//
+// Unlike ApplyWithTimeout, ApplyContext does not destroy anything after an
+// interruption, so the caller should do so if needed.
+func (wd *WorkingDir) ApplyContext(ctx context.Context) error {
+	implicit, err := wd.planForConfirmation()
+	if err != nil {
+		return err
+	}
+	if implicit {
+		defer wd.ClearPlan()
+	}
+	args := []string{"apply", "-no-color", "-auto-approve", "-input=false", "-refresh=false"}
+	saved := wd.HasSavedPlan()
+	if saved {
+		if err := wd.checkPlanNotApplied(); err != nil {
+			return err
+		}
+		if err := wd.beforeApply(PlanFileName); err != nil {
+			return err
+		}
+		args = append(args, PlanFileName)
+	}
+
+	err = wd.run("apply", func() error {
+		return wd.runTerraform(ctx, args...)
+	})
+	if err == nil && saved {
+		wd.markPlanApplied()
+	}
+	return err
+}
+
+// RequireApplyContext is a variant of ApplyContext that will fail the test
+// via the given TestControl if the apply operation fails.
+func (wd *WorkingDir) RequireApplyContext(ctx context.Context, t TestControl) {
+	t.Helper()
+	if err := wd.ApplyContext(ctx); err != nil {
+		t := testingT{t}
+		t.Fatalf("failed to apply: %s", err)
+	}
+}
+
+// DestroyContext is a variant of Destroy which interrupts Terraform when the
+// given context is cancelled.
+//
+// An interrupted destroy leaves behind any remote objects it had not yet
+// destroyed.
+func (wd *WorkingDir) DestroyContext(ctx context.Context) error {
+	return wd.DestroyWithOptionsContext(ctx, DestroyOptions{})
+}
+
+// DestroyWithOptionsContext is a variant of DestroyWithOptions which
+// interrupts Terraform when the given context is cancelled.
+func (wd *WorkingDir) DestroyWithOptionsContext(ctx context.Context, opts DestroyOptions) error {
+	if err := wd.beforeDestroy(); err != nil {
+		return err
+	}
+	args := []string{"destroy", "-no-color", "-auto-approve", "-input=false", "-refresh=" + strconv.FormatBool(opts.Refresh)}
+	for _, target := range opts.Targets {
+		args = append(args, "-target="+target)
+	}
+
+	return wd.run("destroy", func() error {
+		return wd.runTerraform(ctx, args...)
+	})
+}
+
+// RequireDestroyContext is a variant of DestroyContext that handles any
+// failure according to the working directory's cleanup policy, which by
+// default fails the test via the given TestControl.
+func (wd *WorkingDir) RequireDestroyContext(ctx context.Context, t TestControl) {
+	t.Helper()
+	if err := wd.DestroyContext(ctx); err != nil {
+		wd.handleCleanupFailure(t, "destroy", err)
+	}
+}
+
+// RefreshContext is a variant of Refresh which interrupts Terraform when the
+// given context is cancelled.
+func (wd *WorkingDir) RefreshContext(ctx context.Context) error {
+	return wd.run("refresh", func() error {
+		return wd.runTerraform(ctx, "refresh", "-no-color", "-input=false", "-state="+wd.stateFilename())
+	})
+}
+
+// RequireRefreshContext is a variant of RefreshContext that will fail the
+// test via the given TestControl if the refresh is non successful.
+func (wd *WorkingDir) RequireRefreshContext(ctx context.Context, t TestControl) {
+	t.Helper()
+	if err := wd.RefreshContext(ctx); err != nil {
+		t := testingT{t}
+		t.Fatalf("failed to refresh: %s", err)
+	}
+}
+
+// ImportContext is a variant of Import which interrupts Terraform when the
+// given context is cancelled.
+func (wd *WorkingDir) ImportContext(ctx context.Context, resource, id string) error {
+	return wd.run("import", func() error {
+		return wd.runTerraform(ctx, "import", "-no-color", "-input=false", "-config="+wd.baseDir, resource, id)
+	})
+}
+
+// RequireImportContext is a variant of ImportContext that will fail the test
+// via the given TestControl if the import is non successful.
+func (wd *WorkingDir) RequireImportContext(ctx context.Context, t TestControl, resource, id string) {
+	t.Helper()
+	if err := wd.ImportContext(ctx, resource, id); err != nil {
+		t := testingT{t}
+		t.Fatalf("failed to import: %s", err)
+	}
+}
@@ -0,0 +1,97 @@
+package tftest
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// homeDirName is the name of the directory within a working directory that
+// serves as the home directory for the commands run there, once isolated.
+const homeDirName = ".tftest-home"
+
+// CredentialsFile describes a file, such as a generated service account key,
+// to be staged into the isolated home directory of a working directory.
+type CredentialsFile struct {
+	// Path is the location of the file relative to the home directory,
+	// such as ".aws/credentials".
+	Path string
+
+	// Content is the content of the file.
+	Content []byte
+
+	// EnvVar, if set, is the name of an environment variable that will be
+	// set to the absolute path of the staged file, such as
+	// "GOOGLE_APPLICATION_CREDENTIALS".
+	EnvVar string
+}
+
+// AddCredentialsFile registers a credentials file to be staged into every
+// working directory the helper creates from now on, using
+// WorkingDir.StageCredentialsFile.
+func (h *Helper) AddCredentialsFile(file CredentialsFile) {
+	h.credentialsMu.Lock()
+	defer h.credentialsMu.Unlock()
+	h.credentialsFiles = append(h.credentialsFiles, file)
+}
+
+// IsolateHome gives the commands run in the working directory a home
+// directory of their own, inside the working directory, so that neither
+// Terraform nor the providers it runs can read configuration or credentials
+// from the real home directory of the user running the tests, such as
+// ~/.aws or ~/.terraformrc. It returns the path of the new home directory.
+//
+// Calling IsolateHome again has no further effect.
+func (wd *WorkingDir) IsolateHome() (string, error) {
+	home := filepath.Join(wd.baseDir, homeDirName)
+	err := os.MkdirAll(home, 0700)
+	if err != nil {
+		return "", err
+	}
+
+	wd.Setenv("HOME", home)
+	wd.Setenv("USERPROFILE", home)
+	wd.Setenv("XDG_CONFIG_HOME", filepath.Join(home, ".config"))
+	return home, nil
+}
+
+// StageCredentialsFile writes the given credentials file into the working
+// directory's isolated home directory, isolating it first if necessary, and
+// sets the file's EnvVar, if any, to point to it. The staged file is
+// deleted when the working directory is closed.
+func (wd *WorkingDir) StageCredentialsFile(file CredentialsFile) error {
+	home, err := wd.IsolateHome()
+	if err != nil {
+		return err
+	}
+
+	path := filepath.Join(home, filepath.FromSlash(file.Path))
+	err = os.MkdirAll(filepath.Dir(path), 0700)
+	if err != nil {
+		return err
+	}
+	err = ioutil.WriteFile(path, file.Content, 0600)
+	if err != nil {
+		return err
+	}
+
+	if file.EnvVar != "" {
+		wd.Setenv(file.EnvVar, path)
+	}
+	return nil
+}
+
+// stageHelperCredentialsFiles stages the credentials files registered on the
+// helper into the working directory.
+func (wd *WorkingDir) stageHelperCredentialsFiles() error {
+	wd.h.credentialsMu.Lock()
+	files := append([]CredentialsFile(nil), wd.h.credentialsFiles...)
+	wd.h.credentialsMu.Unlock()
+
+	for _, file := range files {
+		if err := wd.StageCredentialsFile(file); err != nil {
+			return err
+		}
+	}
+	return nil
+}
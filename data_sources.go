@@ -0,0 +1,72 @@
+package tftest
+
+import (
+	tfjson "github.com/hashicorp/terraform-json"
+)
+
+// ReadDataSources is a lightweight workflow for testing configurations that
+// contain only data sources. It initializes the working directory and then
+// creates a plan, during which Terraform reads all of the data sources, and
+// returns the resulting data source instances keyed by their absolute
+// addresses, such as "data.http.example".
+//
+// Nothing is applied and the state is left unchanged, so there is nothing to
+// destroy afterwards and this is allowed in plan-only mode. Any managed
+// resources in the configuration are not created. The plan replaces any
+// existing saved plan, and is removed before returning.
+func (wd *WorkingDir) ReadDataSources() (map[string]*tfjson.StateResource, error) {
+	if err := wd.Init(); err != nil {
+		return nil, err
+	}
+	if err := wd.CreatePlan(); err != nil {
+		return nil, err
+	}
+	raw, err := wd.SavedPlanRawJSON()
+	if err != nil {
+		return nil, err
+	}
+	if err := wd.ClearPlan(); err != nil {
+		return nil, err
+	}
+
+	plan, err := decodePlan(raw)
+	if err != nil {
+		return nil, err
+	}
+
+	// Data sources read during planning are recorded in the prior state,
+	// but older Terraform versions report them only in the planned values.
+	ret := map[string]*tfjson.StateResource{}
+	var roots []*tfjson.StateModule
+	if plan.PriorState != nil && plan.PriorState.Values != nil && plan.PriorState.Values.RootModule != nil {
+		roots = append(roots, plan.PriorState.Values.RootModule)
+	}
+	if plan.PlannedValues != nil && plan.PlannedValues.RootModule != nil {
+		roots = append(roots, plan.PlannedValues.RootModule)
+	}
+	for _, root := range roots {
+		modules := []*tfjson.StateModule{root}
+		for len(modules) > 0 {
+			module := modules[0]
+			modules = append(modules[1:], module.ChildModules...)
+			for _, r := range module.Resources {
+				if _, exists := ret[r.Address]; !exists && r.Mode == tfjson.DataResourceMode {
+					ret[r.Address] = r
+				}
+			}
+		}
+	}
+	return ret, nil
+}
+
+// RequireReadDataSources is a variant of ReadDataSources that will fail the
+// test via the given TestControl if the data sources cannot be read.
+func (wd *WorkingDir) RequireReadDataSources(t TestControl) map[string]*tfjson.StateResource {
+	t.Helper()
+	ret, err := wd.ReadDataSources()
+	if err != nil {
+		t := testingT{t}
+		t.Fatalf("failed to read data sources: %s", err)
+	}
+	return ret
+}
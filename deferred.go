@@ -0,0 +1,74 @@
+package tftest
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	tfjson "github.com/hashicorp/terraform-json"
+)
+
+// DeferredChange is a resource change which Terraform deferred to a later
+// plan/apply round, rather than planning it fully, because not enough
+// information was available yet. Deferred actions are available in Terraform
+// v1.9 and later, when plans are created with AllowDeferral set.
+type DeferredChange struct {
+	// Reason is Terraform's machine-readable explanation for deferring
+	// the change, such as "provider_config_unknown".
+	Reason string `json:"reason"`
+
+	ResourceChange *tfjson.ResourceChange `json:"resource_change"`
+}
+
+// SavedPlanDeferredChanges returns the changes that were deferred in the
+// current saved plan, and whether or not the plan is complete. A plan is
+// incomplete if applying it would still leave further changes to make.
+//
+// If no plan is saved or if the plan file cannot be read,
+// SavedPlanDeferredChanges returns an error.
+func (wd *WorkingDir) SavedPlanDeferredChanges() (complete bool, changes []DeferredChange, err error) {
+	raw, err := wd.savedPlanJSON()
+	if err != nil {
+		return false, nil, err
+	}
+
+	var plan struct {
+		// Complete is absent from plans made by Terraform versions
+		// without deferred actions, whose plans are always complete.
+		Complete        *bool            `json:"complete"`
+		DeferredChanges []DeferredChange `json:"deferred_changes"`
+	}
+	dec := json.NewDecoder(strings.NewReader(raw))
+	dec.UseNumber()
+	err = dec.Decode(&plan)
+	if err != nil {
+		return false, nil, fmt.Errorf("failed to decode plan: %w", err)
+	}
+
+	complete = plan.Complete == nil || *plan.Complete
+	return complete, plan.DeferredChanges, nil
+}
+
+// RequireDeferredChange will fail the test via the given TestControl unless
+// the current saved plan defers a change to the resource with the given
+// address for the given reason.
+func (wd *WorkingDir) RequireDeferredChange(t TestControl, address, reason string) {
+	t.Helper()
+	tt := testingT{t}
+
+	_, changes, err := wd.SavedPlanDeferredChanges()
+	if err != nil {
+		tt.Fatalf("failed to read saved plan: %s", err)
+		return
+	}
+	for _, change := range changes {
+		if change.ResourceChange == nil || change.ResourceChange.Address != address {
+			continue
+		}
+		if change.Reason != reason {
+			tt.Fatalf("change to %s was deferred for reason %q, but expected %q", address, change.Reason, reason)
+		}
+		return
+	}
+	tt.Fatalf("plan does not defer any change to %s", address)
+}
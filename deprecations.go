@@ -0,0 +1,66 @@
+package tftest
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// deprecationWarningRegexp matches the summary line of a warning diagnostic
+// about deprecated functionality, in either the plain or the boxed rendering
+// of diagnostics used by different Terraform CLI versions.
+var deprecationWarningRegexp = regexp.MustCompile(`(?m)^[\s│╷]*Warning: (.*(?i:deprecat).*)$`)
+
+// recordDeprecations finds any deprecation warnings in the given command
+// output and adds them to the helper's tally for the run.
+func (h *Helper) recordDeprecations(output string) {
+	matches := deprecationWarningRegexp.FindAllStringSubmatch(output, -1)
+	if len(matches) == 0 {
+		return
+	}
+
+	h.deprecationsMu.Lock()
+	defer h.deprecationsMu.Unlock()
+	if h.deprecations == nil {
+		h.deprecations = map[string]int{}
+	}
+	for _, match := range matches {
+		h.deprecations[strings.TrimSpace(match[1])]++
+	}
+}
+
+// DeprecationWarnings returns the summaries of all of the deprecation warnings
+// that Terraform has reported so far during the run, along with the number of
+// times each one was reported.
+func (h *Helper) DeprecationWarnings() map[string]int {
+	h.deprecationsMu.Lock()
+	defer h.deprecationsMu.Unlock()
+
+	ret := make(map[string]int, len(h.deprecations))
+	for k, v := range h.deprecations {
+		ret[k] = v
+	}
+	return ret
+}
+
+// writeDeprecationReport writes a summary of the deprecation warnings seen
+// during the run to w.
+func (h *Helper) writeDeprecationReport(w io.Writer) {
+	deprecations := h.DeprecationWarnings()
+	if len(deprecations) == 0 {
+		return
+	}
+
+	summaries := make([]string, 0, len(deprecations))
+	for summary := range deprecations {
+		summaries = append(summaries, summary)
+	}
+	sort.Strings(summaries)
+
+	fmt.Fprintf(w, "Terraform reported %d distinct deprecation warnings:\n", len(summaries))
+	for _, summary := range summaries {
+		fmt.Fprintf(w, "  %s (%d times)\n", summary, deprecations[summary])
+	}
+}
@@ -0,0 +1,187 @@
+package tftest
+
+import (
+	"errors"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Diagnostic is a single error or warning reported by Terraform, as found in
+// the Diagnostics of a *TerraformError.
+type Diagnostic struct {
+	// Severity is either "error" or "warning".
+	Severity string `json:"severity"`
+
+	Summary string `json:"summary"`
+	Detail  string `json:"detail"`
+
+	// Address is the address of the resource instance the diagnostic
+	// relates to, if any. This is known only for diagnostics from
+	// Terraform v0.15 and later, and for errors from Terraform v0.11, which
+	// may also give the address of a provider.
+	Address string `json:"address,omitempty"`
+
+	// Range is the part of the configuration the diagnostic relates to, if
+	// any.
+	Range *DiagnosticRange `json:"range,omitempty"`
+}
+
+// DiagnosticRange is the part of the configuration that a Diagnostic relates
+// to.
+type DiagnosticRange struct {
+	Filename string        `json:"filename"`
+	Start    DiagnosticPos `json:"start"`
+	End      DiagnosticPos `json:"end"`
+}
+
+// DiagnosticPos is a position in a configuration file. Column and Byte are
+// zero when only the line is known.
+type DiagnosticPos struct {
+	Line   int `json:"line"`
+	Column int `json:"column"`
+	Byte   int `json:"byte"`
+}
+
+// Diagnostics returns the diagnostics Terraform reported for the failed
+// command that produced the given error, or nil if the error is not, or
+// does not wrap, a *TerraformError.
+//
+// This allows a test which expects a command to fail to check that it failed
+// for the expected reason, without matching against the complete output.
+func Diagnostics(err error) []Diagnostic {
+	var tfErr *TerraformError
+	if !errors.As(err, &tfErr) {
+		return nil
+	}
+	return tfErr.Diagnostics
+}
+
+// addDiagnostics populates the Diagnostics of the given error returned by a
+// Terraform command, unless they are already known, from the command's own
+// output: the machine-readable UI messages on stdout if it was run with
+// -json, or otherwise the human-readable diagnostics on stderr.
+func addDiagnostics(err error) {
+	var tfErr *TerraformError
+	if !errors.As(err, &tfErr) || tfErr.Diagnostics != nil {
+		return
+	}
+	for _, arg := range tfErr.Args {
+		if arg == "-json" {
+			msgs, _ := decodeUIMessages(tfErr.Stdout)
+			tfErr.Diagnostics = UIDiagnostics(msgs)
+			return
+		}
+	}
+	tfErr.Diagnostics = parseDiagnostics(tfErr.Stderr)
+}
+
+// UIDiagnostics returns the diagnostics from the given machine-readable UI
+// messages.
+func UIDiagnostics(msgs []UIMessage) []Diagnostic {
+	var ret []Diagnostic
+	for _, msg := range msgs {
+		if msg.Diagnostic != nil {
+			ret = append(ret, *msg.Diagnostic)
+		}
+	}
+	return ret
+}
+
+var (
+	diagnosticHeaderRegexp  = regexp.MustCompile(`^(Error|Warning): (.*)$`)
+	diagnosticRangeRegexp   = regexp.MustCompile(`^\s+on (.+) line (\d+)`)
+	diagnosticAddressRegexp = regexp.MustCompile(`^\s+with (.+),$`)
+)
+
+// parseDiagnostics extracts the diagnostics from the human-readable output
+// of a Terraform command run with -no-color. Terraform v0.15 and later frame
+// each diagnostic with box-drawing characters, which are removed.
+func parseDiagnostics(output string) []Diagnostic {
+	var ret []Diagnostic
+	var cur *Diagnostic
+	var detail []string
+	inSnippet := false
+
+	flush := func() {
+		if cur != nil {
+			cur.Detail = strings.TrimSpace(strings.Join(detail, "\n"))
+			ret = append(ret, *cur)
+		}
+		cur, detail = nil, nil
+	}
+
+	for _, line := range strings.Split(output, "\n") {
+		line = strings.TrimRight(line, " \r")
+		if strings.HasPrefix(line, "╷") || strings.HasPrefix(line, "╵") {
+			flush()
+			continue
+		}
+		if line == "│" {
+			line = ""
+		}
+		line = strings.TrimPrefix(line, "│ ")
+
+		if m := diagnosticHeaderRegexp.FindStringSubmatch(line); m != nil {
+			flush()
+			cur = &Diagnostic{Severity: strings.ToLower(m[1]), Summary: m[2]}
+			inSnippet = false
+			continue
+		}
+		if cur == nil {
+			continue
+		}
+
+		// The address, range and source snippet come before the detail.
+		if len(detail) == 0 {
+			if m := diagnosticRangeRegexp.FindStringSubmatch(line); m != nil {
+				n, _ := strconv.Atoi(m[2])
+				pos := DiagnosticPos{Line: n}
+				cur.Range = &DiagnosticRange{Filename: m[1], Start: pos, End: pos}
+				inSnippet = true
+				continue
+			}
+			if m := diagnosticAddressRegexp.FindStringSubmatch(line); m != nil {
+				cur.Address = m[1]
+				inSnippet = true
+				continue
+			}
+			if inSnippet || line == "" {
+				inSnippet = inSnippet && line != ""
+				continue
+			}
+		}
+		detail = append(detail, line)
+	}
+	flush()
+	return expandLegacyDiagnostics(ret)
+}
+
+var (
+	legacyMultiErrorRegexp = regexp.MustCompile(`\d+ error\(s\) occurred:$`)
+	legacyBulletRegexp     = regexp.MustCompile(`^\s*\* (?:((?:module\.[^.\s]+\.)*(?:data\.)?[^.\s:]+\.[^\s:]+): )?(.*)$`)
+)
+
+// expandLegacyDiagnostics replaces each of the given diagnostics that is a
+// list of errors, as reported by Terraform v0.11 in the form
+// "1 error(s) occurred:" followed by a bullet for each error, with a
+// diagnostic for each error. Errors about a resource or provider, of the form
+// "* aws_instance.foo: message", get its address.
+func expandLegacyDiagnostics(diags []Diagnostic) []Diagnostic {
+	var ret []Diagnostic
+	for _, diag := range diags {
+		if !legacyMultiErrorRegexp.MatchString(diag.Summary) {
+			ret = append(ret, diag)
+			continue
+		}
+		for _, line := range strings.Split(diag.Detail, "\n") {
+			m := legacyBulletRegexp.FindStringSubmatch(line)
+			if m == nil || legacyMultiErrorRegexp.MatchString(m[2]) {
+				// nested lists repeat their errors in full
+				continue
+			}
+			ret = append(ret, Diagnostic{Severity: diag.Severity, Summary: m[2], Address: m[1]})
+		}
+	}
+	return ret
+}
@@ -0,0 +1,63 @@
+package tftest
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// DiskUsage returns the total size in bytes of the files in the working
+// directory, including Terraform's .terraform directory, state, and saved
+// plans. Files reached through the symlinks to the provider source
+// directories are not counted.
+func (wd *WorkingDir) DiskUsage() (int64, error) {
+	var total int64
+	err := filepath.Walk(wd.baseDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.Mode().IsRegular() {
+			total += info.Size()
+		}
+		return nil
+	})
+	return total, err
+}
+
+// SetDiskQuota limits the total size of the files in the working directory to
+// the given number of bytes. Once the limit has been exceeded, the command
+// which exceeded it returns an error, as do any subsequent commands.
+//
+// This is intended to fail fast when something like runaway provider logging
+// or an unexpectedly large .terraform directory would otherwise fill the
+// disk, causing confusing failures elsewhere. A quota of zero or less
+// disables the limit. The default quota for all working directories can be
+// set in bytes using the environment variable TF_ACC_DISK_QUOTA.
+func (wd *WorkingDir) SetDiskQuota(bytes int64) {
+	wd.diskQuota = bytes
+}
+
+// defaultDiskQuota returns the quota set in TF_ACC_DISK_QUOTA, or zero if
+// it is unset or invalid.
+func defaultDiskQuota() int64 {
+	quota, _ := strconv.ParseInt(os.Getenv("TF_ACC_DISK_QUOTA"), 10, 64)
+	return quota
+}
+
+// checkDiskQuota returns an error if the working directory exceeds its disk
+// quota.
+func (wd *WorkingDir) checkDiskQuota() error {
+	if wd.diskQuota <= 0 {
+		return nil
+	}
+
+	usage, err := wd.DiskUsage()
+	if err != nil {
+		return fmt.Errorf("failed to measure working directory disk usage: %w", err)
+	}
+	if usage > wd.diskQuota {
+		return fmt.Errorf("working directory uses %d bytes of disk, exceeding its quota of %d bytes", usage, wd.diskQuota)
+	}
+	return nil
+}
@@ -0,0 +1,44 @@
+package tftest
+
+import "os"
+
+// EnvPolicy controls how the helper adjusts the environment it passes to
+// Terraform, relative to the environment of the test process.
+type EnvPolicy struct {
+	// PassthroughLogging passes the test process's own TF_LOG and
+	// TF_LOG_PATH settings through to Terraform, rather than clearing them
+	// so that Terraform's logging can't pollute the output the helper
+	// captures. This is useful for one-off local runs to investigate a
+	// problem. TF_LOG_PATH takes precedence over TF_ACC_LOG_PATH.
+	//
+	// terraform-exec, which runs most commands, always logs at the TRACE
+	// level when given a log path, and doesn't support logging to stderr, so
+	// for full control over logging set TF_LOG_PATH as well as TF_LOG.
+	//
+	// PassthroughLogging can also be enabled by setting the environment
+	// variable TF_ACC_LOG_PASSTHROUGH.
+	PassthroughLogging bool
+}
+
+// SetEnvPolicy sets the policy for how the helper's working directories
+// construct the environment for Terraform.
+func (h *Helper) SetEnvPolicy(policy EnvPolicy) {
+	h.envPolicy = policy
+}
+
+// passthroughLogging returns whether the user's logging settings are passed
+// through to Terraform.
+func (h *Helper) passthroughLogging() bool {
+	return h.envPolicy.PassthroughLogging || os.Getenv("TF_ACC_LOG_PASSTHROUGH") != ""
+}
+
+// configuredLogPath returns the path to which the user has asked for
+// Terraform's logs to be written, or an empty string if none.
+func (wd *WorkingDir) configuredLogPath() string {
+	if wd.h.passthroughLogging() {
+		if p := os.Getenv("TF_LOG_PATH"); p != "" {
+			return p
+		}
+	}
+	return os.Getenv("TF_ACC_LOG_PATH")
+}
@@ -0,0 +1,141 @@
+package tftest
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// Types of Event.
+const (
+	EventTestStarted     = "test_started"
+	EventCommandStarted  = "command_started"
+	EventCommandFinished = "command_finished"
+	EventAssertionFailed = "assertion_failed"
+	EventArtifactWritten = "artifact_written"
+)
+
+// Event is a machine-readable record of something happening during a test
+// run, written to the event stream configured with SetEventWriter.
+type Event struct {
+	Time time.Time `json:"time"`
+
+	// Type is one of the Event constants, such as EventCommandStarted.
+	Type string `json:"type"`
+
+	// Test is the name of the test the event relates to, if known.
+	Test string `json:"test,omitempty"`
+
+	// Command is the Terraform subcommand, for command events.
+	Command string `json:"command,omitempty"`
+
+	// Duration is the time taken, for EventCommandFinished.
+	Duration time.Duration `json:"duration,omitempty"`
+
+	// Path is the location of the file written, for
+	// EventArtifactWritten.
+	Path string `json:"path,omitempty"`
+
+	// Message describes the error or failure, if any.
+	Message string `json:"message,omitempty"`
+
+	// Labels are the labels of the working directory the event relates to,
+	// for command events.
+	Labels map[string]string `json:"labels,omitempty"`
+}
+
+var events struct {
+	sync.Mutex
+	w   io.Writer
+	enc *json.Encoder
+}
+
+// SetEventWriter makes this package write an Event to the given writer, as
+// a single line of JSON, whenever a test starts, a Terraform command starts
+// or finishes, a test assertion fails, or a report file is written. This
+// allows IDE integrations and CI dashboards to show the live progress of a
+// test run.
+//
+// Alternatively, set the environment variable TF_ACC_EVENTS_PATH to have
+// AutoInitHelper or InitHelper append the events to the named file, which is
+// opened once however many helpers there are and closed when the last of them
+// is closed. Pass nil to stop writing events.
+func SetEventWriter(w io.Writer) {
+	events.Lock()
+	defer events.Unlock()
+	events.w = w
+	events.enc = nil
+	if w != nil {
+		events.enc = json.NewEncoder(w)
+	}
+}
+
+// eventsFile is the file named by TF_ACC_EVENTS_PATH, shared by all of the
+// helpers that are using it, which is closed when the last of them closes.
+var eventsFile struct {
+	sync.Mutex
+	f    *os.File
+	refs int
+}
+
+// openEventsFile makes the file at the given path the event stream, opening
+// it if no other helper has already done so.
+func openEventsFile(path string) error {
+	eventsFile.Lock()
+	defer eventsFile.Unlock()
+	if eventsFile.f == nil {
+		f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		if err != nil {
+			return fmt.Errorf("failed to open event stream: %s", err)
+		}
+		eventsFile.f = f
+		SetEventWriter(f)
+	}
+	eventsFile.refs++
+	return nil
+}
+
+// closeEventsFile releases a reference obtained with openEventsFile, closing
+// the file and stopping the event stream if it was the last one.
+func closeEventsFile() error {
+	eventsFile.Lock()
+	defer eventsFile.Unlock()
+	eventsFile.refs--
+	if eventsFile.refs > 0 {
+		return nil
+	}
+	f := eventsFile.f
+	eventsFile.f = nil
+	events.Lock()
+	if events.w == f {
+		events.w, events.enc = nil, nil
+	}
+	events.Unlock()
+	return f.Close()
+}
+
+// emitEvent writes the given event to the event stream, if any.
+func emitEvent(e Event) {
+	events.Lock()
+	defer events.Unlock()
+	if events.enc == nil {
+		return
+	}
+	if e.Time.IsZero() {
+		e.Time = now()
+	}
+	// The event stream is best-effort, so that a problem with it can't
+	// disrupt the tests themselves.
+	events.enc.Encode(e)
+}
+
+// testName returns the name of the test controlled by t, if it has one.
+func testName(t TestControl) string {
+	if named, ok := t.(interface{ Name() string }); ok {
+		return named.Name()
+	}
+	return ""
+}
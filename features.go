@@ -0,0 +1,129 @@
+package tftest
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Feature names an optional helper behavior which can be turned on or off
+// either in code, using SetFeature, or per CI environment, using the
+// environment variable TF_ACC_FEATURES.
+type Feature string
+
+const (
+	// FeaturePersistOnFailure keeps the working directory of a failed test
+	// rather than deleting it when it is closed, so that it can be inspected
+	// afterwards. The location of the directory is printed to stderr.
+	FeaturePersistOnFailure Feature = "persist_on_failure"
+
+	// FeatureCaptureProviderOutput enables CaptureProviderOutput for every new
+	// working directory.
+	FeatureCaptureProviderOutput Feature = "capture_provider_output"
+
+	// FeatureIsolateHome runs IsolateHome for every new working directory.
+	FeatureIsolateHome Feature = "isolate_home"
+
+	// FeatureReproBundles saves a repro bundle, as written by
+	// WorkingDir.WriteReproBundle, to the helper's artifact store whenever a
+	// Terraform command fails.
+	FeatureReproBundles Feature = "repro_bundles"
+
+	// FeatureLogToTest sends Terraform's log for each command to the test
+	// log, as described for WorkingDir.SetTerraformLogger, for working
+	// directories created with RequireNewWorkingDir. "go test" shows it only
+	// in verbose mode or for failed tests, and a TestControl with a
+	// Verbose() bool method that returns false turns it off.
+	FeatureLogToTest Feature = "log_to_test"
+
+	// FeatureCheckPlanDeterminism makes WorkingDir.CreatePlan check that
+	// the provider plans deterministically, as described for
+	// WorkingDir.CheckPlanDeterminism.
+	FeatureCheckPlanDeterminism Feature = "check_plan_determinism"
+)
+
+// SetFeature sets whether the given feature is enabled for the working
+// directories created by the helper from now on.
+//
+// The feature can still be overridden by TF_ACC_FEATURES, which is a
+// comma-separated list of feature names to enable, where a name prefixed with
+// "-" disables that feature instead. This allows a particular CI environment
+// to toggle behaviors without code changes. Features neither set in code nor
+// in the environment are disabled.
+func (h *Helper) SetFeature(feature Feature, enabled bool) {
+	h.featuresMu.Lock()
+	defer h.featuresMu.Unlock()
+	if h.features == nil {
+		h.features = map[Feature]bool{}
+	}
+	h.features[feature] = enabled
+}
+
+// FeatureEnabled returns whether the given feature is enabled, taking into
+// account both SetFeature and TF_ACC_FEATURES.
+func (h *Helper) FeatureEnabled(feature Feature) bool {
+	if enabled, ok := envFeature(feature); ok {
+		return enabled
+	}
+
+	h.featuresMu.Lock()
+	defer h.featuresMu.Unlock()
+	return h.features[feature]
+}
+
+// envFeature returns the setting for the given feature in TF_ACC_FEATURES,
+// and whether it appears there at all. If a feature appears more than once
+// then the last mention wins.
+func envFeature(feature Feature) (enabled, ok bool) {
+	for _, name := range strings.Split(os.Getenv("TF_ACC_FEATURES"), ",") {
+		name = strings.TrimSpace(name)
+		switch {
+		case name == string(feature):
+			enabled, ok = true, true
+		case name == "-"+string(feature):
+			enabled, ok = false, true
+		}
+	}
+	return enabled, ok
+}
+
+// persistDir records that the given working directory is being kept by
+// FeaturePersistOnFailure.
+func (h *Helper) persistDir(dir string) {
+	h.featuresMu.Lock()
+	defer h.featuresMu.Unlock()
+	if h.persistedDirs == nil {
+		h.persistedDirs = map[string]bool{}
+	}
+	h.persistedDirs[dir] = true
+}
+
+// removeBaseDir deletes the helper's base directory, except for any working
+// directories kept by FeaturePersistOnFailure.
+func (h *Helper) removeBaseDir() error {
+	h.featuresMu.Lock()
+	persisted := len(h.persistedDirs) > 0
+	h.featuresMu.Unlock()
+	if !persisted {
+		return os.RemoveAll(h.baseDir)
+	}
+
+	entries, err := ioutil.ReadDir(h.baseDir)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		dir := filepath.Join(h.baseDir, entry.Name())
+		h.featuresMu.Lock()
+		keep := h.persistedDirs[dir]
+		h.featuresMu.Unlock()
+		if keep {
+			continue
+		}
+		if err := os.RemoveAll(dir); err != nil {
+			return err
+		}
+	}
+	return nil
+}
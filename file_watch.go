@@ -0,0 +1,123 @@
+package tftest
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// fileWatchInterval is how often the working directory is scanned for
+// changes while a command runs, when WatchFiles is enabled.
+const fileWatchInterval = 100 * time.Millisecond
+
+// WatchFiles makes the working directory log to the given writer each file
+// that is created, modified or removed within it while a Terraform command is
+// running, such as state writes, lock files and plan files. Pass nil to stop.
+//
+// This is intended for diagnosing backend and state problems that only
+// reproduce in CI, where the working directory can't be inspected while the
+// command is running. The directory is polled rather than watched using
+// operating system notifications, so a file that is both created and removed
+// between two scans is not reported. The provider plugins installed by init
+// are not reported.
+func (wd *WorkingDir) WatchFiles(w io.Writer) {
+	wd.fileWatchW = w
+}
+
+// fileStamp is the information compared between scans to detect changes.
+type fileStamp struct {
+	size    int64
+	modTime time.Time
+}
+
+// startFileWatch starts logging the changes made to the working directory by
+// the command with the given name, if enabled, returning a function to call
+// once the command completes which reports any final changes and stops.
+func (wd *WorkingDir) startFileWatch(name string) func() {
+	w := wd.fileWatchW
+	if w == nil {
+		return func() {}
+	}
+
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	prev := wd.scanFiles()
+	go func() {
+		defer close(done)
+		for {
+			select {
+			case <-stop:
+				reportFileChanges(w, name, prev, wd.scanFiles())
+				return
+			case <-currentClock().After(fileWatchInterval):
+			}
+			cur := wd.scanFiles()
+			reportFileChanges(w, name, prev, cur)
+			prev = cur
+		}
+	}()
+
+	return func() {
+		close(stop)
+		<-done
+	}
+}
+
+// scanFiles returns the size and modification time of every file in the
+// working directory, keyed by path relative to the working directory.
+func (wd *WorkingDir) scanFiles() map[string]fileStamp {
+	ret := map[string]fileStamp{}
+	pluginDirs := map[string]bool{
+		filepath.Join(".terraform", "plugins"):   true,
+		filepath.Join(".terraform", "providers"): true,
+	}
+	filepath.Walk(wd.baseDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			// Files can disappear while we're scanning, which is
+			// reported by the next scan.
+			return nil
+		}
+		rel, err := filepath.Rel(wd.baseDir, path)
+		if err != nil {
+			return nil
+		}
+		if info.IsDir() {
+			if pluginDirs[rel] {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		ret[rel] = fileStamp{size: info.Size(), modTime: info.ModTime()}
+		return nil
+	})
+	return ret
+}
+
+// reportFileChanges writes a line to w for each difference between the two
+// given scans.
+func reportFileChanges(w io.Writer, name string, prev, cur map[string]fileStamp) {
+	var lines []string
+	for path, stamp := range cur {
+		old, ok := prev[path]
+		switch {
+		case !ok:
+			lines = append(lines, fmt.Sprintf("terraform %s: created %s (%d bytes)", name, path, stamp.size))
+		case old != stamp:
+			lines = append(lines, fmt.Sprintf("terraform %s: modified %s (%d bytes)", name, path, stamp.size))
+		}
+	}
+	for path := range prev {
+		if _, ok := cur[path]; !ok {
+			lines = append(lines, fmt.Sprintf("terraform %s: removed %s", name, path))
+		}
+	}
+	sort.Strings(lines)
+
+	timestamp := now().Format("15:04:05.000")
+	for _, line := range lines {
+		fmt.Fprintf(w, "%s %s\n", timestamp, line)
+	}
+}
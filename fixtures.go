@@ -0,0 +1,212 @@
+package tftest
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// FixtureManifestFileName is the name of the optional file in a fixture
+// directory that declares the expected outcome of running its configuration,
+// for use with Helper.RunFixtures.
+const FixtureManifestFileName = "tftest.json"
+
+// FixtureManifest describes the expected outcome of a fixture run with
+// Helper.RunFixtures. It is decoded from the fixture's manifest file, using
+// the JSON property names given for each field.
+//
+// A fixture without a manifest is expected to plan, apply and destroy
+// successfully.
+type FixtureManifest struct {
+	// Skip, if set, skips the fixture, giving this string as the reason.
+	Skip string `json:"skip"`
+
+	// PlanOnly stops the fixture after planning, so that it never applies
+	// or destroys anything.
+	PlanOnly bool `json:"plan_only"`
+
+	// PlanChanges, if set, is the number of resource instances that the
+	// plan must propose to create, update or delete.
+	PlanChanges *int `json:"plan_changes"`
+
+	// ExpectError, if set, is a regular expression that the error from
+	// init, plan or apply must match. The fixture fails if all of them
+	// succeed.
+	ExpectError string `json:"expect_error"`
+}
+
+// RunFixtures runs a subtest of t for each subdirectory of the given
+// directory which contains Terraform configuration files, named after the
+// subdirectory.
+//
+// Each subtest copies the fixture's files into a new working directory and
+// then checks the outcome declared in the fixture's manifest file, named by
+// FixtureManifestFileName, as described by FixtureManifest. Anything applied
+// is destroyed again before the subtest completes.
+func (h *Helper) RunFixtures(t *testing.T, dir string) {
+	t.Helper()
+
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("failed to read fixtures directory: %s", err)
+	}
+	var names []string
+	for _, entry := range entries {
+		if entry.IsDir() && isConfigDir(filepath.Join(dir, entry.Name())) {
+			names = append(names, entry.Name())
+		}
+	}
+	sort.Strings(names)
+	if len(names) == 0 {
+		t.Fatalf("no fixtures found in %s", dir)
+	}
+
+	for _, name := range names {
+		fixtureDir := filepath.Join(dir, name)
+		t.Run(name, func(t *testing.T) {
+			h.runFixture(t, fixtureDir)
+		})
+	}
+}
+
+func (h *Helper) runFixture(t *testing.T, dir string) {
+	t.Helper()
+
+	manifest, err := readFixtureManifest(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if manifest.Skip != "" {
+		t.Skipf("skipping fixture: %s", manifest.Skip)
+	}
+	var expectErr *regexp.Regexp
+	if manifest.ExpectError != "" {
+		expectErr, err = regexp.Compile(manifest.ExpectError)
+		if err != nil {
+			t.Fatalf("invalid expect_error in %s: %s", FixtureManifestFileName, err)
+		}
+	}
+
+	wd := h.RequireNewWorkingDir(t)
+	defer wd.Close()
+
+	// The fixture's own files provide the whole configuration, but
+	// SetConfig must still be called to prepare the working directory.
+	wd.RequireSetConfig(t, "")
+	err = copyFixture(dir, wd.baseDir)
+	if err != nil {
+		t.Fatalf("failed to copy fixture: %s", err)
+	}
+
+	err = h.checkFixture(wd, manifest)
+	switch {
+	case expectErr == nil && err != nil:
+		t.Fatal(err)
+	case expectErr != nil && err == nil:
+		t.Fatalf("fixture succeeded, but expected an error matching %s", expectErr)
+	case expectErr != nil && !expectErr.MatchString(err.Error()):
+		t.Fatalf("fixture failed with an error not matching %s: %s", expectErr, err)
+	}
+}
+
+// checkFixture runs the fixture configuration in the given working directory,
+// returning the first error encountered.
+func (h *Helper) checkFixture(wd *WorkingDir, manifest *FixtureManifest) (err error) {
+	if err := wd.Init(); err != nil {
+		return fmt.Errorf("init failed: %s", err)
+	}
+	if err := wd.CreatePlan(); err != nil {
+		return fmt.Errorf("failed to create plan: %s", err)
+	}
+	if manifest.PlanChanges != nil {
+		summary, err := wd.planChangeSummary(PlanFileName)
+		if err != nil {
+			return err
+		}
+		if got, want := len(summary.Addresses), *manifest.PlanChanges; got != want {
+			return fmt.Errorf("plan has %d changes, but the manifest expects %d: %s", got, want, strings.Join(summary.Addresses, ", "))
+		}
+	}
+	if manifest.PlanOnly {
+		return nil
+	}
+
+	// Destroy even if apply fails, because it may have created some of the
+	// objects before failing.
+	defer func() {
+		if destroyErr := wd.Destroy(); destroyErr != nil && err == nil {
+			err = fmt.Errorf("WARNING: destroy failed, so %s: %s", leakWarning, destroyErr)
+		}
+	}()
+	if err := wd.Apply(); err != nil {
+		return fmt.Errorf("failed to apply: %s", err)
+	}
+	return nil
+}
+
+// readFixtureManifest reads the manifest from the given fixture directory,
+// returning the default expectations if it has none.
+func readFixtureManifest(dir string) (*FixtureManifest, error) {
+	manifest := &FixtureManifest{}
+	src, err := ioutil.ReadFile(filepath.Join(dir, FixtureManifestFileName))
+	if os.IsNotExist(err) {
+		return manifest, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(src, manifest)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s: %s", FixtureManifestFileName, err)
+	}
+	return manifest, nil
+}
+
+// isConfigDir returns true if the given directory directly contains any
+// Terraform configuration files.
+func isConfigDir(dir string) bool {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return false
+	}
+	for _, entry := range entries {
+		name := entry.Name()
+		if !entry.IsDir() && (strings.HasSuffix(name, ".tf") || strings.HasSuffix(name, ".tf.json")) {
+			return true
+		}
+	}
+	return false
+}
+
+// copyFixture recursively copies all of the files in srcDir except the
+// manifest into destDir, so that the fixture can include local modules and
+// other files its configuration refers to.
+func copyFixture(srcDir, destDir string) error {
+	return filepath.Walk(srcDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(srcDir, path)
+		if err != nil {
+			return err
+		}
+		dest := filepath.Join(destDir, rel)
+		switch {
+		case rel == FixtureManifestFileName:
+			return nil
+		case info.IsDir():
+			return os.MkdirAll(dest, 0755)
+		}
+		src, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		return ioutil.WriteFile(dest, src, info.Mode())
+	})
+}
@@ -0,0 +1,136 @@
+package tftest
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/hashicorp/go-version"
+)
+
+// minFunctionsVersion is the earliest Terraform CLI version supporting
+// provider-defined functions.
+var minFunctionsVersion = version.Must(version.NewVersion("1.8.0"))
+
+// functionResultOutput is the name of the output value to which the
+// configurations generated by ProviderFunctionConfig assign the result.
+const functionResultOutput = "result"
+
+// ProviderFunctionConfig returns a configuration which calls a function
+// defined by the provider with the given source address, such as
+// "hashicorp/example", and assigns the result to an output value named
+// "result". Provider-defined functions require Terraform v1.8 or later.
+//
+// Each argument must be a Terraform expression, so string arguments must
+// include their quotes, as in `"hello"`.
+func ProviderFunctionConfig(providerSource, function string, args ...string) string {
+	name := providerSource[strings.LastIndex(providerSource, "/")+1:]
+	return fmt.Sprintf(`
+terraform {
+  required_providers {
+    %s = {
+      source = %q
+    }
+  }
+}
+
+output %q {
+  value = provider::%s::%s(%s)
+}
+`, name, providerSource, functionResultOutput, name, function, strings.Join(args, ", "))
+}
+
+// CallProviderFunction replaces the configuration of the working directory
+// with one generated by ProviderFunctionConfig, and then initializes and
+// plans it to evaluate the function call, returning the result.
+//
+// The result is a value decoded from JSON, with numbers represented as
+// json.Number. If the function returns an error, or the call is otherwise
+// invalid, then the returned error includes Terraform's diagnostics.
+func (wd *WorkingDir) CallProviderFunction(providerSource, function string, args ...string) (interface{}, error) {
+	if v := wd.h.TerraformVersion(); v != nil && v.LessThan(minFunctionsVersion) {
+		return nil, fmt.Errorf("provider-defined functions require Terraform v%s or later, but this is v%s", minFunctionsVersion, v)
+	}
+
+	err := wd.SetConfig(ProviderFunctionConfig(providerSource, function, args...))
+	if err != nil {
+		return nil, err
+	}
+	err = wd.Init()
+	if err != nil {
+		return nil, err
+	}
+	err = wd.CreatePlan()
+	if err != nil {
+		return nil, err
+	}
+
+	raw, err := wd.savedPlanJSON()
+	if err != nil {
+		return nil, err
+	}
+
+	var plan struct {
+		OutputChanges map[string]struct {
+			After interface{} `json:"after"`
+		} `json:"output_changes"`
+	}
+	dec := json.NewDecoder(strings.NewReader(raw))
+	dec.UseNumber()
+	err = dec.Decode(&plan)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode plan: %w", err)
+	}
+
+	change, ok := plan.OutputChanges[functionResultOutput]
+	if !ok {
+		return nil, fmt.Errorf("plan has no change for output %q", functionResultOutput)
+	}
+	return change.After, nil
+}
+
+// RequireCallProviderFunction is a variant of CallProviderFunction that will
+// fail the test via the given TestControl if the function cannot be called.
+func (wd *WorkingDir) RequireCallProviderFunction(t TestControl, providerSource, function string, args ...string) interface{} {
+	t.Helper()
+	ret, err := wd.CallProviderFunction(providerSource, function, args...)
+	if err != nil {
+		t := testingT{t}
+		t.Fatalf("failed to call provider function: %s", err)
+	}
+	return ret
+}
+
+// RequireProviderFunctionError is a variant of CallProviderFunction that
+// will fail the test via the given TestControl unless the function call
+// fails with an error matching the given pattern, for testing the error
+// diagnostics returned by a function.
+func (wd *WorkingDir) RequireProviderFunctionError(t TestControl, pattern *regexp.Regexp, providerSource, function string, args ...string) {
+	t.Helper()
+	_, err := wd.CallProviderFunction(providerSource, function, args...)
+	if err == nil {
+		t := testingT{t}
+		t.Fatalf("provider function call succeeded, but expected an error matching %s", pattern)
+		return
+	}
+	if !pattern.MatchString(err.Error()) {
+		t := testingT{t}
+		t.Fatalf("provider function call error does not match %s: %s", pattern, err)
+	}
+}
+
+// savedPlanJSON returns the JSON representation of the current saved plan,
+// exactly as produced by "terraform show -json". Unlike SavedPlan, this does
+// not depend on the plan format version being one that terraform-json
+// understands.
+func (wd *WorkingDir) savedPlanJSON() (string, error) {
+	if !wd.HasSavedPlan() {
+		return "", fmt.Errorf("there is no current saved plan")
+	}
+
+	return wd.runStdout("show", func() error {
+		return wd.runTerraform(context.Background(), "show", "-json", PlanFileName)
+	})
+}
@@ -0,0 +1,228 @@
+package tftest
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// CollectStaleDirs looks for directories left behind in the temporary
+// directory by earlier test runs which exited without calling Close, for
+// example because the test program crashed or was killed, and removes any
+// that have not been modified for at least maxAge.
+//
+// Only directories created by this package with an ownership marker are
+// considered, and only if they belong to the current user on this host and
+// the process that created them is no longer running, so that directories
+// in use by concurrent test runs, or by other users sharing the temporary
+// directory, are left alone. Each directory is locked while it is collected,
+// so that concurrent collectors don't destroy the same objects twice.
+//
+// Before removing a stale working directory that contains state, it first
+// attempts to destroy the objects recorded there, using the configuration
+// and state left in the directory. If the destroy fails then the directory is
+// kept, and reported in the returned error, because its state is then the only
+// record of objects that may still exist. Destroy won't succeed for a
+// directory whose configuration relies on a provider that was served by the
+// crashed test program itself, so such directories must be cleaned up
+// manually.
+//
+// AutoInitHelper and InitHelper call this automatically if the environment
+// variable TF_ACC_STALE_DIR_MAX_AGE is set to a duration such as "24h".
+//
+// The return value is the list of directories that were removed.
+func (h *Helper) CollectStaleDirs(maxAge time.Duration) ([]string, error) {
+	parent := filepath.Dir(h.baseDir)
+	entries, err := ioutil.ReadDir(parent)
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan for stale directories: %s", err)
+	}
+
+	var removed []string
+	var problems []string
+	for _, entry := range entries {
+		dir := filepath.Join(parent, entry.Name())
+		if !entry.IsDir() || dir == h.baseDir || dir == h.execTempDir {
+			continue
+		}
+		if !strings.HasPrefix(entry.Name(), "tftest") {
+			continue
+		}
+		if time.Since(latestModTime(dir)) < maxAge {
+			continue
+		}
+		if !ownedByDeadProcess(dir) {
+			continue
+		}
+
+		unlock, err := lockDir(dir)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("%s: %s", dir, err))
+			continue
+		}
+		if _, err := os.Stat(ownerMarkerPath(dir)); err != nil {
+			// Another collector removed it while we waited for the lock.
+			unlock()
+			continue
+		}
+
+		if !strings.HasPrefix(entry.Name(), "tftest-terraform") {
+			// Helper base directories contain one directory per working
+			// directory, each of which may have state.
+			failed := false
+			works, _ := ioutil.ReadDir(dir)
+			for _, work := range works {
+				if !work.IsDir() {
+					continue
+				}
+				workDir := filepath.Join(dir, work.Name())
+				if err := h.destroyStaleDir(workDir); err != nil {
+					problems = append(problems, fmt.Sprintf("%s: %s", workDir, err))
+					failed = true
+				}
+			}
+			if failed {
+				unlock()
+				continue
+			}
+		}
+
+		err = os.RemoveAll(dir)
+		if err != nil {
+			unlock()
+			problems = append(problems, fmt.Sprintf("%s: %s", dir, err))
+			continue
+		}
+		removed = append(removed, dir)
+	}
+
+	if len(problems) > 0 {
+		return removed, fmt.Errorf("failed to clean up some stale directories, so %s:\n  %s", leakWarning, strings.Join(problems, "\n  "))
+	}
+	return removed, nil
+}
+
+// ownerMarker is the content of the file written into each directory which
+// CollectStaleDirs may later collect, identifying the process that owns it.
+type ownerMarker struct {
+	PID   int    `json:"pid"`
+	UID   int    `json:"uid"`
+	Host  string `json:"host"`
+	RunID string `json:"run_id,omitempty"`
+}
+
+// ownerMarkerPath returns the path of the ownership marker for dir.
+func ownerMarkerPath(dir string) string {
+	return filepath.Join(dir, ".tftest-owner")
+}
+
+// writeOwnerMarker records the current process as the owner of dir.
+func writeOwnerMarker(dir, runID string) error {
+	host, _ := os.Hostname()
+	src, err := json.Marshal(ownerMarker{
+		PID:   os.Getpid(),
+		UID:   os.Getuid(),
+		Host:  host,
+		RunID: runID,
+	})
+	if err != nil {
+		return err
+	}
+	err = ioutil.WriteFile(ownerMarkerPath(dir), src, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to write ownership marker: %s", err)
+	}
+	return nil
+}
+
+// ownedByDeadProcess returns true if dir has an ownership marker recording
+// a process of the current user on this host which is no longer running.
+func ownedByDeadProcess(dir string) bool {
+	src, err := ioutil.ReadFile(ownerMarkerPath(dir))
+	if err != nil {
+		return false
+	}
+	var owner ownerMarker
+	if err := json.Unmarshal(src, &owner); err != nil {
+		return false
+	}
+	host, _ := os.Hostname()
+	if owner.Host != host || owner.UID != os.Getuid() || owner.PID == os.Getpid() {
+		return false
+	}
+	return !processAlive(owner.PID)
+}
+
+// collectStaleDirsFromEnv calls CollectStaleDirs if TF_ACC_STALE_DIR_MAX_AGE
+// is set, reporting the outcome on stderr rather than failing, since it can't
+// affect the results of the current run.
+func (h *Helper) collectStaleDirsFromEnv() error {
+	s := os.Getenv("TF_ACC_STALE_DIR_MAX_AGE")
+	if s == "" {
+		return nil
+	}
+	maxAge, err := time.ParseDuration(s)
+	if err != nil {
+		return fmt.Errorf("invalid TF_ACC_STALE_DIR_MAX_AGE %q: %s", s, err)
+	}
+
+	removed, err := h.CollectStaleDirs(maxAge)
+	for _, dir := range removed {
+		fmt.Fprintf(os.Stderr, "removed stale directory %s\n", dir)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "WARNING: %s\n", err)
+	}
+	return nil
+}
+
+// destroyStaleDir destroys any objects recorded in the state of an abandoned
+// working directory.
+func (h *Helper) destroyStaleDir(dir string) error {
+	info, err := os.Stat(filepath.Join(dir, "terraform.tfstate"))
+	if os.IsNotExist(err) || (err == nil && info.Size() == 0) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	for _, args := range [][]string{
+		{"init", "-no-color", "-input=false"},
+		{"destroy", "-no-color", "-input=false", "-auto-approve"},
+	} {
+		cmd := exec.Command(h.terraformExec, args...)
+		cmd.Dir = dir
+		cmd.Env = append(os.Environ(), "TF_IN_AUTOMATION=1")
+		out, err := cmd.CombinedOutput()
+		if err != nil {
+			return fmt.Errorf("terraform %s: %s\n\n%s", args[0], err, out)
+		}
+	}
+	return nil
+}
+
+// latestModTime returns the most recent modification time of the given
+// directory, its immediate children and their state files, which together
+// indicate when a test run last did anything with it.
+func latestModTime(dir string) time.Time {
+	var latest time.Time
+	consider := func(path string) {
+		if info, err := os.Stat(path); err == nil && info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+
+	consider(dir)
+	children, _ := ioutil.ReadDir(dir)
+	for _, child := range children {
+		consider(filepath.Join(dir, child.Name()))
+		consider(filepath.Join(dir, child.Name(), "terraform.tfstate"))
+	}
+	return latest
+}
@@ -4,6 +4,9 @@ go 1.12
 
 require (
 	github.com/hashicorp/go-getter v1.5.3
+	github.com/hashicorp/go-version v1.6.0
+	github.com/hashicorp/hcl/v2 v2.11.1
 	github.com/hashicorp/terraform-exec v0.13.3
-	github.com/hashicorp/terraform-json v0.10.0
+	github.com/hashicorp/terraform-json v0.15.0
+	github.com/zclconf/go-cty v1.10.0
 )
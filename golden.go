@@ -0,0 +1,92 @@
+package tftest
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+var (
+	goldenTimestampRegexp = regexp.MustCompile(`\d{4}-\d{2}-\d{2}T\d{2}:\d{2}:\d{2}(\.\d+)?(Z|[+-]\d{2}:\d{2})`)
+	goldenDurationRegexp  = regexp.MustCompile(`\b(\d+(\.\d+)?(h|ms|us|µs|ns|m|s))+\b`)
+)
+
+// NormalizeOutput returns the given human-readable Terraform output with the
+// details that vary between runs and CLI versions masked, so that it can be
+// compared with a golden file:
+//
+//   - the working directory's path is replaced with "<WORKDIR>", and the
+//     provider source directory's with "<SOURCEDIR>"
+//   - RFC 3339 timestamps are replaced with "<TIMESTAMP>"
+//   - durations such as "1m30s" are replaced with "<DURATION>"
+//   - the box-drawing frames around diagnostics added in Terraform v0.15
+//     are removed, as is trailing whitespace
+func (wd *WorkingDir) NormalizeOutput(output string) string {
+	if wd.baseDir != "" {
+		output = strings.Replace(output, wd.baseDir, "<WORKDIR>", -1)
+	}
+	if wd.h.sourceDir != "" {
+		output = strings.Replace(output, wd.h.sourceDir, "<SOURCEDIR>", -1)
+	}
+	output = goldenTimestampRegexp.ReplaceAllString(output, "<TIMESTAMP>")
+	output = goldenDurationRegexp.ReplaceAllString(output, "<DURATION>")
+
+	var lines []string
+	for _, line := range strings.Split(output, "\n") {
+		line = strings.TrimRight(line, " \r")
+		if strings.HasPrefix(line, "╷") || strings.HasPrefix(line, "╵") {
+			continue
+		}
+		if line == "│" {
+			line = ""
+		}
+		lines = append(lines, strings.TrimPrefix(line, "│ "))
+	}
+	return strings.TrimSpace(strings.Join(lines, "\n")) + "\n"
+}
+
+// MatchGoldenStderr checks that the normalized stderr of the failed command
+// that returned err, as produced by NormalizeOutput, matches the content of
+// the golden file at the given path. This allows a test to lock down the
+// exact error messages that the provider's diagnostics produce.
+//
+// If the environment variable TF_ACC_UPDATE_GOLDEN is set, the golden file
+// is instead written with the normalized stderr, creating it and its
+// directory if necessary.
+func (wd *WorkingDir) MatchGoldenStderr(err error, path string) error {
+	var tfErr *TerraformError
+	if !errors.As(err, &tfErr) {
+		return fmt.Errorf("expected a failed Terraform command, but got %v", err)
+	}
+	got := wd.NormalizeOutput(tfErr.Stderr)
+
+	if os.Getenv("TF_ACC_UPDATE_GOLDEN") != "" {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return err
+		}
+		return ioutil.WriteFile(path, []byte(got), 0644)
+	}
+
+	want, err := ioutil.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read golden file: %s; set TF_ACC_UPDATE_GOLDEN to create it", err)
+	}
+	if got != string(want) {
+		return fmt.Errorf("stderr of terraform %s does not match %s; set TF_ACC_UPDATE_GOLDEN to update it\n\ngot:\n%s\nwant:\n%s", tfErr.Subcommand, path, got, want)
+	}
+	return nil
+}
+
+// RequireMatchGoldenStderr is a variant of MatchGoldenStderr that will fail
+// the test via the given TestControl if the stderr doesn't match.
+func (wd *WorkingDir) RequireMatchGoldenStderr(t TestControl, err error, path string) {
+	t.Helper()
+	if err := wd.MatchGoldenStderr(err, path); err != nil {
+		t := testingT{t}
+		t.Fatalf("%s", err)
+	}
+}
@@ -0,0 +1,86 @@
+package tftest
+
+import (
+	"context"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Graph runs "terraform graph" and returns the dependency graph of the
+// configuration in the DOT format. graphType selects the graph to output, as
+// for the -type option, such as "plan" or "apply", or if empty the default
+// graph used for planning.
+//
+// GraphEdges extracts the dependencies from the output, for tests that check
+// the dependencies between resources rather than the exact rendering.
+func (wd *WorkingDir) Graph(graphType string) (string, error) {
+	args := []string{"graph"}
+	if graphType != "" {
+		args = append(args, "-type="+graphType)
+	}
+	return wd.runStdout("graph", func() error {
+		return wd.runTerraform(context.Background(), args...)
+	})
+}
+
+// RequireGraph is a variant of Graph that will fail the test via the given
+// TestControl if the graph cannot be produced.
+func (wd *WorkingDir) RequireGraph(t TestControl, graphType string) string {
+	t.Helper()
+	ret, err := wd.Graph(graphType)
+	if err != nil {
+		t := testingT{t}
+		t.Fatalf("failed to produce graph: %s", err)
+	}
+	return ret
+}
+
+// GraphEdge is a dependency in the output of Graph, meaning that the node
+// From depends on the node To.
+type GraphEdge struct {
+	From, To string
+}
+
+var graphEdgeRegexp = regexp.MustCompile(`^\s*"((?:[^"\\]|\\.)*)"\s*->\s*"((?:[^"\\]|\\.)*)"`)
+
+// GraphEdges returns the edges of the given graph in the DOT format, as
+// returned by Graph, sorted and without duplicates.
+//
+// The node names are simplified to the addresses they represent, such as
+// "aws_instance.foo" or `provider["registry.terraform.io/hashicorp/aws"]`,
+// by removing the "[root] " prefix and the suffixes such as " (expand)" that
+// Terraform adds.
+func GraphEdges(dot string) []GraphEdge {
+	seen := map[GraphEdge]bool{}
+	var ret []GraphEdge
+	for _, line := range strings.Split(dot, "\n") {
+		m := graphEdgeRegexp.FindStringSubmatch(line)
+		if m == nil {
+			continue
+		}
+		edge := GraphEdge{From: graphNodeAddress(m[1]), To: graphNodeAddress(m[2])}
+		if !seen[edge] {
+			seen[edge] = true
+			ret = append(ret, edge)
+		}
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		if ret[i].From != ret[j].From {
+			return ret[i].From < ret[j].From
+		}
+		return ret[i].To < ret[j].To
+	})
+	return ret
+}
+
+// graphNodeAddress returns the address represented by the given quoted DOT
+// node name.
+func graphNodeAddress(name string) string {
+	name = strings.Replace(name, `\"`, `"`, -1)
+	name = strings.TrimPrefix(name, "[root] ")
+	if i := strings.LastIndex(name, " ("); i >= 0 && strings.HasSuffix(name, ")") {
+		name = name[:i]
+	}
+	return name
+}
@@ -0,0 +1,71 @@
+package tftest
+
+import (
+	"sort"
+	"testing"
+
+	tfjson "github.com/hashicorp/terraform-json"
+)
+
+// GroupTest is the signature of a subtest run by Helper.RunGroup.
+//
+// foundation is the state of the group's shared foundation working directory
+// after it was applied, which the subtest can use to find the outputs and
+// attributes of the shared objects. It must be treated as read-only, because
+// it is shared between all of the subtests in the group. wd is a new working
+// directory belonging only to this subtest, which is closed automatically
+// once the subtest completes.
+type GroupTest func(t *testing.T, foundation *tfjson.State, wd *WorkingDir)
+
+// RunGroup runs a group of parallel subtests which all depend on the same
+// expensive prerequisite objects, creating those objects only once for the
+// whole group rather than once per test.
+//
+// The given foundation configuration is applied in a working directory of its
+// own before any of the subtests start, and then destroyed after all of them
+// have completed. Each subtest runs as a parallel subtest of t, named after
+// its key in the tests map.
+//
+// Subtests are responsible for destroying any objects they create in their
+// own working directories, and should do so before returning, because the
+// foundation objects they depend on are destroyed as soon as the last
+// subtest has finished.
+func (h *Helper) RunGroup(t *testing.T, foundationConfig string, tests map[string]GroupTest) {
+	t.Helper()
+
+	foundation := h.RequireNewWorkingDir(t)
+	defer foundation.Close()
+	foundation.RequireSetConfig(t, foundationConfig)
+	foundation.RequireInit(t)
+
+	// Destroy even if apply fails, because it may have created some of the
+	// objects before failing.
+	defer func() {
+		if err := foundation.Destroy(); err != nil {
+			foundation.handleCleanupFailure(t, "destroy group foundation", err)
+		}
+	}()
+	foundation.RequireApply(t)
+	state := foundation.RequireState(t)
+
+	names := make([]string, 0, len(tests))
+	for name := range tests {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	// The parallel subtests are wrapped in a non-parallel subtest, which
+	// doesn't return until all of them have completed, so that the
+	// foundation outlives them.
+	t.Run("group", func(t *testing.T) {
+		for _, name := range names {
+			test := tests[name]
+			t.Run(name, func(t *testing.T) {
+				t.Parallel()
+				wd := h.RequireNewWorkingDir(t)
+				defer wd.Close()
+				test(t, state, wd)
+			})
+		}
+	})
+}
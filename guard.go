@@ -23,12 +23,19 @@ import (
 // run more easily and without external cost by contributors.
 func AcceptanceTest(t TestControl) {
 	t.Helper()
-	if os.Getenv("TF_ACC") != "" {
+	if !AcceptanceModeEnabled() {
 		t.Log("TF_ACC is not set")
 		t.SkipNow()
 	}
 }
 
+// AcceptanceModeEnabled returns true if the environment variable TF_ACC is set
+// to a non-empty value, indicating that the caller wants to run acceptance
+// tests.
+func AcceptanceModeEnabled() bool {
+	return os.Getenv("TF_ACC") != ""
+}
+
 // LongTest is a test guard that will produce a log and call SkipNow on the
 // given TestControl if the test harness is currently running in "short mode".
 //
@@ -84,6 +91,10 @@ func (t testingT) Logf(f string, args ...interface{}) {
 func (t testingT) Fatalf(f string, args ...interface{}) {
 	t.Helper()
 	t.Log(fmt.Sprintf(f, args...))
+	emitEvent(Event{Type: EventAssertionFailed, Test: testName(t.TestControl), Message: fmt.Sprintf(f, args...)})
+	if msg := randomSeedMessage(); msg != "" {
+		t.Log(msg)
+	}
 	t.FailNow()
 }
 
@@ -1,14 +1,17 @@
 package tftest
 
 import (
+	"context"
 	"fmt"
 	"io/ioutil"
 	"os"
 	"path/filepath"
 	"runtime"
 	"strings"
+	"sync"
 
 	getter "github.com/hashicorp/go-getter"
+	"github.com/hashicorp/go-version"
 	"github.com/hashicorp/terraform-exec/tfexec"
 )
 
@@ -39,13 +42,120 @@ type Helper struct {
 	baseDir string
 
 	// sourceDir is the dir containing the provider source code, needed
-	// for tests that use fixture files.
+	// for tests that use fixture files. It is empty if there is none, as
+	// when testing modules or backends rather than a provider.
 	sourceDir     string
 	terraformExec string
 
+	// terraformVersion is the version of the executable at terraformExec
+	terraformVersion *version.Version
+
 	// execTempDir is created during DiscoverConfig to store any downloaded
-	// binaries
-	execTempDir string
+	// binaries, and is removed by Close unless it is shared with the other
+	// helpers in a HelperSet
+	execTempDir       string
+	sharedExecTempDir bool
+
+	// pluginVersions are the version suffixes for auxiliary provider plugins
+	pluginVersions []string
+
+	// previousPluginExec is the previous release of the provider under
+	// test, which CompareBuilds compares against
+	previousPluginExec string
+
+	// retryPolicy is the initial retry policy of new working directories
+	retryPolicy RetryPolicy
+
+	// templateValues and templateEndpoints are available to the
+	// configuration templates of all working directories
+	templateMu        sync.Mutex
+	templateValues    map[string]interface{}
+	templateEndpoints map[string]func() MockEndpoint
+
+	// autoInit is the initial auto-init setting of new working directories
+	autoInit bool
+
+	// cleanupPolicy is the initial cleanup policy of new working
+	// directories, and leaks are those recorded by CleanupRecordLeak
+	cleanupPolicy CleanupPolicy
+	leaksMu       sync.Mutex
+	leaks         []LeakedResources
+
+	// flaky are the commands that succeeded only after retrying
+	flakyMu sync.Mutex
+	flaky   []FlakyCommand
+
+	// resourceLimits are the initial resource limits of new working
+	// directories
+	resourceLimits ResourceLimits
+
+	// commandWrapper is the initial command wrapper of new working
+	// directories
+	commandWrapper []string
+
+	// pluginInstall is how plugin binaries are placed in working
+	// directories
+	pluginInstall PluginInstallStrategy
+
+	// throttles are the named buckets defined with AddThrottle
+	throttlesMu sync.Mutex
+	throttles   map[string]*throttle
+
+	// skips records the tests skipped using Skip
+	skipsMu sync.Mutex
+	skips   []SkippedTest
+
+	// deprecations counts the deprecation warnings reported by Terraform
+	deprecationsMu sync.Mutex
+	deprecations   map[string]int
+
+	// variables are the input variable values set with SetVariable
+	variablesMu sync.Mutex
+	variables   map[string]interface{}
+
+	// credentialsFiles are staged into each new working directory
+	credentialsMu    sync.Mutex
+	credentialsFiles []CredentialsFile
+
+	// commandMetrics are the metrics reported by WriteMetrics, by subcommand
+	metricsMu      sync.Mutex
+	commandMetrics map[string]*commandMetrics
+
+	// artifactStore receives the artifacts of failed tests, identified by
+	// runID
+	artifactStore ArtifactStore
+	runID         string
+
+	// features are the settings made with SetFeature
+	featuresMu sync.Mutex
+	features   map[Feature]bool
+
+	// persistedDirs are working directories kept by FeaturePersistOnFailure,
+	// which Close must not delete
+	persistedDirs map[string]bool
+
+	// providerBinaries are installed into each new working directory
+	providersMu      sync.Mutex
+	providerBinaries []ProviderBinary
+
+	// planOnly is the initial plan-only mode of new working directories
+	planOnly bool
+
+	// allowWithoutAcceptance permits mutating commands even if TF_ACC is
+	// not set
+	allowWithoutAcceptance bool
+
+	// envPolicy is set with SetEnvPolicy
+	envPolicy EnvPolicy
+
+	// confirm and confirmThreshold are the initial confirmation settings of
+	// new working directories
+	confirm          ConfirmFunc
+	confirmThreshold int
+
+	// openedEvents records that InitHelper opened TF_ACC_EVENTS_PATH for
+	// this helper, which Close must release
+	openedEvents bool
 }
 
 // AutoInitHelper uses the auto-discovery behavior of DiscoverConfig to prepare
@@ -59,7 +169,14 @@ func AutoInitHelper(sourceDir string) (*Helper, error) {
 		return nil, err
 	}
 
-	return InitHelper(config)
+	h, err := InitHelper(config)
+	if err != nil {
+		if config.execTempDir != "" {
+			os.RemoveAll(config.execTempDir)
+		}
+		return nil, err
+	}
+	return h, nil
 }
 
 // InitHelper prepares a testing helper with the given configuration.
@@ -68,33 +185,103 @@ func AutoInitHelper(sourceDir string) (*Helper, error) {
 // will construct a configuration automatically based on certain environment
 // variables.
 //
-// If this function returns an error then it may have left some temporary files
-// behind in the system's temporary directory. There is currently no way to
-// automatically clean those up.
-func InitHelper(config *Config) (*Helper, error) {
+// If this function returns an error then it removes the temporary directory it
+// created, but the caller remains responsible for any created by
+// DiscoverConfig, recorded in the given configuration.
+func InitHelper(config *Config) (h *Helper, err error) {
+	if config.RequireVerifiedTerraform && !config.terraformVerified {
+		return nil, fmt.Errorf("the Terraform CLI executable %s was not downloaded from releases.hashicorp.com, so its checksum and signature cannot be verified", config.TerraformExec)
+	}
+
 	tempDir := os.Getenv("TF_ACC_TEMP_DIR")
 	baseDir, err := ioutil.TempDir(tempDir, "tftest")
 	if err != nil {
 		return nil, fmt.Errorf("failed to create temporary directory for test helper: %s", err)
 	}
+	defer func() {
+		if err != nil {
+			os.RemoveAll(baseDir)
+		}
+	}()
+
+	tf, err := tfexec.NewTerraform(baseDir, config.TerraformExec)
+	if err != nil {
+		return nil, err
+	}
+	tfVersion, _, err := tf.Version(context.Background(), false)
+	if err != nil {
+		return nil, fmt.Errorf("failed to determine Terraform CLI version: %s", err)
+	}
+	switch {
+	case tfVersion.LessThan(minLegacyTerraformVersion):
+		return nil, fmt.Errorf("Terraform CLI v%s is not supported: this package requires Terraform v%s or later", tfVersion, minLegacyTerraformVersion)
+	case tfVersion.LessThan(minTerraformVersion) && !config.LegacyTerraform:
+		return nil, fmt.Errorf("Terraform CLI v%s is supported only in legacy mode: set Config.LegacyTerraform, or TF_ACC_TERRAFORM_LEGACY for DiscoverConfig, to use it", tfVersion)
+	}
+
+	openedEvents := false
+	if p := os.Getenv("TF_ACC_EVENTS_PATH"); p != "" {
+		if err := openEventsFile(p); err != nil {
+			return nil, err
+		}
+		openedEvents = true
+		defer func() {
+			if err != nil {
+				closeEventsFile()
+			}
+		}()
+	}
 
-	return &Helper{
-		baseDir:       baseDir,
-		sourceDir:     config.SourceDir,
-		terraformExec: config.TerraformExec,
-		execTempDir:   config.execTempDir,
-	}, nil
+	h = &Helper{
+		baseDir:          baseDir,
+		sourceDir:        config.SourceDir,
+		terraformExec:    config.TerraformExec,
+		terraformVersion: tfVersion,
+		execTempDir:      config.execTempDir,
+		pluginVersions:   config.PluginVersions,
+		pluginInstall:    config.PluginInstallStrategy,
+		runID:            newRunID(),
+		openedEvents:     openedEvents,
+
+		previousPluginExec: config.PreviousPluginExec,
+	}
+	if dir := os.Getenv("TF_ACC_ARTIFACTS_DIR"); dir != "" {
+		h.artifactStore = DirArtifactStore(dir)
+	}
+
+	err = writeOwnerMarker(baseDir, h.runID)
+	if err != nil {
+		return nil, err
+	}
+
+	err = h.collectStaleDirsFromEnv()
+	if err != nil {
+		return nil, err
+	}
+
+	return h, nil
 }
 
-// symlinkAuxiliaryProviders discovers auxiliary provider binaries, used in
-// multi-provider tests, and symlinks them to the plugin directory.
+// minTerraformVersion is the earliest Terraform CLI version which produces
+// the JSON plan and state representations this package relies on, and
+// minLegacyTerraformVersion is the earliest supported in legacy mode.
+var (
+	minTerraformVersion       = version.Must(version.NewVersion("0.12.0"))
+	minLegacyTerraformVersion = version.Must(version.NewVersion("0.11.0"))
+)
+
+// installAuxiliaryProviders discovers auxiliary provider binaries, used in
+// multi-provider tests, and installs them in the plugin directory using the
+// helper's plugin install strategy.
 //
 // Auxiliary provider binaries should be included in the provider source code
 // directory, under the path terraform.d/plugins/$GOOS_$GOARCH/provider-name.
+// Each one is installed once for each of the given versions, with the
+// corresponding version suffix, unless its filename already has one.
 //
 // The environment variable TF_ACC_PROVIDER_ROOT_DIR must be set to the path of
 // the provider source code directory root in order to use this feature.
-func symlinkAuxiliaryProviders(pluginDir string) error {
+func (h *Helper) installAuxiliaryProviders(pluginDir string) error {
 	providerRootDir := os.Getenv("TF_ACC_PROVIDER_ROOT_DIR")
 	if providerRootDir == "" {
 		// common case; assume intentional and do not log
@@ -120,11 +307,15 @@ func symlinkAuxiliaryProviders(pluginDir string) error {
 		return fmt.Errorf("Unexpected error: %s", err)
 	}
 
-	// now find all the providers in that dir and symlink them to the plugin dir
+	// now find all the providers in that dir and install them in the plugin dir
 	providers, err := ioutil.ReadDir(auxiliaryProviderDir)
 	if err != nil {
 		return fmt.Errorf("error reading auxiliary providers: %s", err)
 	}
+	err = os.MkdirAll(pluginDir, 0755)
+	if err != nil {
+		return fmt.Errorf("error creating plugin dir: %s", err)
+	}
 
 	zipDecompressor := new(getter.ZipDecompressor)
 
@@ -133,13 +324,6 @@ func symlinkAuxiliaryProviders(pluginDir string) error {
 		filenameExt := filepath.Ext(filename)
 		name := strings.TrimSuffix(filename, filenameExt)
 		path := filepath.Join(auxiliaryProviderDir, name)
-		symlinkPath := filepath.Join(pluginDir, name)
-
-		// exit early if we have already symlinked this provider
-		_, err := os.Stat(symlinkPath)
-		if err == nil {
-			continue
-		}
 
 		// if filename ends in .zip, assume it is a zip and extract it
 		// otherwise assume it is a provider binary
@@ -152,9 +336,19 @@ func symlinkAuxiliaryProviders(pluginDir string) error {
 			}
 		}
 
-		err = symlinkFile(path, symlinkPath)
-		if err != nil {
-			return fmt.Errorf("error symlinking auxiliary provider %s: %s", name, err)
+		installNames := []string{name}
+		if len(h.pluginVersions) > 0 && !strings.Contains(name, "_v") {
+			installNames = installNames[:0]
+			for _, v := range h.pluginVersions {
+				installNames = append(installNames, legacyPluginName(strings.TrimPrefix(name, "terraform-provider-"), v))
+			}
+		}
+
+		for _, installName := range installNames {
+			err := h.installPlugin(path, filepath.Join(pluginDir, installName))
+			if err != nil {
+				return fmt.Errorf("error installing auxiliary provider %s: %s", name, err)
+			}
 		}
 	}
 
@@ -165,15 +359,80 @@ func symlinkAuxiliaryProviders(pluginDir string) error {
 // helper, returning an error if any of the cleanup fails.
 //
 // Call this before returning from TestMain to minimize the amount of detritus
-// left behind in the filesystem after the tests complete.
+// left behind in the filesystem after the tests complete. This includes the
+// directory in which DiscoverConfig installed Terraform CLI, if any, and all
+// of the working directories. To keep them all for debugging instead, set the
+// environment variable TF_ACC_KEEP_TEMP_DIRS.
+//
+// If any tests were skipped using Skip, Close also prints a summary of them
+// and the reasons they were skipped, and likewise for any deprecation
+// warnings that Terraform reported while running commands and any commands
+// that succeeded only after retrying, as described for RetryPolicy. Working
+// directories recorded by CleanupRecordLeak are kept, and added to the leaked
+// resources manifest. It also writes the final metrics to
+// TF_ACC_METRICS_PATH, if set, as described for WriteMetrics. Once no other
+// helper is using it, the file named by TF_ACC_EVENTS_PATH is closed.
 func (h *Helper) Close() error {
-	if h.execTempDir != "" {
+	reportErr := h.writeReports()
+	eventsErr := h.closeEvents()
+	err := h.removeTempDirs()
+	if err != nil {
+		return err
+	}
+	if reportErr != nil {
+		return reportErr
+	}
+	return eventsErr
+}
+
+// closeEvents releases the helper's use of the file named by
+// TF_ACC_EVENTS_PATH, if any, so that it is closed once no helper needs it.
+func (h *Helper) closeEvents() error {
+	if !h.openedEvents {
+		return nil
+	}
+	h.openedEvents = false
+	if err := closeEventsFile(); err != nil {
+		return fmt.Errorf("failed to close event stream: %s", err)
+	}
+	return nil
+}
+
+// writeReports writes the reports described for Close, returning the first
+// error encountered.
+func (h *Helper) writeReports() error {
+	err := h.writeSkipReport(os.Stdout)
+	h.writeDeprecationReport(os.Stdout)
+	if flakyErr := h.writeFlakyReport(os.Stdout); flakyErr != nil && err == nil {
+		err = flakyErr
+	}
+	if leakErr := h.writeLeakReport(os.Stdout); leakErr != nil && err == nil {
+		err = leakErr
+	}
+	if metricsErr := h.writeMetricsFile(); metricsErr != nil && err == nil {
+		err = metricsErr
+	}
+	return err
+}
+
+// removeTempDirs removes the helper's temporary directories as described
+// for Close, unless TF_ACC_KEEP_TEMP_DIRS is set.
+func (h *Helper) removeTempDirs() error {
+	if os.Getenv("TF_ACC_KEEP_TEMP_DIRS") != "" {
+		fmt.Fprintf(os.Stderr, "keeping the helper's temporary directory at %s\n", h.baseDir)
+		if h.execTempDir != "" {
+			fmt.Fprintf(os.Stderr, "keeping the Terraform CLI installation directory at %s\n", h.execTempDir)
+		}
+		return nil
+	}
+
+	if h.execTempDir != "" && !h.sharedExecTempDir {
 		err := os.RemoveAll(h.execTempDir)
 		if err != nil {
 			return err
 		}
 	}
-	return os.RemoveAll(h.baseDir)
+	return h.removeBaseDir()
 }
 
 // NewWorkingDir creates a new working directory for use in the implementation
@@ -182,30 +441,77 @@ func (h *Helper) Close() error {
 // If the working directory object is not itself closed by the time the test
 // program exits, the Close method on the helper itself will attempt to
 // delete it.
-func (h *Helper) NewWorkingDir() (*WorkingDir, error) {
+func (h *Helper) NewWorkingDir() (_ *WorkingDir, err error) {
 	dir, err := ioutil.TempDir(h.baseDir, "work")
 	if err != nil {
 		return nil, err
 	}
+	defer func() {
+		if err != nil {
+			os.RemoveAll(dir)
+		}
+	}()
 
 	// symlink the provider source files into the config directory
 	// e.g. testdata
-	err = symlinkDirectoriesOnly(h.sourceDir, dir)
+	if h.sourceDir != "" {
+		err = symlinkDirectoriesOnly(h.sourceDir, dir)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	tf, err := tfexec.NewTerraform(dir, h.terraformExec)
 	if err != nil {
 		return nil, err
 	}
 
-	tf, err := tfexec.NewTerraform(dir, h.terraformExec)
+	wd := &WorkingDir{
+		h:                h,
+		tf:               tf,
+		baseDir:          dir,
+		terraformExec:    h.terraformExec,
+		diskQuota:        defaultDiskQuota(),
+		outputLimit:      defaultOutputLimit(),
+		planOnly:         h.planOnly,
+		confirm:          h.confirm,
+		confirmThreshold: h.confirmThreshold,
+		resourceLimits:   h.resourceLimits,
+		commandWrapper:   h.commandWrapper,
+		retryPolicy:      h.retryPolicy,
+		cleanupPolicy:    h.cleanupPolicy,
+		autoInit:         h.autoInit,
+	}
+
+	if h.FeatureEnabled(FeatureCaptureProviderOutput) {
+		wd.CaptureProviderOutput()
+	}
+	if h.FeatureEnabled(FeatureIsolateHome) {
+		_, err = wd.IsolateHome()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = wd.stageHelperCredentialsFiles()
+	if err != nil {
+		return nil, err
+	}
+
+	wd.providerBinaries = h.helperProviderBinaries()
+	err = wd.installProviderBinaries()
 	if err != nil {
 		return nil, err
 	}
 
-	return &WorkingDir{
-		h:             h,
-		tf:            tf,
-		baseDir:       dir,
-		terraformExec: h.terraformExec,
-	}, nil
+	if providerInstallMethodFor(h.terraformVersion) == installLegacyPluginDir {
+		err = h.installAuxiliaryProviders(wd.legacyPluginDir())
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return wd, nil
 }
 
 // RequireNewWorkingDir is a variant of NewWorkingDir that takes a TestControl
@@ -220,11 +526,38 @@ func (h *Helper) RequireNewWorkingDir(t TestControl) *WorkingDir {
 		t.Fatalf("failed to create new working directory: %s", err)
 		return nil
 	}
+	wd.testName = testName(t)
+	if failer, ok := t.(interface{ Failed() bool }); ok {
+		wd.testFailed = failer.Failed
+	}
+	if h.FeatureEnabled(FeatureLogToTest) && logVerbose(t) {
+		wd.SetTerraformLogger(func(line string) {
+			t.Log(line)
+		})
+	}
+	emitEvent(Event{Type: EventTestStarted, Test: wd.testName})
 	return wd
 }
 
+// logVerbose returns whether FeatureLogToTest should send Terraform's log to
+// the log of the test controlled by t. A TestControl can decide by
+// implementing a Verbose method; otherwise the log always goes to t.Log, and
+// "go test" shows it only in verbose mode or if the test fails.
+func logVerbose(t TestControl) bool {
+	if v, ok := t.(interface{ Verbose() bool }); ok {
+		return v.Verbose()
+	}
+	return true
+}
+
 // TerraformExecPath returns the location of the Terraform CLI executable that
 // should be used when running tests.
 func (h *Helper) TerraformExecPath() string {
 	return h.terraformExec
 }
+
+// TerraformVersion returns the version of the Terraform CLI executable that
+// should be used when running tests.
+func (h *Helper) TerraformVersion() *version.Version {
+	return h.terraformVersion
+}
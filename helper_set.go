@@ -0,0 +1,168 @@
+package tftest
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// HelperSet manages a separate Helper for each of several providers tested
+// by the same test program, such as in a repository containing several small
+// providers, so that a single TestMain can prepare all of them.
+//
+// The helpers share one Terraform CLI executable, discovered once, but each
+// has its own source directory, temporary directory and registered provider
+// binaries, so that the providers' installations in working directories
+// don't interfere. A HelperSet is safe for concurrent use.
+//
+// The reports that Helper.Close writes, such as the skip report and metrics,
+// are written once by the set's Close, combining the results of all of the
+// helpers, rather than by each helper in turn.
+type HelperSet struct {
+	config *Config
+
+	mu      sync.Mutex
+	helpers map[string]*Helper
+}
+
+// AutoInitHelperSet uses the auto-discovery behavior of DiscoverConfig to
+// find the Terraform CLI and returns a HelperSet with a helper for each of the
+// given providers, as a map from a name identifying each provider, such as
+// its type name, to its source directory.
+func AutoInitHelperSet(sourceDirs map[string]string) (*HelperSet, error) {
+	config, err := DiscoverConfig("")
+	if err != nil {
+		return nil, err
+	}
+
+	set := NewHelperSet(config)
+	for name, sourceDir := range sourceDirs {
+		if _, err := set.Add(name, sourceDir); err != nil {
+			set.Close()
+			return nil, err
+		}
+	}
+	return set, nil
+}
+
+// NewHelperSet returns an empty HelperSet whose helpers will be initialized
+// using the given configuration, apart from its SourceDir. The set takes
+// ownership of the temporary directory created by DiscoverConfig for the
+// configuration, if any, and removes it in Close.
+func NewHelperSet(config *Config) *HelperSet {
+	return &HelperSet{
+		config:  config,
+		helpers: map[string]*Helper{},
+	}
+}
+
+// Add initializes a helper for the provider with the given name, whose source
+// code is in sourceDir, and adds it to the set.
+func (s *HelperSet) Add(name, sourceDir string) (*Helper, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, exists := s.helpers[name]; exists {
+		return nil, fmt.Errorf("helper set already has a helper for %q", name)
+	}
+
+	config := *s.config
+	config.SourceDir = sourceDir
+	h, err := InitHelper(&config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize helper for %q: %s", name, err)
+	}
+	h.sharedExecTempDir = true
+	s.helpers[name] = h
+	return h, nil
+}
+
+// Helper returns the helper for the provider with the given name, or nil if
+// the set has none.
+func (s *HelperSet) Helper(name string) *Helper {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.helpers[name]
+}
+
+// Names returns the names of all of the providers in the set, in
+// lexicographical order.
+func (s *HelperSet) Names() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	names := make([]string, 0, len(s.helpers))
+	for name := range s.helpers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Close writes the combined reports of all of the helpers in the set, as
+// described for Helper.Close, then closes the helpers and removes the
+// Terraform CLI installation directory they shared, returning an error
+// describing any of the cleanup that failed.
+func (s *HelperSet) Close() error {
+	var problems []string
+	names := s.Names()
+	merged := &Helper{}
+	for _, name := range names {
+		merged.mergeReports(s.Helper(name))
+	}
+	if err := merged.writeReports(); err != nil {
+		problems = append(problems, err.Error())
+	}
+	for _, name := range names {
+		if err := s.Helper(name).closeEvents(); err != nil {
+			problems = append(problems, fmt.Sprintf("%s: %s", name, err))
+		}
+		if err := s.Helper(name).removeTempDirs(); err != nil {
+			problems = append(problems, fmt.Sprintf("%s: %s", name, err))
+		}
+	}
+
+	if s.config.execTempDir != "" && os.Getenv("TF_ACC_KEEP_TEMP_DIRS") == "" {
+		if err := os.RemoveAll(s.config.execTempDir); err != nil {
+			problems = append(problems, err.Error())
+		}
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("failed to close helper set:\n  %s", strings.Join(problems, "\n  "))
+	}
+	return nil
+}
+
+// mergeReports adds the results recorded by the given helper for its reports
+// to those of h.
+func (h *Helper) mergeReports(other *Helper) {
+	h.skips = append(h.skips, other.SkippedTests()...)
+	h.flaky = append(h.flaky, other.FlakyCandidates()...)
+	h.leaks = append(h.leaks, other.LeakedResources()...)
+
+	if h.deprecations == nil {
+		h.deprecations = map[string]int{}
+	}
+	for summary, n := range other.DeprecationWarnings() {
+		h.deprecations[summary] += n
+	}
+
+	other.metricsMu.Lock()
+	defer other.metricsMu.Unlock()
+	if h.commandMetrics == nil {
+		h.commandMetrics = map[string]*commandMetrics{}
+	}
+	for name, om := range other.commandMetrics {
+		m := h.commandMetrics[name]
+		if m == nil {
+			m = &commandMetrics{bucketCounts: make([]int, len(metricsDurationBuckets))}
+			h.commandMetrics[name] = m
+		}
+		m.count += om.count
+		m.failures += om.failures
+		m.durationSum += om.durationSum
+		for i, n := range om.bucketCounts {
+			m.bucketCounts[i] += n
+		}
+	}
+}
@@ -0,0 +1,131 @@
+package tftest
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// ResourceAttributes returns the attribute values of the resource instance
+// with the given address in the current state, as decoded from JSON.
+//
+// In legacy mode the attributes are those of LegacyState, flattened and all
+// strings.
+func (wd *WorkingDir) ResourceAttributes(address string) (map[string]interface{}, error) {
+	if wd.h.LegacyMode() {
+		return wd.legacyResourceAttributes(address)
+	}
+	raw, err := wd.RawState()
+	if err != nil {
+		return nil, err
+	}
+
+	var state struct {
+		Values struct {
+			RootModule jsonStateModule `json:"root_module"`
+		} `json:"values"`
+	}
+	err = json.Unmarshal(raw, &state)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode state: %w", err)
+	}
+
+	values, ok := state.Values.RootModule.resourceValues(address)
+	if !ok {
+		return nil, fmt.Errorf("no resource instance %s in state", address)
+	}
+	return values, nil
+}
+
+// RequireResourceAttributes is a variant of ResourceAttributes that will fail
+// the test via the given TestControl if the instance is not in the state.
+func (wd *WorkingDir) RequireResourceAttributes(t TestControl, address string) map[string]interface{} {
+	t.Helper()
+	ret, err := wd.ResourceAttributes(address)
+	if err != nil {
+		t := testingT{t}
+		t.Fatalf("failed to read attributes: %s", err)
+	}
+	return ret
+}
+
+// ImportResource imports the object with the given ID to the given address,
+// as with Import, and then returns the attributes that the provider's import
+// and subsequent read recorded for it in the state.
+func (wd *WorkingDir) ImportResource(address, id string) (map[string]interface{}, error) {
+	if err := wd.Import(address, id); err != nil {
+		return nil, err
+	}
+	return wd.ResourceAttributes(address)
+}
+
+// RequireImportResource is a variant of ImportResource that will fail the test
+// via the given TestControl if the import fails.
+func (wd *WorkingDir) RequireImportResource(t TestControl, address, id string) map[string]interface{} {
+	t.Helper()
+	ret, err := wd.ImportResource(address, id)
+	if err != nil {
+		t := testingT{t}
+		t.Fatalf("failed to import: %s", err)
+	}
+	return ret
+}
+
+// ImportVerify checks that importing the existing resource instance with the
+// given address, which must have been created by an earlier apply, produces
+// the same attributes in the state as creating it did. It removes the
+// instance from the state, imports it again using the given ID, and compares
+// the attribute values from before and after.
+//
+// The attribute values are compared with Compare using the given options,
+// which may be nil. Ignore paths in the options can exclude attributes the
+// provider cannot read back from the remote API, such as passwords, including
+// nested ones, and Normalize can tolerate values the API reformats. An error
+// lists all of the differences.
+//
+// The imported instance is left in the state, so that the object can be
+// destroyed as usual even if the verification fails. If the import itself
+// fails, the state from before the removal is restored instead.
+func (wd *WorkingDir) ImportVerify(address, id string, opts *CompareOptions) error {
+	before, err := wd.ResourceAttributes(address)
+	if err != nil {
+		return err
+	}
+	backup, err := wd.StatePull()
+	if err != nil {
+		return err
+	}
+	if err := wd.StateRm(address); err != nil {
+		return err
+	}
+	after, err := wd.ImportResource(address, id)
+	if err != nil {
+		if pushErr := wd.StatePush(backup); pushErr != nil {
+			return fmt.Errorf("%s; additionally, failed to restore %s to the state, so it must be destroyed manually: %s", err, address, pushErr)
+		}
+		return err
+	}
+
+	diffs, err := Compare(before, after, opts)
+	if err != nil {
+		return err
+	}
+	if len(diffs) > 0 {
+		lines := make([]string, len(diffs))
+		for i, d := range diffs {
+			lines[i] = d.String()
+		}
+		return fmt.Errorf("imported %s differs from the created instance:\n  %s", address, strings.Join(lines, "\n  "))
+	}
+	return nil
+}
+
+// RequireImportVerify is a variant of ImportVerify that will fail the test via
+// the given TestControl if the import fails or the attributes differ.
+func (wd *WorkingDir) RequireImportVerify(t TestControl, address, id string, opts *CompareOptions) {
+	t.Helper()
+	if err := wd.ImportVerify(address, id, opts); err != nil {
+		t := testingT{t}
+		t.Fatalf("import verification failed: %s", err)
+	}
+}
@@ -0,0 +1,99 @@
+package tftest
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// initCommands are the Terraform subcommands which need the working
+// directory to have been initialized, and which otherwise fail with errors
+// about missing providers or backends that don't suggest the cause.
+var initCommands = map[string]bool{
+	"plan":             true,
+	"apply":            true,
+	"destroy":          true,
+	"import":           true,
+	"refresh":          true,
+	"validate":         true,
+	"console":          true,
+	"graph":            true,
+	"taint":            true,
+	"untaint":          true,
+	"state mv":         true,
+	"state rm":         true,
+	"state pull":       true,
+	"state push":       true,
+	"workspace new":    true,
+	"workspace delete": true,
+	"workspace list":   true,
+	"providers lock":   true,
+	"providers schema": true,
+}
+
+// SetAutoInit sets whether the working directory runs Init automatically
+// before any command that needs it, if Init has not yet been run for the
+// current configuration. When disabled, which is the default, those commands
+// instead fail immediately if Init has never been run.
+func (wd *WorkingDir) SetAutoInit(enabled bool) {
+	wd.autoInit = enabled
+}
+
+// SetAutoInit sets whether the working directories created by the helper
+// from now on run Init automatically, as described for
+// WorkingDir.SetAutoInit.
+func (h *Helper) SetAutoInit(enabled bool) {
+	h.autoInit = enabled
+}
+
+// ReInit runs "terraform init" again, even if it has already been run for the
+// current configuration, for example after changing a module outside of the
+// working directory.
+func (wd *WorkingDir) ReInit() error {
+	wd.initSum = nil
+	return wd.Init()
+}
+
+// RequireReInit is a variant of ReInit that will fail the test via the given
+// TestControl if init fails.
+func (wd *WorkingDir) RequireReInit(t TestControl) {
+	t.Helper()
+	if err := wd.ReInit(); err != nil {
+		t := testingT{t}
+		t.Fatalf("init failed: %s", err)
+	}
+}
+
+// markInitialized records that init succeeded for the current configuration.
+func (wd *WorkingDir) markInitialized() {
+	sum, _ := fileChecksum(wd.configFilename())
+	if sum == nil {
+		sum = []byte{}
+	}
+	wd.initSum = sum
+}
+
+// initCurrent returns whether init has succeeded since the configuration was
+// last changed.
+func (wd *WorkingDir) initCurrent() bool {
+	if wd.initSum == nil {
+		return false
+	}
+	sum, _ := fileChecksum(wd.configFilename())
+	return bytes.Equal(sum, wd.initSum)
+}
+
+// ensureInitialized runs Init before the command with the given name if
+// auto-init is enabled, or otherwise returns an error if the command needs
+// the working directory to be initialized and it never has been.
+func (wd *WorkingDir) ensureInitialized(name string) error {
+	if !initCommands[name] {
+		return nil
+	}
+	if wd.autoInit {
+		return wd.Init()
+	}
+	if wd.initSum == nil {
+		return fmt.Errorf("working directory not initialized: call Init before running terraform %s", name)
+	}
+	return nil
+}
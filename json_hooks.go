@@ -0,0 +1,39 @@
+package tftest
+
+import (
+	"fmt"
+)
+
+// Kinds of JSON document passed to a JSONDecodeHook.
+const (
+	JSONKindPlan  = "plan"
+	JSONKindState = "state"
+)
+
+// JSONDecodeHook is a function that is called with the raw JSON document each
+// time a WorkingDir decodes a plan or state into the typed representation
+// from terraform-json. The kind argument is one of the JSONKind constants.
+//
+// The typed representation silently ignores any properties it doesn't know
+// about, which can happen when newer Terraform CLI versions add information
+// faster than this package is updated. A hook can decode the raw document
+// itself to retain that extra information. If a hook returns an error then
+// the operation that decoded the document fails with that error.
+type JSONDecodeHook func(kind string, raw []byte) error
+
+// AddJSONDecodeHook registers a hook to be called each time the working
+// directory decodes a plan or state.
+func (wd *WorkingDir) AddJSONDecodeHook(hook JSONDecodeHook) {
+	wd.jsonDecodeHooks = append(wd.jsonDecodeHooks, hook)
+}
+
+// runJSONDecodeHooks passes a raw JSON document of the given kind to each of
+// the registered decode hooks.
+func (wd *WorkingDir) runJSONDecodeHooks(kind string, raw string) error {
+	for _, hook := range wd.jsonDecodeHooks {
+		if err := hook(kind, []byte(raw)); err != nil {
+			return fmt.Errorf("%s decode hook failed: %w", kind, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,49 @@
+package tftest
+
+import (
+	"sort"
+	"strings"
+)
+
+// SetLabel attaches a label to the working directory, such as the resource
+// type under test, a region, or a ticket ID, so that large test suites can
+// slice their results by metadata. Labels are recorded with each command in
+// the command history, included in events, and used in the keys of stored
+// artifacts.
+//
+// Setting a label to an empty value removes it.
+func (wd *WorkingDir) SetLabel(key, value string) {
+	if value == "" {
+		delete(wd.labels, key)
+		return
+	}
+	if wd.labels == nil {
+		wd.labels = map[string]string{}
+	}
+	wd.labels[key] = value
+}
+
+// Labels returns the labels attached to the working directory using
+// SetLabel.
+func (wd *WorkingDir) Labels() map[string]string {
+	if len(wd.labels) == 0 {
+		return nil
+	}
+	ret := make(map[string]string, len(wd.labels))
+	for k, v := range wd.labels {
+		ret[k] = v
+	}
+	return ret
+}
+
+// labelsString renders the working directory's labels in a stable form
+// suitable for use in file and object names, such as
+// "region=us-east-1,type=aws_instance".
+func (wd *WorkingDir) labelsString() string {
+	pairs := make([]string, 0, len(wd.labels))
+	for k, v := range wd.labels {
+		pairs = append(pairs, k+"="+v)
+	}
+	sort.Strings(pairs)
+	return strings.NewReplacer("/", "_", "\\", "_").Replace(strings.Join(pairs, ","))
+}
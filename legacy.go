@@ -0,0 +1,144 @@
+package tftest
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// LegacyMode returns true if the helper runs Terraform v0.11, as allowed by
+// Config.LegacyTerraform.
+//
+// Terraform v0.11 has no JSON representation of plans, state or schemas, so
+// in legacy mode the methods that return them, such as State, RawState,
+// SavedPlan and Schemas, return an error, as do the features that depend on
+// them, such as plan gates and confirmation callbacks. Tests can instead
+// inspect the state with LegacyState or ResourceAttributes, which reads the
+// legacy state, and the plan with SavedPlanStdout. Diagnostics are parsed
+// from the legacy error output, and provider plugins are installed in the
+// working directory's plugin directory as for Terraform v0.12.
+func (h *Helper) LegacyMode() bool {
+	return h.terraformVersion.LessThan(minTerraformVersion)
+}
+
+// checkJSONOutput returns an error if the Terraform version under test can't
+// produce the JSON output needed for the given purpose.
+func (wd *WorkingDir) checkJSONOutput(what string) error {
+	if !wd.h.LegacyMode() {
+		return nil
+	}
+	return fmt.Errorf("%s requires the JSON output added in Terraform v%s, but this is v%s in legacy mode", what, minTerraformVersion, wd.h.terraformVersion)
+}
+
+// LegacyState is the state in the format written by Terraform v0.11, as
+// returned by WorkingDir.LegacyState.
+type LegacyState struct {
+	Version          int                 `json:"version"`
+	TerraformVersion string              `json:"terraform_version"`
+	Serial           int64               `json:"serial"`
+	Lineage          string              `json:"lineage"`
+	Modules          []LegacyModuleState `json:"modules"`
+}
+
+// LegacyModuleState is the state of a single module in a LegacyState.
+type LegacyModuleState struct {
+	// Path is the path of the module, starting with "root".
+	Path []string `json:"path"`
+
+	Outputs map[string]LegacyOutputState `json:"outputs"`
+
+	// Resources are the resource instances in the module, keyed by their
+	// address within the module, such as "aws_instance.foo.0" or
+	// "data.aws_ami.foo".
+	Resources map[string]LegacyResourceState `json:"resources"`
+}
+
+// LegacyOutputState is the value of an output in a LegacyModuleState.
+type LegacyOutputState struct {
+	Sensitive bool        `json:"sensitive"`
+	Type      string      `json:"type"`
+	Value     interface{} `json:"value"`
+}
+
+// LegacyResourceState is the state of a single resource instance in a
+// LegacyModuleState.
+type LegacyResourceState struct {
+	Type     string               `json:"type"`
+	Provider string               `json:"provider"`
+	Primary  *LegacyInstanceState `json:"primary"`
+}
+
+// LegacyInstanceState is the state of the primary object of a resource
+// instance in a LegacyResourceState. Its attributes are flattened, as in
+// "tags.%" and "tags.Name".
+type LegacyInstanceState struct {
+	ID         string            `json:"id"`
+	Attributes map[string]string `json:"attributes"`
+	Tainted    bool              `json:"tainted"`
+}
+
+// LegacyState returns the current state in the format written by Terraform
+// v0.11, as read with "terraform state pull". It returns an error for state
+// in any other format, which can be read with State instead.
+func (wd *WorkingDir) LegacyState() (*LegacyState, error) {
+	raw, err := wd.StatePull()
+	if err != nil {
+		return nil, err
+	}
+	var ret LegacyState
+	if err := json.Unmarshal(raw, &ret); err != nil {
+		return nil, fmt.Errorf("failed to decode state: %w", err)
+	}
+	if ret.Version != 3 {
+		return nil, fmt.Errorf("unsupported state format version %d: LegacyState supports only version 3, written by Terraform v0.11", ret.Version)
+	}
+	return &ret, nil
+}
+
+// RequireLegacyState is a variant of LegacyState that will fail the test via
+// the given TestControl if the state cannot be read.
+func (wd *WorkingDir) RequireLegacyState(t TestControl) *LegacyState {
+	t.Helper()
+	ret, err := wd.LegacyState()
+	if err != nil {
+		t := testingT{t}
+		t.Fatalf("failed to read legacy state: %s", err)
+	}
+	return ret
+}
+
+// Resource returns the state of the resource instance with the given
+// address, such as "module.foo.aws_instance.bar", or nil if there is none.
+func (s *LegacyState) Resource(address string) *LegacyResourceState {
+	for _, m := range s.Modules {
+		prefix := ""
+		for _, name := range m.Path[1:] {
+			prefix += "module." + name + "."
+		}
+		if !strings.HasPrefix(address, prefix) {
+			continue
+		}
+		if r, ok := m.Resources[strings.TrimPrefix(address, prefix)]; ok {
+			return &r
+		}
+	}
+	return nil
+}
+
+// legacyResourceAttributes implements ResourceAttributes in legacy mode,
+// returning the flattened attributes as strings.
+func (wd *WorkingDir) legacyResourceAttributes(address string) (map[string]interface{}, error) {
+	state, err := wd.LegacyState()
+	if err != nil {
+		return nil, err
+	}
+	r := state.Resource(address)
+	if r == nil || r.Primary == nil {
+		return nil, fmt.Errorf("no resource instance %s in state", address)
+	}
+	ret := make(map[string]interface{}, len(r.Primary.Attributes))
+	for k, v := range r.Primary.Attributes {
+		ret[k] = v
+	}
+	return ret, nil
+}
@@ -0,0 +1,152 @@
+package tftest
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// metricsDurationBuckets are the upper bounds, in seconds, of the buckets in
+// the command duration histogram. Acceptance test commands range from under a
+// second to the better part of an hour.
+var metricsDurationBuckets = []float64{1, 5, 10, 30, 60, 120, 300, 600, 1200, 1800, 3600}
+
+// commandMetrics accumulates the metrics for one Terraform subcommand.
+type commandMetrics struct {
+	count        int
+	failures     int
+	bucketCounts []int
+	durationSum  float64
+}
+
+// recordCommandMetrics adds the given completed command to the helper's
+// metrics.
+func (h *Helper) recordCommandMetrics(cmd Command) {
+	h.metricsMu.Lock()
+	defer h.metricsMu.Unlock()
+	if h.commandMetrics == nil {
+		h.commandMetrics = map[string]*commandMetrics{}
+	}
+	m := h.commandMetrics[cmd.Name]
+	if m == nil {
+		m = &commandMetrics{bucketCounts: make([]int, len(metricsDurationBuckets))}
+		h.commandMetrics[cmd.Name] = m
+	}
+
+	m.count++
+	if cmd.Err != nil {
+		m.failures++
+	}
+	secs := cmd.Duration.Seconds()
+	m.durationSum += secs
+	for i, le := range metricsDurationBuckets {
+		if secs <= le {
+			m.bucketCounts[i]++
+		}
+	}
+}
+
+// WriteMetrics writes metrics about the run so far to w, in the Prometheus
+// text exposition format. The metrics include the number of Terraform commands
+// run and failed and a histogram of their durations, each by subcommand, and
+// the number of tests skipped using Skip, by reason.
+//
+// If the environment variable TF_ACC_METRICS_PATH is set then Close also
+// writes the final metrics to the named file, which is suitable for the
+// textfile collector of the Prometheus node exporter. To instead expose live
+// metrics during a long run, serve MetricsHandler.
+func (h *Helper) WriteMetrics(w io.Writer) error {
+	var buf bytes.Buffer
+
+	h.metricsMu.Lock()
+	names := make([]string, 0, len(h.commandMetrics))
+	for name := range h.commandMetrics {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	fmt.Fprintln(&buf, "# HELP tftest_commands_total Terraform CLI commands run.")
+	fmt.Fprintln(&buf, "# TYPE tftest_commands_total counter")
+	for _, name := range names {
+		fmt.Fprintf(&buf, "tftest_commands_total{command=%q} %d\n", name, h.commandMetrics[name].count)
+	}
+	fmt.Fprintln(&buf, "# HELP tftest_command_failures_total Terraform CLI commands which returned an error.")
+	fmt.Fprintln(&buf, "# TYPE tftest_command_failures_total counter")
+	for _, name := range names {
+		fmt.Fprintf(&buf, "tftest_command_failures_total{command=%q} %d\n", name, h.commandMetrics[name].failures)
+	}
+	fmt.Fprintln(&buf, "# HELP tftest_command_duration_seconds Time taken by Terraform CLI commands.")
+	fmt.Fprintln(&buf, "# TYPE tftest_command_duration_seconds histogram")
+	for _, name := range names {
+		m := h.commandMetrics[name]
+		for i, le := range metricsDurationBuckets {
+			fmt.Fprintf(&buf, "tftest_command_duration_seconds_bucket{command=%q,le=\"%g\"} %d\n", name, le, m.bucketCounts[i])
+		}
+		fmt.Fprintf(&buf, "tftest_command_duration_seconds_bucket{command=%q,le=\"+Inf\"} %d\n", name, m.count)
+		fmt.Fprintf(&buf, "tftest_command_duration_seconds_sum{command=%q} %g\n", name, m.durationSum)
+		fmt.Fprintf(&buf, "tftest_command_duration_seconds_count{command=%q} %d\n", name, m.count)
+	}
+	h.metricsMu.Unlock()
+
+	skips := map[SkipReason]int{}
+	for _, skip := range h.SkippedTests() {
+		skips[skip.Reason]++
+	}
+	reasons := make([]string, 0, len(skips))
+	for reason := range skips {
+		reasons = append(reasons, string(reason))
+	}
+	sort.Strings(reasons)
+	fmt.Fprintln(&buf, "# HELP tftest_skipped_tests_total Tests skipped using Skip.")
+	fmt.Fprintln(&buf, "# TYPE tftest_skipped_tests_total counter")
+	for _, reason := range reasons {
+		fmt.Fprintf(&buf, "tftest_skipped_tests_total{reason=%q} %d\n", reason, skips[SkipReason(reason)])
+	}
+
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// MetricsHandler returns an HTTP handler which serves the metrics described
+// for WriteMetrics, for scraping by Prometheus during a long-running test
+// pipeline.
+func (h *Helper) MetricsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		h.WriteMetrics(w)
+	})
+}
+
+// writeMetricsFile writes the metrics to the file named in
+// TF_ACC_METRICS_PATH, if set.
+func (h *Helper) writeMetricsFile() error {
+	p := os.Getenv("TF_ACC_METRICS_PATH")
+	if p == "" {
+		return nil
+	}
+
+	// The textfile collector may read the file at any time, so we write it
+	// under a temporary name and then rename it into place.
+	f, err := ioutil.TempFile(filepath.Dir(p), ".tftest-metrics")
+	if err != nil {
+		return fmt.Errorf("failed to write metrics: %w", err)
+	}
+	err = h.WriteMetrics(f)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), p)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return fmt.Errorf("failed to write metrics: %w", err)
+	}
+	emitEvent(Event{Type: EventArtifactWritten, Path: p})
+	return nil
+}
@@ -0,0 +1,88 @@
+package tftest
+
+import (
+	"net/http"
+	"net/http/httptest"
+)
+
+// MockEndpoint is a mock of a remote API, such as an httptest server or a
+// container running a local emulator, whose lifetime can be tied to a
+// working directory using WorkingDir.StartMockEndpoint.
+type MockEndpoint interface {
+	// Start starts the mock and returns the URL at which it can be reached.
+	Start() (string, error)
+
+	// Stop stops the mock and releases any resources it is using.
+	Stop() error
+}
+
+// HTTPTestEndpoint returns a MockEndpoint which serves the given handler
+// using an httptest.Server, listening on a port reserved with
+// ListenReserved.
+func HTTPTestEndpoint(handler http.Handler) MockEndpoint {
+	return &httpTestEndpoint{handler: handler}
+}
+
+type httpTestEndpoint struct {
+	handler http.Handler
+	server  *httptest.Server
+}
+
+func (e *httpTestEndpoint) Start() (string, error) {
+	l, err := ListenReserved()
+	if err != nil {
+		return "", err
+	}
+	e.server = httptest.NewUnstartedServer(e.handler)
+	e.server.Listener.Close()
+	e.server.Listener = l
+	e.server.Start()
+	return e.server.URL, nil
+}
+
+func (e *httpTestEndpoint) Stop() error {
+	e.server.Close()
+	return nil
+}
+
+// StartMockEndpoint starts the given mock endpoint and sets the root module
+// input variable with the given name to its URL, so that the configuration
+// can use the variable to point the provider at the mock. The endpoint is
+// stopped when the working directory is closed.
+//
+// The URL is also returned, for configurations that need it in some other
+// form.
+func (wd *WorkingDir) StartMockEndpoint(variable string, endpoint MockEndpoint) (string, error) {
+	url, err := endpoint.Start()
+	if err != nil {
+		return "", err
+	}
+	wd.mockEndpoints = append(wd.mockEndpoints, endpoint)
+	wd.SetVariable(variable, url)
+	return url, nil
+}
+
+// RequireStartMockEndpoint is a variant of StartMockEndpoint that will fail
+// the test via the given TestControl if the endpoint cannot be started.
+func (wd *WorkingDir) RequireStartMockEndpoint(t TestControl, variable string, endpoint MockEndpoint) string {
+	t.Helper()
+	ret, err := wd.StartMockEndpoint(variable, endpoint)
+	if err != nil {
+		t := testingT{t}
+		t.Fatalf("failed to start mock endpoint: %s", err)
+	}
+	return ret
+}
+
+// stopMockEndpoints stops all of the endpoints started with
+// StartMockEndpoint, returning the first error encountered.
+func (wd *WorkingDir) stopMockEndpoints() error {
+	var ret error
+	for _, endpoint := range wd.mockEndpoints {
+		if err := endpoint.Stop(); err != nil && ret == nil {
+			ret = err
+		}
+	}
+	wd.mockEndpoints = nil
+	return ret
+}
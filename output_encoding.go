@@ -0,0 +1,80 @@
+package tftest
+
+import (
+	"bytes"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// normalizeOutputEncoding returns the given output captured from a Terraform
+// command as UTF-8 with LF line endings, so that the output is parsed and
+// matched in the same way on all platforms.
+//
+// On Windows, Terraform and its plugins write CRLF line endings, and output
+// that passes through a console or a shell wrapper may be re-encoded as
+// UTF-16 with a byte order mark, or in the console's legacy code page. The
+// latter can't be decoded without knowing the code page, so any invalid
+// UTF-8 is replaced with U+FFFD rather than decoded.
+func normalizeOutputEncoding(out []byte) string {
+	enc, bomLen := detectOutputEncoding(out)
+	return enc.normalize(out[bomLen:])
+}
+
+// outputEncoding is an encoding detected from the byte order mark at the
+// start of a command's output.
+type outputEncoding int
+
+const (
+	outputUTF8 outputEncoding = iota
+	outputUTF16LE
+	outputUTF16BE
+)
+
+// detectOutputEncoding returns the encoding of the given output, as
+// indicated by its byte order mark, along with the length of that mark.
+func detectOutputEncoding(out []byte) (outputEncoding, int) {
+	switch {
+	case bytes.HasPrefix(out, []byte{0xff, 0xfe}):
+		return outputUTF16LE, 2
+	case bytes.HasPrefix(out, []byte{0xfe, 0xff}):
+		return outputUTF16BE, 2
+	case bytes.HasPrefix(out, []byte{0xef, 0xbb, 0xbf}):
+		return outputUTF8, 3
+	}
+	return outputUTF8, 0
+}
+
+// normalize returns the given output in this encoding, without any byte
+// order mark, as UTF-8 with LF line endings.
+func (enc outputEncoding) normalize(out []byte) string {
+	switch enc {
+	case outputUTF16LE:
+		out = decodeUTF16(out, false)
+	case outputUTF16BE:
+		out = decodeUTF16(out, true)
+	}
+
+	s := string(out)
+	if !utf8.ValidString(s) {
+		s = strings.ToValidUTF8(s, string(utf8.RuneError))
+	}
+	if strings.Contains(s, "\r\n") {
+		s = strings.Replace(s, "\r\n", "\n", -1)
+	}
+	return s
+}
+
+// decodeUTF16 returns the given UTF-16 text, without its byte order mark, as
+// UTF-8.
+func decodeUTF16(b []byte, bigEndian bool) []byte {
+	units := make([]uint16, len(b)/2)
+	for i := range units {
+		if bigEndian {
+			units[i] = uint16(b[2*i])<<8 | uint16(b[2*i+1])
+		} else {
+			units[i] = uint16(b[2*i+1])<<8 | uint16(b[2*i])
+		}
+	}
+	return []byte(string(utf16.Decode(units)))
+}
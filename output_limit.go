@@ -0,0 +1,143 @@
+package tftest
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+)
+
+// defaultOutputLimitBytes is the output limit used when TF_ACC_OUTPUT_LIMIT
+// is not set.
+const defaultOutputLimitBytes = 16 << 20
+
+// SetOutputLimit limits how much of each Terraform command's stdout and
+// stderr the working directory retains, in bytes, including in the command
+// history and in error messages. Output beyond the limit is discarded from
+// the middle, keeping the first and last halves, and replaced with a marker
+// saying how much was removed.
+//
+// This protects test tooling from runaway output, such as provider TRACE
+// logging written to stderr. The output of commands whose JSON result is
+// decoded by this package, such as State, is never truncated because that
+// would make it unusable. A limit of zero or less disables truncation. The
+// default limit of 16MiB can be overridden in bytes using the environment
+// variable TF_ACC_OUTPUT_LIMIT.
+func (wd *WorkingDir) SetOutputLimit(bytes int) {
+	wd.outputLimit = bytes
+}
+
+// defaultOutputLimit returns the limit set in TF_ACC_OUTPUT_LIMIT, or the
+// default limit if it is unset or invalid.
+func defaultOutputLimit() int {
+	limit, err := strconv.Atoi(os.Getenv("TF_ACC_OUTPUT_LIMIT"))
+	if err != nil {
+		return defaultOutputLimitBytes
+	}
+	return limit
+}
+
+// limitedBuffer is an io.Writer which keeps only the first and last parts of
+// what is written to it once it exceeds its limit.
+type limitedBuffer struct {
+	limit int
+	head  []byte
+	tail  []byte
+	total int64
+}
+
+func newLimitedBuffer(limit int) *limitedBuffer {
+	return &limitedBuffer{limit: limit}
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	n := len(p)
+	b.total += int64(n)
+	if b.limit <= 0 {
+		b.head = append(b.head, p...)
+		return n, nil
+	}
+
+	headLimit := b.limit / 2
+	if room := headLimit - len(b.head); room > 0 {
+		if room > len(p) {
+			room = len(p)
+		}
+		b.head = append(b.head, p[:room]...)
+		p = p[room:]
+	}
+
+	// The tail is allowed to grow to twice its limit before the excess is
+	// discarded, so that many small writes don't each move the whole tail.
+	tailLimit := b.limit - headLimit
+	b.tail = append(b.tail, p...)
+	if len(b.tail) > 2*tailLimit {
+		b.tail = b.tail[:copy(b.tail, b.tail[len(b.tail)-tailLimit:])]
+	}
+	return n, nil
+}
+
+// tailBytes returns the part of the tail within the limit.
+func (b *limitedBuffer) tailBytes() []byte {
+	if tailLimit := b.limit - b.limit/2; b.limit > 0 && len(b.tail) > tailLimit {
+		return b.tail[len(b.tail)-tailLimit:]
+	}
+	return b.tail
+}
+
+// Truncated returns the number of bytes discarded from the middle of the
+// output.
+func (b *limitedBuffer) Truncated() int64 {
+	return b.total - int64(len(b.head)+len(b.tailBytes()))
+}
+
+// String returns what was written to the buffer, normalized to UTF-8 with LF
+// line endings as described for normalizeOutputEncoding.
+func (b *limitedBuffer) String() string {
+	n := b.Truncated()
+	if n == 0 {
+		out := make([]byte, 0, len(b.head)+len(b.tail))
+		out = append(append(out, b.head...), b.tail...)
+		return normalizeOutputEncoding(out)
+	}
+
+	// The tail continues the output that began with the head, so it is
+	// decoded using the encoding detected there, starting on a UTF-16 code
+	// unit boundary if necessary.
+	enc, bomLen := detectOutputEncoding(b.head)
+	tail := b.tailBytes()
+	if enc != outputUTF8 && (b.total-int64(len(tail)))%2 != 0 {
+		tail = tail[1:]
+	}
+	return fmt.Sprintf("%s\n\n[... %d bytes truncated ...]\n\n%s", enc.normalize(b.head[bomLen:]), n, enc.normalize(tail))
+}
+
+// truncatedError wraps an error whose message has been shortened to the
+// output limit, so that errors embedding a command's full stderr stay a
+// reasonable size while remaining inspectable with errors.As.
+type truncatedError struct {
+	msg string
+	err error
+}
+
+func (e *truncatedError) Error() string {
+	return e.msg
+}
+
+func (e *truncatedError) Unwrap() error {
+	return e.err
+}
+
+// limitError returns err with its message truncated to the given limit, if
+// it is longer.
+func limitError(err error, limit int) error {
+	if err == nil || limit <= 0 {
+		return err
+	}
+	msg := err.Error()
+	if len(msg) <= limit {
+		return err
+	}
+	b := newLimitedBuffer(limit)
+	b.Write([]byte(msg))
+	return &truncatedError{msg: b.String(), err: err}
+}
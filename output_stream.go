@@ -0,0 +1,84 @@
+package tftest
+
+import (
+	"bytes"
+	"io"
+	"sync"
+)
+
+// SetOutputWriters makes the working directory copy everything each
+// Terraform command writes to stdout and stderr to the given writers as the
+// command runs, in addition to capturing it as usual. Either writer may be
+// nil to stop copying that stream.
+//
+// The stdout of commands whose output the helper decodes as data, such as
+// the JSON representations of plans and state, is not copied.
+func (wd *WorkingDir) SetOutputWriters(stdout, stderr io.Writer) {
+	wd.streamStdoutW, wd.streamStderrW = stdout, stderr
+}
+
+// StreamOutput makes the working directory log each line that Terraform
+// commands write to stdout or stderr via the given TestControl as soon as it
+// is written, rather than only reporting stderr after a failure, so that the
+// progress of long-running operations can be followed as the test runs.
+//
+// Both streams are logged through a single writer, so that lines from
+// stdout and stderr appear in the order they were written.
+func (wd *WorkingDir) StreamOutput(t TestControl) {
+	w := &lineLogger{t: t}
+	wd.SetOutputWriters(w, w)
+}
+
+// streamOutput returns the given capture buffers combined with the writers
+// set with SetOutputWriters, if any, and a function to call once the command
+// completes to flush any incomplete final lines. Stdout is copied only if
+// the command's stdout is human-readable.
+func (wd *WorkingDir) streamOutput(stdout, stderr io.Writer, humanStdout bool) (io.Writer, io.Writer, func()) {
+	flush := func() {
+		for _, w := range []io.Writer{wd.streamStdoutW, wd.streamStderrW} {
+			if l, ok := w.(*lineLogger); ok {
+				l.Flush()
+			}
+		}
+	}
+	if wd.streamStdoutW != nil && humanStdout {
+		stdout = io.MultiWriter(stdout, wd.streamStdoutW)
+	}
+	if wd.streamStderrW != nil {
+		stderr = io.MultiWriter(stderr, wd.streamStderrW)
+	}
+	return stdout, stderr, flush
+}
+
+// lineLogger is an io.Writer which logs each complete line written to it via
+// a TestControl.
+type lineLogger struct {
+	t   TestControl
+	mu  sync.Mutex
+	buf []byte
+}
+
+func (l *lineLogger) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.buf = append(l.buf, p...)
+	for {
+		i := bytes.IndexByte(l.buf, '\n')
+		if i < 0 {
+			break
+		}
+		l.t.Log(string(l.buf[:i]))
+		l.buf = l.buf[i+1:]
+	}
+	return len(p), nil
+}
+
+// Flush logs any incomplete final line.
+func (l *lineLogger) Flush() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if len(l.buf) > 0 {
+		l.t.Log(string(l.buf))
+		l.buf = nil
+	}
+}
@@ -0,0 +1,97 @@
+package tftest
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+)
+
+// OutputValue is the value of a root module output, as returned by Outputs.
+type OutputValue struct {
+	// Value is the JSON encoding of the output value.
+	Value json.RawMessage
+
+	// Type is the JSON encoding of the output value's Terraform type, such
+	// as "string" or ["list","number"].
+	Type json.RawMessage
+
+	// Sensitive is true if the output is marked as sensitive. Its value is
+	// included regardless.
+	Sensitive bool
+}
+
+// Unmarshal decodes the output value into the Go value pointed to by target,
+// as with json.Unmarshal.
+func (v OutputValue) Unmarshal(target interface{}) error {
+	return json.Unmarshal(v.Value, target)
+}
+
+// Outputs runs "terraform output -json" in the working directory and returns
+// the values of all of the root module outputs in the current state, by name.
+//
+// This avoids finding the outputs in the complete state returned by State,
+// where they are available only as untyped values.
+func (wd *WorkingDir) Outputs() (map[string]OutputValue, error) {
+	var outputs map[string]OutputValue
+	_, err := wd.runStdout("output", func() error {
+		metas, err := wd.tf.Output(context.Background())
+		if err != nil {
+			return err
+		}
+		outputs = make(map[string]OutputValue, len(metas))
+		for name, meta := range metas {
+			outputs[name] = OutputValue{
+				Value:     meta.Value,
+				Type:      meta.Type,
+				Sensitive: meta.Sensitive,
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return outputs, nil
+}
+
+// RequireOutputs is a variant of Outputs that will fail the test via the
+// given TestControl if the outputs cannot be read.
+func (wd *WorkingDir) RequireOutputs(t TestControl) map[string]OutputValue {
+	t.Helper()
+	ret, err := wd.Outputs()
+	if err != nil {
+		t := testingT{t}
+		t.Fatalf("failed to read outputs: %s", err)
+	}
+	return ret
+}
+
+// Output decodes the value of the root module output with the given name into
+// the Go value pointed to by target, as with json.Unmarshal.
+//
+// If there is no such output in the current state, or if its value cannot be
+// decoded into target, Output returns an error.
+func (wd *WorkingDir) Output(name string, target interface{}) error {
+	outputs, err := wd.Outputs()
+	if err != nil {
+		return err
+	}
+	v, ok := outputs[name]
+	if !ok {
+		return fmt.Errorf("no output named %q", name)
+	}
+	if err := v.Unmarshal(target); err != nil {
+		return fmt.Errorf("failed to decode output %q: %s", name, err)
+	}
+	return nil
+}
+
+// RequireOutput is a variant of Output that will fail the test via the given
+// TestControl if the output cannot be read.
+func (wd *WorkingDir) RequireOutput(t TestControl, name string, target interface{}) {
+	t.Helper()
+	if err := wd.Output(name, target); err != nil {
+		t := testingT{t}
+		t.Fatalf("failed to read output: %s", err)
+	}
+}
@@ -0,0 +1,90 @@
+package tftest
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// PlanNondeterminismError is the error returned by CheckPlanDeterminism, and
+// by CreatePlan when FeatureCheckPlanDeterminism is enabled, if two plans
+// created from identical inputs differ.
+type PlanNondeterminismError struct {
+	// Differences are the differences between the JSON representations of
+	// the first plan and the second.
+	Differences []Difference
+}
+
+func (e *PlanNondeterminismError) Error() string {
+	lines := make([]string, len(e.Differences))
+	for i, d := range e.Differences {
+		lines[i] = "  " + d.String()
+	}
+	return fmt.Sprintf("planning twice from identical inputs produced different plans:\n%s", strings.Join(lines, "\n"))
+}
+
+// CheckPlanDeterminism runs CreatePlan twice back-to-back and returns a
+// *PlanNondeterminismError if the JSON representations of the two plans
+// differ, other than in the time at which they were created. The second plan
+// remains saved for the next call to Apply.
+//
+// This catches providers whose plans depend on map iteration order or the
+// current time, which users would see as a perpetual diff.
+func (wd *WorkingDir) CheckPlanDeterminism() error {
+	if err := wd.createPlan(); err != nil {
+		return err
+	}
+	return wd.checkPlanDeterminism(wd.createPlan)
+}
+
+// RequireCheckPlanDeterminism is a variant of CheckPlanDeterminism that will
+// fail the test via the given TestControl if planning fails or the plans
+// differ.
+func (wd *WorkingDir) RequireCheckPlanDeterminism(t TestControl) {
+	t.Helper()
+	if err := wd.CheckPlanDeterminism(); err != nil {
+		t := testingT{t}
+		t.Fatalf("failed to check plan determinism: %s", err)
+	}
+}
+
+// checkPlanDeterminism creates the plan again using the given function, which
+// must plan in the same way as the one that created the current saved plan,
+// and compares the result with the current saved plan.
+func (wd *WorkingDir) checkPlanDeterminism(plan func() error) error {
+	first, err := wd.savedPlanValue()
+	if err != nil {
+		return err
+	}
+	if err := plan(); err != nil {
+		return err
+	}
+	second, err := wd.savedPlanValue()
+	if err != nil {
+		return err
+	}
+
+	diffs, err := Compare(first, second, &CompareOptions{Ignore: []string{"timestamp"}})
+	if err != nil {
+		return err
+	}
+	if len(diffs) > 0 {
+		return &PlanNondeterminismError{Differences: diffs}
+	}
+	return nil
+}
+
+// savedPlanValue returns the JSON representation of the current saved plan,
+// decoded without any particular structure.
+func (wd *WorkingDir) savedPlanValue() (interface{}, error) {
+	raw, err := wd.SavedPlanRawJSON()
+	if err != nil {
+		return nil, err
+	}
+	var ret interface{}
+	err = json.Unmarshal(raw, &ret)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode plan: %w", err)
+	}
+	return ret, nil
+}
@@ -0,0 +1,73 @@
+package tftest
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// fakeTerraformScript stands in for the Terraform CLI. It reports its
+// version, succeeds at init, appends the arguments of each plan to plans.log in the working
+// directory and saves an empty plan, which show -json prints.
+const fakeTerraformScript = `#!/bin/sh
+case "$1" in
+version)
+	echo '{"terraform_version":"1.5.0","platform":"linux_amd64","provider_selections":{},"terraform_outdated":false}'
+	;;
+init)
+	;;
+plan)
+	echo "$@" >>plans.log
+	echo '{"format_version":"1.0","terraform_version":"1.5.0"}' >"$(pwd)/tfplan"
+	;;
+show)
+	cat "$(pwd)/tfplan"
+	;;
+esac
+`
+
+func TestCreatePlanTargetCheckPlanDeterminism(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the fake Terraform CLI is a shell script")
+	}
+
+	dir, err := ioutil.TempDir("", "tftest-determinism")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	tfExec := filepath.Join(dir, "terraform")
+	if err := ioutil.WriteFile(tfExec, []byte(fakeTerraformScript), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	h, err := InitHelper(&Config{TerraformExec: tfExec})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Close()
+	h.SetFeature(FeatureCheckPlanDeterminism, true)
+
+	wd := h.RequireNewWorkingDir(t)
+	defer wd.Close()
+	wd.RequireSetConfig(t, `resource "null_resource" "a" {}`)
+	wd.RequireInit(t)
+	wd.RequireCreatePlan(t, Target("null_resource.a"))
+
+	log, err := ioutil.ReadFile(filepath.Join(wd.baseDir, "plans.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	plans := strings.Split(strings.TrimSpace(string(log)), "\n")
+	if len(plans) != 2 {
+		t.Fatalf("planned %d times, want 2:\n%s", len(plans), log)
+	}
+	for i, args := range plans {
+		if !strings.Contains(args, "-target=null_resource.a") {
+			t.Errorf("plan %d was not targeted: %s", i+1, args)
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package tftest
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/hashicorp/terraform-exec/tfexec"
+	tfjson "github.com/hashicorp/terraform-json"
+)
+
+// PlanGate is a function which inspects a saved plan before it is applied,
+// returning an error to prevent the apply. This is the integration point for
+// policy checks, such as running Sentinel or OPA policies against the plan.
+type PlanGate func(plan *tfjson.Plan) error
+
+// AddPlanGate registers a function to be called with the saved plan each time
+// one is about to be applied in the working directory, by Apply or any of its
+// variants. If any gate returns an error then the plan is not applied, and
+// the error is returned from the apply method instead.
+//
+// Gates are not called when Apply is creating and applying a plan in one
+// step because there is no saved plan, so tests that rely on gates should
+// call CreatePlan first.
+func (wd *WorkingDir) AddPlanGate(gate PlanGate) {
+	wd.planGates = append(wd.planGates, gate)
+}
+
+// checkPlanGates calls the plan gates, if any, with the plan file at the
+// given path.
+func (wd *WorkingDir) checkPlanGates(planPath string) error {
+	if len(wd.planGates) == 0 {
+		return nil
+	}
+	if err := wd.checkJSONOutput("plan gates"); err != nil {
+		return err
+	}
+
+	var plan *tfjson.Plan
+	err := wd.run("show", func() error {
+		var err error
+		plan, err = wd.tf.ShowPlanFile(context.Background(), planPath, tfexec.Reattach(wd.reattachInfo))
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("failed to read plan for plan gates: %w", err)
+	}
+
+	for _, gate := range wd.planGates {
+		if err := gate(plan); err != nil {
+			return fmt.Errorf("plan rejected: %w", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,125 @@
+package tftest
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	tfjson "github.com/hashicorp/terraform-json"
+)
+
+// SavedPlanRawJSON runs "terraform show -json" for the current saved plan and
+// returns the JSON representation of the plan exactly as Terraform produced
+// it, including any properties that terraform-json doesn't yet know about.
+//
+// If no plan is saved or if the plan file cannot be read, SavedPlanRawJSON
+// returns an error.
+func (wd *WorkingDir) SavedPlanRawJSON() ([]byte, error) {
+	if !wd.HasSavedPlan() {
+		return nil, fmt.Errorf("there is no current saved plan")
+	}
+	if err := wd.checkJSONOutput("reading the plan"); err != nil {
+		return nil, err
+	}
+	out, err := wd.runStdout("show", func() error {
+		return wd.runTerraform(context.Background(), "show", "-json", PlanFileName)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return []byte(out), wd.runJSONDecodeHooks(JSONKindPlan, out)
+}
+
+// RequireSavedPlanRawJSON is a variant of SavedPlanRawJSON that will fail the
+// test via the given TestControl if the plan cannot be read.
+func (wd *WorkingDir) RequireSavedPlanRawJSON(t TestControl) []byte {
+	t.Helper()
+	ret, err := wd.SavedPlanRawJSON()
+	if err != nil {
+		t := testingT{t}
+		t.Fatalf("failed to read saved plan: %s", err)
+	}
+	return ret
+}
+
+// SavedPlanStructured is a variant of SavedPlanRawJSON that decodes the plan
+// into a tfjson.Plan, in the same way as SavedPlan.
+//
+// Use PlanReplacements with the result of SavedPlanRawJSON for the reasons
+// Terraform gives for replacing resource instances.
+func (wd *WorkingDir) SavedPlanStructured() (*tfjson.Plan, error) {
+	raw, err := wd.SavedPlanRawJSON()
+	if err != nil {
+		return nil, err
+	}
+
+	return decodePlan(raw)
+}
+
+// RequireSavedPlanStructured is a variant of SavedPlanStructured that will
+// fail the test via the given TestControl if the plan cannot be read.
+func (wd *WorkingDir) RequireSavedPlanStructured(t TestControl) *tfjson.Plan {
+	t.Helper()
+	ret, err := wd.SavedPlanStructured()
+	if err != nil {
+		t := testingT{t}
+		t.Fatalf("failed to read saved plan: %s", err)
+	}
+	return ret
+}
+
+// PlanReplacement describes why a plan replaces a resource instance.
+type PlanReplacement struct {
+	Address string `json:"address"`
+
+	// ActionReason is Terraform's reason for the replacement, such as
+	// "replace_because_cannot_update" or "replace_because_tainted". It
+	// is reported by Terraform v1.0 and later.
+	ActionReason string `json:"action_reason,omitempty"`
+
+	// ReplacePaths are the paths of the attributes whose changes forced the
+	// replacement, each a sequence of attribute names and collection keys.
+	// They are reported by Terraform v0.15.4 and later.
+	ReplacePaths [][]interface{} `json:"replace_paths,omitempty"`
+}
+
+// PlanReplacements returns the resource instances that the plan with the
+// given JSON representation, such as from SavedPlanRawJSON, replaces, along
+// with the reasons for each replacement, in the order of the plan.
+func PlanReplacements(raw []byte) ([]PlanReplacement, error) {
+	var plan struct {
+		ResourceChanges []struct {
+			Address      string `json:"address"`
+			ActionReason string `json:"action_reason"`
+			Change       struct {
+				Actions      tfjson.Actions  `json:"actions"`
+				ReplacePaths [][]interface{} `json:"replace_paths"`
+			} `json:"change"`
+		} `json:"resource_changes"`
+	}
+	if err := json.Unmarshal(raw, &plan); err != nil {
+		return nil, fmt.Errorf("failed to decode plan: %s", err)
+	}
+
+	var ret []PlanReplacement
+	for _, rc := range plan.ResourceChanges {
+		if rc.Change.Actions.Replace() {
+			ret = append(ret, PlanReplacement{
+				Address:      rc.Address,
+				ActionReason: rc.ActionReason,
+				ReplacePaths: rc.Change.ReplacePaths,
+			})
+		}
+	}
+	return ret, nil
+}
+
+// decodePlan decodes the JSON representation of a plan, as produced by
+// "terraform show -json", into a tfjson.Plan.
+func decodePlan(raw []byte) (*tfjson.Plan, error) {
+	ret := &tfjson.Plan{}
+	if err := json.Unmarshal(raw, ret); err != nil {
+		return nil, fmt.Errorf("failed to decode plan: %s", err)
+	}
+	return ret, nil
+}
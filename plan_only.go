@@ -0,0 +1,65 @@
+package tftest
+
+import "fmt"
+
+// mutatingCommands are the Terraform subcommands which can change remote
+// objects or the state, and so are forbidden in plan-only mode.
+var mutatingCommands = map[string]bool{
+	"apply":            true,
+	"destroy":          true,
+	"import":           true,
+	"refresh":          true,
+	"taint":            true,
+	"untaint":          true,
+	"state mv":         true,
+	"state rm":         true,
+	"state push":       true,
+	"workspace delete": true,
+}
+
+// SetPlanOnly sets whether working directories created by the helper from
+// now on are in plan-only mode, as described for WorkingDir.SetPlanOnly.
+func (h *Helper) SetPlanOnly(planOnly bool) {
+	h.planOnly = planOnly
+}
+
+// SetPlanOnly sets whether the working directory is in plan-only mode, in
+// which any Terraform command that could change remote objects or the state,
+// such as apply, destroy, import or refresh, returns an error without being
+// run.
+//
+// This is intended for unit-style tests, such as validation tests or plan
+// assertions against mock endpoints, which must never touch real
+// infrastructure even if they are misconfigured.
+func (wd *WorkingDir) SetPlanOnly(planOnly bool) {
+	wd.planOnly = planOnly
+}
+
+// RequireAcceptanceMode sets whether the helper refuses to run any Terraform
+// command that could change remote objects or the state, in any of its
+// working directories, unless acceptance mode is enabled by setting the
+// environment variable TF_ACC. This is a backstop for the AcceptanceTest
+// guard, in case a test that creates real infrastructure forgets to call it.
+//
+// It is enabled by default. Tests that run against local instances of a
+// service, as recommended for AcceptanceTest, can opt out by calling
+// RequireAcceptanceMode(false), typically in TestMain, so that they can run
+// without TF_ACC.
+func (h *Helper) RequireAcceptanceMode(require bool) {
+	h.allowWithoutAcceptance = !require
+}
+
+// checkPlanOnly returns an error if the given command is forbidden by
+// plan-only mode, or by RequireAcceptanceMode.
+func (wd *WorkingDir) checkPlanOnly(name string) error {
+	if !mutatingCommands[name] {
+		return nil
+	}
+	if wd.planOnly {
+		return fmt.Errorf("terraform %s is not allowed because the working directory is in plan-only mode", name)
+	}
+	if !wd.h.allowWithoutAcceptance && !AcceptanceModeEnabled() {
+		return fmt.Errorf("terraform %s is not allowed because TF_ACC is not set; call RequireAcceptanceMode(false) on the helper to allow it", name)
+	}
+	return nil
+}
@@ -0,0 +1,84 @@
+package tftest
+
+import (
+	"context"
+	"errors"
+	"strings"
+)
+
+// PlanResult is the outcome of CreatePlanDetailed.
+type PlanResult int
+
+const (
+	// PlanFailed means that Terraform could not create the plan.
+	PlanFailed PlanResult = iota
+
+	// PlanNoChanges means that the plan has no changes, so the remote
+	// objects already match the configuration.
+	PlanNoChanges
+
+	// PlanHasChanges means that the plan has changes to apply.
+	PlanHasChanges
+)
+
+func (r PlanResult) String() string {
+	switch r {
+	case PlanNoChanges:
+		return "no changes"
+	case PlanHasChanges:
+		return "changes present"
+	default:
+		return "failed"
+	}
+}
+
+// CreatePlanDetailed is a variant of CreatePlan which runs Terraform with
+// -detailed-exitcode, and so also reports whether the plan has any changes,
+// as determined by Terraform itself. This allows checking that a plan after
+// an apply is empty without parsing the plan.
+func (wd *WorkingDir) CreatePlanDetailed() (PlanResult, error) {
+	result := PlanFailed
+	err := wd.runPlan(func() error {
+		err := wd.runTerraform(context.Background(), "plan", "-no-color", "-input=false", "-refresh=false", "-detailed-exitcode", "-out="+PlanFileName)
+		var tfErr *TerraformError
+		switch {
+		case err == nil:
+			result = PlanNoChanges
+		case errors.As(err, &tfErr) && tfErr.ExitCode == 2:
+			// Exit status 2 means success with changes present.
+			result = PlanHasChanges
+			return nil
+		}
+		return err
+	})
+	if err != nil {
+		return PlanFailed, err
+	}
+	return result, nil
+}
+
+// RequireCreatePlanDetailed is a variant of CreatePlanDetailed that will fail
+// the test via the given TestControl if plan creation fails.
+func (wd *WorkingDir) RequireCreatePlanDetailed(t TestControl) PlanResult {
+	t.Helper()
+	result, err := wd.CreatePlanDetailed()
+	if err != nil {
+		t := testingT{t}
+		t.Fatalf("failed to create plan: %s", err)
+	}
+	return result
+}
+
+// RequireEmptyPlan creates a plan using CreatePlanDetailed and will fail the
+// test via the given TestControl if plan creation fails or if the plan has
+// any changes, as when checking that applying a configuration converged.
+func (wd *WorkingDir) RequireEmptyPlan(t TestControl) {
+	t.Helper()
+	if result := wd.RequireCreatePlanDetailed(t); result != PlanNoChanges {
+		t := testingT{t}
+		if summary, err := wd.planChangeSummary(PlanFileName); err == nil && len(summary.Addresses) > 0 {
+			t.Fatalf("expected an empty plan, but the plan has changes for %s", strings.Join(summary.Addresses, ", "))
+		}
+		t.Fatalf("expected an empty plan, but the plan has changes")
+	}
+}
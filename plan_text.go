@@ -0,0 +1,113 @@
+package tftest
+
+import (
+	"strings"
+)
+
+// PlanResourceText is the part of Terraform's human-readable plan output
+// describing the planned change for a single resource instance.
+type PlanResourceText struct {
+	// Address is the address of the resource instance, such as
+	// "module.foo.aws_instance.bar[0]".
+	Address string
+
+	// Summary is the rest of the comment line that introduces the change,
+	// such as "will be created" or "must be replaced".
+	Summary string
+
+	// Text is the complete section of the output for the resource
+	// instance, including the comment line and the diff that follows it.
+	Text string
+}
+
+// ParsePlanText splits the human-readable output of "terraform plan" or
+// "terraform show" for a saved plan, as returned by SavedPlanStdout, into
+// the sections for each resource instance, in the order Terraform printed
+// them.
+//
+// This allows failure messages and golden tests to quote only the diff for
+// the resource instance of interest, rather than the entire plan.
+func ParsePlanText(text string) []PlanResourceText {
+	var ret []PlanResourceText
+	var cur *PlanResourceText
+	var lines []string
+
+	flush := func() {
+		if cur != nil {
+			cur.Text = strings.TrimRight(strings.Join(lines, "\n"), "\n ") + "\n"
+			ret = append(ret, *cur)
+		}
+		cur, lines = nil, nil
+	}
+
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimRight(line, "\r")
+		switch {
+		case strings.HasPrefix(line, "  # "):
+			flush()
+			address, summary := splitPlanComment(strings.TrimPrefix(line, "  # "))
+			cur = &PlanResourceText{Address: address, Summary: summary}
+		case line != "" && !strings.ContainsAny(line[:1], " +-~<"):
+			// Anything unindented other than the action symbols of a
+			// replacement, such as "-/+", ends the resource changes.
+			// This includes the "Plan:" summary.
+			flush()
+			continue
+		}
+		if cur != nil {
+			lines = append(lines, line)
+		}
+	}
+	flush()
+	return ret
+}
+
+// splitPlanComment splits the comment line introducing a resource instance
+// in the plan output into the address and the rest of the line, allowing for
+// instance keys containing spaces.
+func splitPlanComment(comment string) (address, summary string) {
+	depth := 0
+	inString := false
+	for i := 0; i < len(comment); i++ {
+		switch c := comment[i]; {
+		case inString && c == '\\':
+			i++
+		case c == '"':
+			inString = !inString
+		case inString:
+		case c == '[':
+			depth++
+		case c == ']':
+			depth--
+		case c == ' ' && depth == 0:
+			return comment[:i], comment[i+1:]
+		}
+	}
+	return comment, ""
+}
+
+// SavedPlanResourceText returns the sections of the human-readable output of
+// the current saved plan for each resource instance, as described for
+// ParsePlanText.
+//
+// If no plan is saved or if the plan file cannot be read,
+// SavedPlanResourceText returns an error.
+func (wd *WorkingDir) SavedPlanResourceText() ([]PlanResourceText, error) {
+	out, err := wd.SavedPlanStdout()
+	if err != nil {
+		return nil, err
+	}
+	return ParsePlanText(out), nil
+}
+
+// RequireSavedPlanResourceText is a variant of SavedPlanResourceText that
+// will fail the test via the given TestControl if the plan cannot be read.
+func (wd *WorkingDir) RequireSavedPlanResourceText(t TestControl) []PlanResourceText {
+	t.Helper()
+	ret, err := wd.SavedPlanResourceText()
+	if err != nil {
+		t := testingT{t}
+		t.Fatalf("failed to read saved plan: %s", err)
+	}
+	return ret
+}
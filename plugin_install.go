@@ -0,0 +1,125 @@
+package tftest
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+)
+
+// PluginInstallStrategy is how the helper places provider plugin binaries
+// where Terraform will discover them, as selected by
+// Config.PluginInstallStrategy.
+type PluginInstallStrategy int
+
+const (
+	// PluginInstallDefault copies plugins on Windows, where creating
+	// symlinks usually requires extra privileges, and symlinks them on all
+	// other platforms.
+	PluginInstallDefault PluginInstallStrategy = iota
+
+	// PluginInstallSymlink symlinks each plugin binary, so that it is never
+	// duplicated on disk.
+	PluginInstallSymlink
+
+	// PluginInstallCopy copies each plugin binary. This is needed on
+	// filesystems that don't support symlinks, such as some container
+	// volumes, and ensures that a working directory keeps using the same
+	// binary even if the original is rebuilt while the tests are running.
+	//
+	// A binary that was already installed is copied again only if its
+	// content differs from the original, compared by SHA-256 checksum.
+	PluginInstallCopy
+)
+
+// resolve returns the strategy to use on the current platform.
+func (s PluginInstallStrategy) resolve() PluginInstallStrategy {
+	if s != PluginInstallDefault {
+		return s
+	}
+	if runtime.GOOS == "windows" {
+		return PluginInstallCopy
+	}
+	return PluginInstallSymlink
+}
+
+// installPlugin places the plugin binary at src at the path dest using the
+// helper's install strategy, replacing whatever was at dest unless it is
+// already the same binary.
+func (h *Helper) installPlugin(src, dest string) error {
+	if h.pluginInstall.resolve() == PluginInstallSymlink {
+		if target, err := os.Readlink(dest); err == nil && target == src {
+			return nil
+		}
+		// Creating the new symlink alongside and renaming it into place
+		// replaces any existing one atomically.
+		tmp := filepath.Join(filepath.Dir(dest), "."+filepath.Base(dest)+".tmp")
+		os.Remove(tmp)
+		if err := symlinkFile(src, tmp); err != nil {
+			return err
+		}
+		return os.Rename(tmp, dest)
+	}
+
+	srcSum, err := fileChecksum(src)
+	if err != nil {
+		return err
+	}
+	if info, err := os.Lstat(dest); err == nil && info.Mode()&os.ModeSymlink == 0 {
+		destSum, err := fileChecksum(dest)
+		if err == nil && bytes.Equal(srcSum, destSum) {
+			return nil
+		}
+	}
+	return copyPluginFile(src, dest)
+}
+
+// copyPluginFile copies the file at src to dest, replacing dest if it
+// exists, with the same permissions.
+func copyPluginFile(src, dest string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	info, err := in.Stat()
+	if err != nil {
+		return err
+	}
+
+	// Writing to a new file and renaming it into place means that a
+	// Terraform process already running the old binary is unaffected.
+	tmp := filepath.Join(filepath.Dir(dest), "."+filepath.Base(dest)+".tmp")
+	out, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, info.Mode())
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, in)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	// os.Rename can't replace an existing file on all platforms
+	os.Remove(dest)
+	return os.Rename(tmp, dest)
+}
+
+// fileChecksum returns the SHA-256 checksum of the content of the file at the
+// given path, following symlinks.
+func fileChecksum(path string) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return nil, err
+	}
+	return h.Sum(nil), nil
+}
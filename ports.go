@@ -0,0 +1,133 @@
+package tftest
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// PortAllocator chooses TCP ports on the loopback interface for servers run
+// by tests, such as mock endpoints and emulators, in a way that avoids
+// collisions between tests running in parallel. The allocator used by this
+// package can be replaced with SetPortAllocator.
+type PortAllocator interface {
+	// AllocatePort returns a port that is currently free and will not be
+	// returned again, by this process or any other using the same
+	// allocator, until the returned release function has been called.
+	AllocatePort() (port int, release func(), err error)
+}
+
+// portReservationTimeout is the age after which a port reservation is
+// assumed to belong to a test process that exited without releasing it.
+const portReservationTimeout = time.Hour
+
+// fileLockPortAllocator is the default PortAllocator, which records each
+// reservation as a lock file in a directory shared by all processes, so that
+// the test binaries for separate packages, which "go test" runs in parallel,
+// also avoid each other.
+type fileLockPortAllocator struct {
+	dir string
+}
+
+func (a fileLockPortAllocator) AllocatePort() (int, func(), error) {
+	if err := os.MkdirAll(a.dir, 0755); err != nil {
+		return 0, nil, fmt.Errorf("failed to create port reservation directory: %s", err)
+	}
+
+	for attempt := 0; attempt < 100; attempt++ {
+		// The operating system chooses a free port, and holding the
+		// listener until the reservation is recorded prevents any other
+		// process from getting the same one in the meantime.
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			return 0, nil, err
+		}
+		port := l.Addr().(*net.TCPAddr).Port
+
+		lockPath := filepath.Join(a.dir, strconv.Itoa(port)+".lock")
+		f, err := os.OpenFile(lockPath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+		if os.IsExist(err) {
+			if info, err := os.Stat(lockPath); err == nil && time.Since(info.ModTime()) > portReservationTimeout {
+				os.Remove(lockPath)
+			}
+			l.Close()
+			continue
+		}
+		if err != nil {
+			l.Close()
+			return 0, nil, fmt.Errorf("failed to reserve port %d: %s", port, err)
+		}
+		fmt.Fprintf(f, "%d\n", os.Getpid())
+		f.Close()
+		l.Close()
+
+		var once sync.Once
+		return port, func() {
+			once.Do(func() { os.Remove(lockPath) })
+		}, nil
+	}
+	return 0, nil, fmt.Errorf("failed to find a port that is not reserved by another test")
+}
+
+var portAllocator struct {
+	sync.Mutex
+	a PortAllocator
+}
+
+// SetPortAllocator replaces the allocator used by ReservePort and
+// ListenReserved. Pass nil to restore the default, which coordinates between
+// processes using lock files in the system's temporary directory.
+func SetPortAllocator(a PortAllocator) {
+	portAllocator.Lock()
+	defer portAllocator.Unlock()
+	portAllocator.a = a
+}
+
+func currentPortAllocator() PortAllocator {
+	portAllocator.Lock()
+	defer portAllocator.Unlock()
+	if portAllocator.a == nil {
+		return fileLockPortAllocator{dir: filepath.Join(os.TempDir(), "tftest-ports")}
+	}
+	return portAllocator.a
+}
+
+// ReservePort returns a free TCP port on the loopback interface for a
+// test-local server, which no other test using this package will be given
+// until the returned release function is called. This is for servers that
+// must be told their port before they start, such as emulators run in
+// containers. Servers started by the test process itself should use
+// ListenReserved instead.
+func ReservePort() (int, func(), error) {
+	return currentPortAllocator().AllocatePort()
+}
+
+// ListenReserved reserves a port using ReservePort and listens on it. The
+// reservation is released when the listener is closed.
+func ListenReserved() (net.Listener, error) {
+	port, release, err := ReservePort()
+	if err != nil {
+		return nil, err
+	}
+	l, err := net.Listen("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(port)))
+	if err != nil {
+		release()
+		return nil, err
+	}
+	return &reservedListener{Listener: l, release: release}, nil
+}
+
+type reservedListener struct {
+	net.Listener
+	release func()
+}
+
+func (l *reservedListener) Close() error {
+	err := l.Listener.Close()
+	l.release()
+	return err
+}
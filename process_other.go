@@ -0,0 +1,46 @@
+//go:build !aix && !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd && !solaris
+// +build !aix,!darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd,!solaris
+
+package tftest
+
+import (
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+)
+
+// startProcessGroup does nothing on this platform.
+func startProcessGroup(cmd *exec.Cmd) {}
+
+// killProcessTree kills the given started command, along with the provider
+// plugins Terraform launched where the platform allows.
+func killProcessTree(cmd *exec.Cmd) {
+	if runtime.GOOS == "windows" {
+		err := exec.Command("taskkill", "/T", "/F", "/PID", strconv.Itoa(cmd.Process.Pid)).Run()
+		if err == nil {
+			return
+		}
+	}
+	cmd.Process.Kill()
+}
+
+// interruptProcessTree interrupts the given started command, where the
+// platform allows.
+func interruptProcessTree(cmd *exec.Cmd) error {
+	return cmd.Process.Signal(os.Interrupt)
+}
+
+// processAlive returns true if a process with the given ID may exist. Where
+// the platform can't tell, it assumes so, to be safe.
+func processAlive(pid int) bool {
+	if runtime.GOOS != "windows" {
+		return true
+	}
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	p.Release()
+	return true
+}
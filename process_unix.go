@@ -0,0 +1,40 @@
+//go:build aix || darwin || dragonfly || freebsd || linux || netbsd || openbsd || solaris
+// +build aix darwin dragonfly freebsd linux netbsd openbsd solaris
+
+package tftest
+
+import (
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+// startProcessGroup makes the given command start a new process group, so
+// that killProcessTree can kill any processes it in turn starts.
+func startProcessGroup(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+}
+
+// killProcessTree kills the given started command along with the rest of its
+// process group, which includes the provider plugins Terraform launched.
+func killProcessTree(cmd *exec.Cmd) {
+	if err := syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL); err != nil {
+		cmd.Process.Kill()
+	}
+}
+
+// interruptProcessTree interrupts the given started command along with the
+// rest of its process group, so that Terraform is interrupted even when it
+// runs under a command wrapper which doesn't forward signals.
+func interruptProcessTree(cmd *exec.Cmd) error {
+	if err := syscall.Kill(-cmd.Process.Pid, syscall.SIGINT); err != nil {
+		return cmd.Process.Signal(os.Interrupt)
+	}
+	return nil
+}
+
+// processAlive returns true if a process with the given ID exists.
+func processAlive(pid int) bool {
+	err := syscall.Kill(pid, 0)
+	return err == nil || err == syscall.EPERM
+}
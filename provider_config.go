@@ -0,0 +1,195 @@
+package tftest
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+)
+
+// ProviderConfigBlock returns the source of a provider configuration block for
+// the provider with the given local name, such as "aws", with the arguments
+// and nested blocks taken from config, which must be a struct or a pointer to
+// one. This lets tests that configure many provider arguments use a Go type,
+// so that misspelled arguments are caught at compile time.
+//
+// Only fields with an "hcl" tag are included, using the same tag format as
+// the gohcl package:
+//
+//	Region     string            `hcl:"region"`
+//	Profile    string            `hcl:"profile,optional"`
+//	Tags       map[string]string `hcl:"default_tags,optional"`
+//	AssumeRole *AssumeRole       `hcl:"assume_role,block"`
+//
+// Arguments are encoded as with encoding/json, so a field with a struct type
+// becomes an object value. Arguments marked optional are omitted if they have
+// their zero value, as are nil pointers, maps and slices. A block field may be
+// a struct, a pointer to a struct, or a slice of structs for a repeated
+// block, and a string field tagged "label" in a nested block becomes its
+// label.
+func ProviderConfigBlock(name string, config interface{}) (string, error) {
+	var b strings.Builder
+	fmt.Fprintf(&b, "provider %s {\n", hclLiteral(name))
+	err := writeHCLBody(&b, reflect.ValueOf(config), "  ")
+	if err != nil {
+		return "", fmt.Errorf("invalid configuration for provider %q: %s", name, err)
+	}
+	b.WriteString("}\n")
+	return b.String(), nil
+}
+
+// providerConfigFilename returns the path of the file in which
+// SetProviderConfig writes the configuration for the named provider.
+func (wd *WorkingDir) providerConfigFilename(name string) string {
+	return filepath.Join(wd.baseDir, "terraform_plugin_test_provider_"+name+".tf")
+}
+
+// SetProviderConfig writes a provider configuration block generated from
+// config by ProviderConfigBlock into the working directory, in a file of its
+// own alongside the configuration given to SetConfig, replacing any
+// configuration previously set for the same provider.
+//
+// To configure several aliased configurations for the same provider, include
+// the blocks returned by ProviderConfigBlock in the configuration given to
+// SetConfig instead.
+func (wd *WorkingDir) SetProviderConfig(name string, config interface{}) error {
+	src, err := ProviderConfigBlock(name, config)
+	if err != nil {
+		return err
+	}
+	err = ioutil.WriteFile(wd.providerConfigFilename(name), []byte(src), 0700)
+	if err != nil {
+		return err
+	}
+
+	// Changing configuration invalidates any saved plan.
+	return wd.ClearPlan()
+}
+
+// RequireSetProviderConfig is a variant of SetProviderConfig that will fail
+// the test via the given TestControl if the configuration cannot be written.
+func (wd *WorkingDir) RequireSetProviderConfig(t TestControl, name string, config interface{}) {
+	t.Helper()
+	if err := wd.SetProviderConfig(name, config); err != nil {
+		t := testingT{t}
+		t.Fatalf("failed to set provider configuration: %s", err)
+	}
+}
+
+// hclField is a struct field with an "hcl" tag.
+type hclField struct {
+	name  string
+	kind  string
+	value reflect.Value
+}
+
+// hclFields returns the tagged fields of the given struct value.
+func hclFields(v reflect.Value) ([]hclField, error) {
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return nil, nil
+		}
+		v = v.Elem()
+	}
+	if v.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("%s is not a struct", v.Type())
+	}
+
+	var ret []hclField
+	for i := 0; i < v.NumField(); i++ {
+		tag := v.Type().Field(i).Tag.Get("hcl")
+		if tag == "" || tag == "-" {
+			continue
+		}
+		parts := strings.SplitN(tag, ",", 2)
+		f := hclField{name: parts[0], kind: "attr", value: v.Field(i)}
+		if len(parts) > 1 {
+			f.kind = parts[1]
+		}
+		switch f.kind {
+		case "attr", "optional", "block", "label":
+		default:
+			return nil, fmt.Errorf("field %s has unsupported hcl tag kind %q", v.Type().Field(i).Name, f.kind)
+		}
+		ret = append(ret, f)
+	}
+	return ret, nil
+}
+
+// writeHCLBody writes the arguments and nested blocks for the given struct
+// value to b, with each line prefixed by indent.
+func writeHCLBody(b *strings.Builder, v reflect.Value, indent string) error {
+	fields, err := hclFields(v)
+	if err != nil {
+		return err
+	}
+
+	for _, f := range fields {
+		if f.kind == "block" || f.kind == "label" || isNilValue(f.value) {
+			continue
+		}
+		if f.kind == "optional" && f.value.IsZero() {
+			continue
+		}
+		val, err := hclValue(f.value.Interface())
+		if err != nil {
+			return fmt.Errorf("argument %s: %s", f.name, err)
+		}
+		fmt.Fprintf(b, "%s%s = %s\n", indent, f.name, val)
+	}
+
+	for _, f := range fields {
+		if f.kind != "block" || isNilValue(f.value) {
+			continue
+		}
+		blocks := []reflect.Value{f.value}
+		if f.value.Kind() == reflect.Slice {
+			blocks = blocks[:0]
+			for i := 0; i < f.value.Len(); i++ {
+				blocks = append(blocks, f.value.Index(i))
+			}
+		}
+		for _, block := range blocks {
+			labels, err := hclLabels(block)
+			if err != nil {
+				return fmt.Errorf("block %s: %s", f.name, err)
+			}
+			fmt.Fprintf(b, "%s%s%s {\n", indent, f.name, labels)
+			if err := writeHCLBody(b, block, indent+"  "); err != nil {
+				return fmt.Errorf("block %s: %s", f.name, err)
+			}
+			fmt.Fprintf(b, "%s}\n", indent)
+		}
+	}
+	return nil
+}
+
+// hclLabels returns the labels of the given block struct value, each preceded
+// by a space, in field order.
+func hclLabels(v reflect.Value) (string, error) {
+	fields, err := hclFields(v)
+	if err != nil {
+		return "", err
+	}
+	var labels []string
+	for _, f := range fields {
+		if f.kind != "label" {
+			continue
+		}
+		if f.value.Kind() != reflect.String {
+			return "", fmt.Errorf("label %s is not a string", f.name)
+		}
+		labels = append(labels, " "+hclLiteral(f.value.String()))
+	}
+	return strings.Join(labels, ""), nil
+}
+
+// isNilValue returns whether v is a nil pointer, interface, map or slice.
+func isNilValue(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface, reflect.Map, reflect.Slice:
+		return v.IsNil()
+	}
+	return false
+}
@@ -0,0 +1,313 @@
+package tftest
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/hashicorp/go-version"
+)
+
+// ProviderBinary describes a provider plugin executable which the helper
+// installs into each working directory, so that configurations requiring
+// that provider use the given executable rather than one from a registry.
+type ProviderBinary struct {
+	// Source is the provider's source address, such as
+	// "registry.terraform.io/hashicorp/null" or "hashicorp/null".
+	Source string
+
+	// Version is the version number to install the provider as, which
+	// configurations' version constraints must accept. It is required for
+	// Terraform 0.13, and ignored by Terraform 0.14 and later.
+	Version string
+
+	// Path is the location of the provider executable.
+	Path string
+
+	// Platform is the platform the executable was built for, such as
+	// "linux_amd64". If empty, it defaults to the current platform.
+	//
+	// Executables for other platforms can't be run, but they are placed in
+	// the working directory's filesystem mirror so that LockProviders can
+	// record their checksums, producing a dependency lock file that is valid
+	// on all of those platforms. They must have a Version.
+	Platform string
+}
+
+// providerInstallMethod is a mechanism for making Terraform use a local
+// provider executable.
+type providerInstallMethod int
+
+const (
+	// installLegacyPluginDir places the executable in the working
+	// directory's legacy plugin directory, for Terraform versions before 0.13.
+	installLegacyPluginDir providerInstallMethod = iota
+
+	// installFilesystemMirror places the executable in the implied local
+	// filesystem mirror in the working directory, for Terraform 0.13.
+	installFilesystemMirror
+
+	// installDevOverrides points a dev_overrides block in a generated CLI
+	// configuration at the executable, for Terraform 0.14 and later.
+	installDevOverrides
+)
+
+var (
+	filesystemMirrorVersion = version.Must(version.NewVersion("0.13.0"))
+	devOverridesVersion     = version.Must(version.NewVersion("0.14.0"))
+)
+
+// providerInstallMethodFor returns the mechanism to use for installing
+// provider executables for the given Terraform CLI version.
+func providerInstallMethodFor(v *version.Version) providerInstallMethod {
+	switch {
+	case v.LessThan(filesystemMirrorVersion):
+		return installLegacyPluginDir
+	case v.LessThan(devOverridesVersion):
+		return installFilesystemMirror
+	default:
+		return installDevOverrides
+	}
+}
+
+// AddProviderBinary registers a provider executable to be installed into
+// every working directory the helper creates from now on.
+//
+// The mechanism used to install the executable is chosen automatically based
+// on the version of Terraform under test: the working directory's plugin
+// directory before Terraform 0.13, its implied filesystem mirror in Terraform
+// 0.13, or dev_overrides in a generated CLI configuration file for Terraform
+// 0.14 and later. This allows the same test suite to run unchanged against
+// all of those versions. The CLI configuration file is selected by setting
+// TF_CLI_CONFIG_FILE using Setenv, with the effects described there.
+func (h *Helper) AddProviderBinary(provider ProviderBinary) error {
+	if _, _, _, err := parseProviderSource(provider.Source); err != nil {
+		return err
+	}
+	if _, err := os.Stat(provider.Path); err != nil {
+		return fmt.Errorf("invalid provider executable for %s: %s", provider.Source, err)
+	}
+
+	h.providersMu.Lock()
+	defer h.providersMu.Unlock()
+	h.providerBinaries = append(h.providerBinaries, provider)
+	return nil
+}
+
+// SwitchProviderExec replaces the executable installed in the working
+// directory for the provider with the given source address, which must have
+// been registered with Helper.AddProviderBinary before the working directory
+// was created, with the executable at the given path. This allows a test to
+// upgrade or downgrade a provider between steps, through any number of
+// versions.
+//
+// The executable is replaced atomically, so that each command uses either the
+// old executable or the new one. Terraform versions before 0.14 record the
+// checksums of providers during init, so with those versions Init must be
+// run again before any other command, which it then will be even though the
+// configuration hasn't changed.
+func (wd *WorkingDir) SwitchProviderExec(source, path string) error {
+	hostname, namespace, typeName, err := parseProviderSource(source)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(path); err != nil {
+		return fmt.Errorf("invalid provider executable for %s: %s", source, err)
+	}
+
+	found := false
+	for i, provider := range wd.providerBinaries {
+		h, n, t, _ := parseProviderSource(provider.Source)
+		if h == hostname && n == namespace && t == typeName && provider.Platform == "" {
+			wd.providerBinaries[i].Path = path
+			found = true
+		}
+	}
+	if !found {
+		return fmt.Errorf("provider %s was not installed in the working directory using Helper.AddProviderBinary", source)
+	}
+	if wd.h.terraformVersion.LessThan(devOverridesVersion) {
+		wd.initSum = nil
+	}
+	return wd.installProviderBinaries()
+}
+
+// RequireSwitchProviderExec is a variant of SwitchProviderExec that will fail
+// the test via the given TestControl if the executable cannot be switched.
+func (wd *WorkingDir) RequireSwitchProviderExec(t TestControl, source, path string) {
+	t.Helper()
+	if err := wd.SwitchProviderExec(source, path); err != nil {
+		t := testingT{t}
+		t.Fatalf("failed to switch provider executable: %s", err)
+	}
+}
+
+// helperProviderBinaries returns the provider executables registered with the
+// helper so far.
+func (h *Helper) helperProviderBinaries() []ProviderBinary {
+	h.providersMu.Lock()
+	defer h.providersMu.Unlock()
+	return append([]ProviderBinary(nil), h.providerBinaries...)
+}
+
+// installProviderBinaries installs the working directory's provider
+// executables.
+func (wd *WorkingDir) installProviderBinaries() error {
+	if len(wd.providerBinaries) == 0 {
+		return nil
+	}
+
+	method := providerInstallMethodFor(wd.h.terraformVersion)
+	var overrides []string
+	for _, provider := range wd.providerBinaries {
+		hostname, namespace, typeName, _ := parseProviderSource(provider.Source)
+		name := "terraform-provider-" + typeName
+		ext := filepath.Ext(provider.Path)
+		platform := runtime.GOOS + "_" + runtime.GOARCH
+		target := provider.Platform
+		if target == "" {
+			target = platform
+		}
+
+		mirrorDest := ""
+		if provider.Version != "" {
+			mirrorDest = filepath.Join(wd.providerMirrorDir(), hostname, namespace, typeName, provider.Version, target, name+"_v"+provider.Version+ext)
+		}
+		if target != platform {
+			if mirrorDest == "" {
+				return fmt.Errorf("provider %s for %s must have a version", provider.Source, provider.Platform)
+			}
+			if err := wd.installProviderFile(provider, mirrorDest); err != nil {
+				return err
+			}
+			continue
+		}
+
+		var dest string
+		switch method {
+		case installLegacyPluginDir:
+			dest = filepath.Join(wd.legacyPluginDir(), legacyPluginName(typeName, provider.Version)+ext)
+		case installFilesystemMirror:
+			if mirrorDest == "" {
+				return fmt.Errorf("provider %s must have a version to be installed for Terraform v%s", provider.Source, wd.h.terraformVersion)
+			}
+			dest = mirrorDest
+			mirrorDest = ""
+		case installDevOverrides:
+			dir := filepath.Join(wd.baseDir, ".tftest-providers", hostname, namespace, typeName)
+			dest = filepath.Join(dir, name+ext)
+			overrides = append(overrides, fmt.Sprintf("    %q = %q\n", hostname+"/"+namespace+"/"+typeName, filepath.ToSlash(dir)))
+		}
+
+		if err := wd.installProviderFile(provider, dest); err != nil {
+			return err
+		}
+		if method != installLegacyPluginDir && mirrorDest != "" {
+			// dev_overrides bypass the mirror, but LockProviders needs the
+			// executable there too to record its checksum.
+			if err := wd.installProviderFile(provider, mirrorDest); err != nil {
+				return err
+			}
+		}
+	}
+
+	if method != installDevOverrides {
+		return nil
+	}
+	cfg := "provider_installation {\n  dev_overrides {\n" + strings.Join(overrides, "") + "  }\n  direct {}\n}\n"
+	p := filepath.Join(wd.baseDir, ".tftest.tfrc")
+	err := ioutil.WriteFile(p, []byte(cfg), 0644)
+	if err != nil {
+		return err
+	}
+	wd.Setenv("TF_CLI_CONFIG_FILE", p)
+	return nil
+}
+
+// installProviderFile puts a provider executable into place at dest, using
+// the helper's plugin install strategy.
+func (wd *WorkingDir) installProviderFile(provider ProviderBinary, dest string) error {
+	err := os.MkdirAll(filepath.Dir(dest), 0755)
+	if err != nil {
+		return err
+	}
+	err = wd.h.installPlugin(provider.Path, dest)
+	if err != nil {
+		return fmt.Errorf("failed to install provider %s: %s", provider.Source, err)
+	}
+	return nil
+}
+
+// legacyPluginDir is the directory in the working directory where Terraform
+// versions before 0.13 look for plugins for the current platform.
+func (wd *WorkingDir) legacyPluginDir() string {
+	return filepath.Join(wd.baseDir, "terraform.d", "plugins", runtime.GOOS+"_"+runtime.GOARCH)
+}
+
+// legacyPluginName returns the filename, without any extension, under which
+// Terraform versions before 0.13 discover the given version of a provider.
+// The version may be empty for an unversioned plugin.
+func legacyPluginName(typeName, version string) string {
+	name := "terraform-provider-" + typeName
+	if version != "" {
+		name += "_v" + strings.TrimPrefix(version, "v")
+	}
+	return name
+}
+
+// providerMirrorDir is the working directory's local filesystem mirror of
+// provider packages, which Terraform 0.13 uses implicitly.
+func (wd *WorkingDir) providerMirrorDir() string {
+	return filepath.Join(wd.baseDir, "terraform.d", "plugins")
+}
+
+// LockProviders runs "terraform providers lock" to write a dependency lock
+// file recording checksums for the providers installed by AddProviderBinary,
+// for each of the given platforms, such as "linux_amd64" and
+// "darwin_arm64". The helper must have executables registered for all of
+// those platforms, with versions.
+//
+// This requires Terraform 0.14 or later, which introduced lock files.
+func (wd *WorkingDir) LockProviders(platforms ...string) error {
+	if wd.h.terraformVersion.LessThan(devOverridesVersion) {
+		return fmt.Errorf("Terraform v%s does not support dependency lock files", wd.h.terraformVersion)
+	}
+
+	args := []string{"providers", "lock", "-fs-mirror=" + wd.providerMirrorDir()}
+	for _, platform := range platforms {
+		args = append(args, "-platform="+platform)
+	}
+	return wd.run("providers lock", func() error {
+		return wd.runTerraform(context.Background(), args...)
+	})
+}
+
+// RequireLockProviders is a variant of LockProviders that will fail the test
+// via the given TestControl if the lock file cannot be written.
+func (wd *WorkingDir) RequireLockProviders(t TestControl, platforms ...string) {
+	t.Helper()
+	if err := wd.LockProviders(platforms...); err != nil {
+		t := testingT{t}
+		t.Fatalf("failed to lock providers: %s", err)
+	}
+}
+
+// parseProviderSource splits a provider source address into its parts,
+// filling in the defaults for any that are omitted.
+func parseProviderSource(source string) (hostname, namespace, typeName string, err error) {
+	parts := strings.Split(source, "/")
+	switch len(parts) {
+	case 1:
+		return "registry.terraform.io", "hashicorp", parts[0], nil
+	case 2:
+		return "registry.terraform.io", parts[0], parts[1], nil
+	case 3:
+		return parts[0], parts[1], parts[2], nil
+	default:
+		return "", "", "", fmt.Errorf("invalid provider source address %q", source)
+	}
+}
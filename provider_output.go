@@ -0,0 +1,106 @@
+package tftest
+
+import (
+	"bufio"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// providerLogLineRegexp matches the lines of Terraform's log which Terraform
+// relays from the stderr of the provider plugin processes it launched.
+var providerLogLineRegexp = regexp.MustCompile(`\] (?:plugin|provider)\.terraform-provider-[^:]*: (.*)$`)
+
+// CaptureProviderOutput makes the working directory collect whatever the
+// provider plugin processes write to stderr, such as their own log lines and
+// panic messages, separately from the output of Terraform itself. The
+// collected output is available from ProviderOutput.
+//
+// This works by enabling Terraform's logging for each command and extracting
+// the lines it relays from providers, so it only applies to providers that
+// Terraform launches itself. Providers served from the test process using
+// SetReattachInfo write to the test process's own stderr instead.
+func (wd *WorkingDir) CaptureProviderOutput() {
+	wd.captureProviderOutput = true
+}
+
+// ProviderOutput returns everything the provider plugin processes have
+// written to stderr during the commands run in the working directory since
+// CaptureProviderOutput was called.
+func (wd *WorkingDir) ProviderOutput() string {
+	return wd.providerOutput.String()
+}
+
+// logPath returns the path to which Terraform should write its log for the
+// next command, or an empty string if logging is disabled.
+func (wd *WorkingDir) logPath() string {
+	if wd.commandLogPath != "" {
+		return wd.commandLogPath
+	}
+	return wd.configuredLogPath()
+}
+
+// startLogCapture prepares to capture Terraform's log from the next command,
+// if either provider output capture or a Terraform logger is enabled,
+// returning a function to call once the command completes which extracts
+// the provider output into the given record.
+func (wd *WorkingDir) startLogCapture(cmd *Command) (func(), error) {
+	logger := wd.terraformLogger()
+	if !wd.captureProviderOutput && logger == nil {
+		return func() {}, nil
+	}
+
+	f, err := ioutil.TempFile(wd.h.baseDir, "log")
+	if err != nil {
+		return nil, err
+	}
+	f.Close()
+	wd.commandLogPath = f.Name()
+	wd.tf.SetLogPath(wd.commandLogPath)
+
+	stopTail := func() {}
+	if logger != nil {
+		stopTail = tailLog(wd.commandLogPath, func(line string) {
+			logger("terraform " + cmd.Name + ": " + line)
+		})
+	}
+
+	return func() {
+		stopTail()
+		defer func() {
+			os.Remove(wd.commandLogPath)
+			wd.commandLogPath = ""
+			wd.tf.SetLogPath(wd.configuredLogPath())
+		}()
+
+		if !wd.captureProviderOutput {
+			return
+		}
+		log, err := ioutil.ReadFile(wd.commandLogPath)
+		if err != nil {
+			return
+		}
+
+		// The log would otherwise have gone to the configured log path, so
+		// keep that working.
+		if p := wd.configuredLogPath(); p != "" {
+			if f, err := os.OpenFile(p, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644); err == nil {
+				f.Write(log)
+				f.Close()
+			}
+		}
+
+		var out strings.Builder
+		sc := bufio.NewScanner(strings.NewReader(string(log)))
+		sc.Buffer(nil, 16*1024*1024)
+		for sc.Scan() {
+			if match := providerLogLineRegexp.FindStringSubmatch(sc.Text()); match != nil {
+				out.WriteString(match[1])
+				out.WriteString("\n")
+			}
+		}
+		cmd.ProviderOutput = out.String()
+		wd.providerOutput.WriteString(cmd.ProviderOutput)
+	}, nil
+}
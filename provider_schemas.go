@@ -0,0 +1,29 @@
+package tftest
+
+import (
+	tfjson "github.com/hashicorp/terraform-json"
+)
+
+// ProviderSchemas runs "terraform providers schema -json" and returns the
+// decoded schemas of all of the providers the configuration requires, as
+// served by the providers through the real plugin protocol. This allows a
+// test to check the provider's published schema, including which attributes
+// and blocks are deprecated, end to end.
+//
+// ProviderSchemas is equivalent to Schemas. The working directory must be
+// initialized.
+func (wd *WorkingDir) ProviderSchemas() (*tfjson.ProviderSchemas, error) {
+	return wd.Schemas()
+}
+
+// RequireProviderSchemas is a variant of ProviderSchemas that will fail the
+// test via the given TestControl if the schemas cannot be read.
+func (wd *WorkingDir) RequireProviderSchemas(t TestControl) *tfjson.ProviderSchemas {
+	t.Helper()
+	ret, err := wd.ProviderSchemas()
+	if err != nil {
+		t := testingT{t}
+		t.Fatalf("failed to read provider schemas: %s", err)
+	}
+	return ret
+}
@@ -0,0 +1,272 @@
+package tftest
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Query evaluates a path expression against the given JSON document, such as
+// the raw state returned by RawState, and returns the value it refers to. This
+// is useful for assertions about parts of the JSON that the typed
+// representations don't cover.
+//
+// A path is a sequence of steps separated by periods. Each step is one of the
+// following:
+//
+//   - An object property name, such as "values". A literal period in a name
+//     can be escaped with a backslash.
+//   - An array index, such as "0".
+//   - "#", which refers to the length of an array.
+//   - "#(key==value)", which refers to the first element of an array whose
+//     property key (itself a path) has the given value. "!=" may be used
+//     instead of "==" to match elements which do not have that value.
+//   - "#(key==value)#", which is like the above but refers to all matching
+//     elements, with the remaining steps applied to each element in turn.
+//
+// The value in a condition is a JSON value, such as "x" or 2, or otherwise is
+// treated as a bare string. For example:
+//
+//	resource_changes.#(address=="aws_instance.foo").change.after.tags
+//
+// If the path doesn't resolve then the returned error describes the first
+// step that failed and what was found there instead.
+func Query(src []byte, path string) (interface{}, error) {
+	var doc interface{}
+	err := json.Unmarshal(src, &doc)
+	if err != nil {
+		return nil, fmt.Errorf("invalid JSON: %s", err)
+	}
+
+	steps, err := splitQueryPath(path)
+	if err != nil {
+		return nil, err
+	}
+	return queryValue(doc, steps, nil)
+}
+
+// QueryState evaluates a path expression against the JSON representation of
+// the current state, as described for Query.
+func (wd *WorkingDir) QueryState(path string) (interface{}, error) {
+	src, err := wd.RawState()
+	if err != nil {
+		return nil, err
+	}
+	return Query(src, path)
+}
+
+// RequireQueryState is a variant of QueryState that will fail the test via
+// the given TestControl if the state cannot be read or the path doesn't
+// resolve.
+func (wd *WorkingDir) RequireQueryState(t TestControl, path string) interface{} {
+	t.Helper()
+	ret, err := wd.QueryState(path)
+	if err != nil {
+		t := testingT{t}
+		t.Fatalf("failed to query state: %s", err)
+	}
+	return ret
+}
+
+// QuerySavedPlan evaluates a path expression against the JSON representation
+// of the current saved plan, as described for Query.
+func (wd *WorkingDir) QuerySavedPlan(path string) (interface{}, error) {
+	src, err := wd.savedPlanJSON()
+	if err != nil {
+		return nil, err
+	}
+	return Query([]byte(src), path)
+}
+
+// RequireQuerySavedPlan is a variant of QuerySavedPlan that will fail the
+// test via the given TestControl if the plan cannot be read or the path
+// doesn't resolve.
+func (wd *WorkingDir) RequireQuerySavedPlan(t TestControl, path string) interface{} {
+	t.Helper()
+	ret, err := wd.QuerySavedPlan(path)
+	if err != nil {
+		t := testingT{t}
+		t.Fatalf("failed to query saved plan: %s", err)
+	}
+	return ret
+}
+
+// splitQueryPath splits a path into its steps at each unescaped period that
+// isn't inside a condition.
+func splitQueryPath(path string) ([]string, error) {
+	var steps []string
+	var current strings.Builder
+	depth := 0
+	for i := 0; i < len(path); i++ {
+		c := path[i]
+		switch {
+		case c == '\\' && i+1 < len(path):
+			if depth > 0 {
+				current.WriteByte(c)
+			}
+			i++
+			current.WriteByte(path[i])
+		case c == '(':
+			depth++
+			current.WriteByte(c)
+		case c == ')':
+			depth--
+			if depth < 0 {
+				return nil, fmt.Errorf("invalid path %q: unbalanced parentheses", path)
+			}
+			current.WriteByte(c)
+		case c == '.' && depth == 0:
+			steps = append(steps, current.String())
+			current.Reset()
+		default:
+			current.WriteByte(c)
+		}
+	}
+	if depth != 0 {
+		return nil, fmt.Errorf("invalid path %q: unbalanced parentheses", path)
+	}
+	steps = append(steps, current.String())
+	return steps, nil
+}
+
+// queryValue applies the given steps to v. done is the steps already applied,
+// for use in error messages.
+func queryValue(v interface{}, steps []string, done []string) (interface{}, error) {
+	for i, step := range steps {
+		at := strings.Join(done, ".")
+		if at == "" {
+			at = "the document root"
+		}
+
+		switch {
+		case step == "#":
+			arr, ok := v.([]interface{})
+			if !ok {
+				return nil, fmt.Errorf("cannot take length at %s: %s", at, describeJSON(v))
+			}
+			v = float64(len(arr))
+
+		case strings.HasPrefix(step, "#(") && (strings.HasSuffix(step, ")") || strings.HasSuffix(step, ")#")):
+			all := strings.HasSuffix(step, ")#")
+			arr, ok := v.([]interface{})
+			if !ok {
+				return nil, fmt.Errorf("cannot apply %s at %s: %s", step, at, describeJSON(v))
+			}
+			cond := strings.TrimPrefix(step, "#(")
+			cond = strings.TrimSuffix(strings.TrimSuffix(cond, "#"), ")")
+			match, err := queryCondition(cond)
+			if err != nil {
+				return nil, err
+			}
+
+			var matches []interface{}
+			for _, elem := range arr {
+				if match(elem) {
+					matches = append(matches, elem)
+				}
+			}
+
+			if !all {
+				if len(matches) == 0 {
+					return nil, fmt.Errorf("no element at %s matches %s", at, step)
+				}
+				v = matches[0]
+				break
+			}
+
+			ret := make([]interface{}, 0, len(matches))
+			rest := steps[i+1:]
+			for j, elem := range matches {
+				elemV, err := queryValue(elem, rest, append(append(done, step), strconv.Itoa(j)))
+				if err != nil {
+					return nil, err
+				}
+				ret = append(ret, elemV)
+			}
+			return ret, nil
+
+		default:
+			switch tv := v.(type) {
+			case map[string]interface{}:
+				next, ok := tv[step]
+				if !ok {
+					return nil, fmt.Errorf("no property %q at %s; has %s", step, at, describeJSON(v))
+				}
+				v = next
+			case []interface{}:
+				idx, err := strconv.Atoi(step)
+				if err != nil || idx < 0 {
+					return nil, fmt.Errorf("invalid step %q at %s: must be an index into %s", step, at, describeJSON(v))
+				}
+				if idx >= len(tv) {
+					return nil, fmt.Errorf("no element %d at %s: %s", idx, at, describeJSON(v))
+				}
+				v = tv[idx]
+			default:
+				return nil, fmt.Errorf("no property %q at %s: %s", step, at, describeJSON(v))
+			}
+		}
+
+		done = append(done, step)
+	}
+	return v, nil
+}
+
+// queryCondition parses a condition of the form key==value or key!=value into
+// a function that tests whether an array element satisfies it.
+func queryCondition(cond string) (func(interface{}) bool, error) {
+	op := "=="
+	idx := strings.Index(cond, op)
+	if ne := strings.Index(cond, "!="); ne >= 0 && (idx < 0 || ne < idx) {
+		op, idx = "!=", ne
+	}
+	if idx < 0 {
+		return nil, fmt.Errorf("invalid condition %q: must be key==value or key!=value", cond)
+	}
+
+	keySteps, err := splitQueryPath(strings.TrimSpace(cond[:idx]))
+	if err != nil {
+		return nil, err
+	}
+	rawWant := strings.TrimSpace(cond[idx+len(op):])
+	var want interface{}
+	if json.Unmarshal([]byte(rawWant), &want) != nil {
+		want = rawWant
+	}
+
+	return func(elem interface{}) bool {
+		got, err := queryValue(elem, keySteps, nil)
+		equal := err == nil && reflect.DeepEqual(got, want)
+		if op == "!=" {
+			return !equal
+		}
+		return equal
+	}, nil
+}
+
+// describeJSON returns a short description of a decoded JSON value, for error
+// messages.
+func describeJSON(v interface{}) string {
+	switch tv := v.(type) {
+	case map[string]interface{}:
+		keys := make([]string, 0, len(tv))
+		for k := range tv {
+			keys = append(keys, strconv.Quote(k))
+		}
+		sort.Strings(keys)
+		if len(keys) == 0 {
+			return "an empty object"
+		}
+		return "an object with properties " + strings.Join(keys, ", ")
+	case []interface{}:
+		return fmt.Sprintf("an array of %d elements", len(tv))
+	case nil:
+		return "null"
+	default:
+		src, _ := json.Marshal(v)
+		return "the value " + string(src)
+	}
+}
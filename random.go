@@ -0,0 +1,85 @@
+package tftest
+
+import (
+	"fmt"
+	"math/rand"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const randomCharset = "abcdefghijklmnopqrstuvwxyz0123456789"
+
+// random is the shared source of randomness for the name and ID helpers in
+// this package. It is seeded from TF_ACC_RANDOM_SEED if set, so that a failed
+// run can be reproduced with identical generated names.
+var random struct {
+	sync.Mutex
+	rand *rand.Rand
+	seed int64
+	used bool
+}
+
+func init() {
+	seed := time.Now().UnixNano()
+	if s := os.Getenv("TF_ACC_RANDOM_SEED"); s != "" {
+		if v, err := strconv.ParseInt(s, 10, 64); err == nil {
+			seed = v
+		} else {
+			fmt.Fprintf(os.Stderr, "ignoring invalid TF_ACC_RANDOM_SEED %q: %s\n", s, err)
+		}
+	}
+	SetRandomSeed(seed)
+}
+
+// SetRandomSeed resets the source used by RandomString and RandomName to the
+// given seed. This can be called in TestMain as an alternative to setting the
+// TF_ACC_RANDOM_SEED environment variable.
+func SetRandomSeed(seed int64) {
+	random.Lock()
+	defer random.Unlock()
+	random.rand = rand.New(rand.NewSource(seed))
+	random.seed = seed
+}
+
+// RandomSeed returns the seed currently in use by RandomString and
+// RandomName.
+func RandomSeed() int64 {
+	random.Lock()
+	defer random.Unlock()
+	return random.seed
+}
+
+// RandomString returns a random string of the given length containing only
+// lowercase letters and digits, which are accepted in names by most remote
+// APIs.
+func RandomString(length int) string {
+	random.Lock()
+	defer random.Unlock()
+	random.used = true
+
+	b := make([]byte, length)
+	for i := range b {
+		b[i] = randomCharset[random.rand.Intn(len(randomCharset))]
+	}
+	return string(b)
+}
+
+// RandomName returns the given prefix followed by a hyphen and a random
+// suffix, for naming remote objects uniquely within a test run.
+func RandomName(prefix string) string {
+	return prefix + "-" + RandomString(10)
+}
+
+// randomSeedMessage returns a message describing how to reproduce the
+// current run's generated names, or an empty string if no names have been
+// generated.
+func randomSeedMessage() string {
+	random.Lock()
+	defer random.Unlock()
+	if !random.used {
+		return ""
+	}
+	return fmt.Sprintf("random names were generated with seed %d; set TF_ACC_RANDOM_SEED=%d to reproduce them", random.seed, random.seed)
+}
@@ -0,0 +1,113 @@
+package tftest
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// UseReleasedProvider makes the working directory install the given released
+// version of the provider with the given source address from its registry,
+// rather than any local build of it registered with Helper.AddProviderBinary.
+// With two working directories, one using the released version and one the
+// local build, and CopyStateFrom to move the state between them, a test can
+// check that upgrading from a release to the current code works.
+//
+// The version is pinned by writing an override file to the working
+// directory, which replaces the provider's entry in the configuration's
+// required_providers, so it takes effect for whatever configuration is set
+// later. Any dependency lock file is removed, since it may select the local
+// build's version instead, and Init must be run again before other commands.
+// This requires Terraform v0.12 or later.
+func (wd *WorkingDir) UseReleasedProvider(source, version string) error {
+	if wd.h.LegacyMode() {
+		return fmt.Errorf("released providers can't be pinned for Terraform v%s", wd.h.terraformVersion)
+	}
+	hostname, namespace, typeName, err := parseProviderSource(source)
+	if err != nil {
+		return err
+	}
+	if version == "" {
+		return fmt.Errorf("no version given for released provider %s", source)
+	}
+
+	err = wd.uninstallProviderBinary(hostname, namespace, typeName)
+	if err != nil {
+		return err
+	}
+
+	entry := fmt.Sprintf("{\n      source  = %s\n      version = %s\n    }", hclLiteral(hostname+"/"+namespace+"/"+typeName), hclLiteral("= "+version))
+	if wd.h.terraformVersion.LessThan(filesystemMirrorVersion) {
+		// Terraform 0.12 has only version constraints, for providers from
+		// the public registry.
+		entry = hclLiteral("= " + version)
+	}
+	cfg := fmt.Sprintf("terraform {\n  required_providers {\n    %s = %s\n  }\n}\n", typeName, entry)
+	err = ioutil.WriteFile(wd.releasedProviderFilename(typeName), []byte(cfg), 0644)
+	if err != nil {
+		return err
+	}
+
+	err = os.Remove(filepath.Join(wd.baseDir, ".terraform.lock.hcl"))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	wd.initSum = nil
+	return wd.ClearPlan()
+}
+
+// RequireUseReleasedProvider is a variant of UseReleasedProvider that will
+// fail the test via the given TestControl if the provider cannot be pinned.
+func (wd *WorkingDir) RequireUseReleasedProvider(t TestControl, source, version string) {
+	t.Helper()
+	if err := wd.UseReleasedProvider(source, version); err != nil {
+		t := testingT{t}
+		t.Fatalf("failed to use released provider: %s", err)
+	}
+}
+
+// releasedProviderFilename is the override file pinning the released
+// version of the provider with the given type.
+func (wd *WorkingDir) releasedProviderFilename(typeName string) string {
+	return filepath.Join(wd.baseDir, "terraform_plugin_test_release_"+typeName+"_override.tf")
+}
+
+// uninstallProviderBinary removes the executables for the provider with the
+// given source address parts from the working directory, undoing
+// installProviderBinaries.
+func (wd *WorkingDir) uninstallProviderBinary(hostname, namespace, typeName string) error {
+	var kept []ProviderBinary
+	for _, provider := range wd.providerBinaries {
+		h, n, t, _ := parseProviderSource(provider.Source)
+		if h != hostname || n != namespace || t != typeName {
+			kept = append(kept, provider)
+		}
+	}
+	wd.providerBinaries = kept
+
+	dirs := []string{
+		filepath.Join(wd.providerMirrorDir(), hostname, namespace, typeName),
+		filepath.Join(wd.baseDir, ".tftest-providers", hostname, namespace, typeName),
+	}
+	for _, dir := range dirs {
+		if err := os.RemoveAll(dir); err != nil {
+			return err
+		}
+	}
+	name := filepath.Join(wd.legacyPluginDir(), legacyPluginName(typeName, ""))
+	legacy, _ := filepath.Glob(name + "_v*")
+	for _, p := range append(legacy, name, name+".exe") {
+		if err := os.Remove(p); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	if len(wd.providerBinaries) == 0 && wd.env["TF_CLI_CONFIG_FILE"] == filepath.Join(wd.baseDir, ".tftest.tfrc") {
+		// installProviderBinaries leaves the dev_overrides in place when
+		// there are no executables
+		wd.Unsetenv("TF_CLI_CONFIG_FILE")
+		return os.Remove(filepath.Join(wd.baseDir, ".tftest.tfrc"))
+	}
+	return wd.installProviderBinaries()
+}
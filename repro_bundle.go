@@ -0,0 +1,232 @@
+package tftest
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// redactedValue replaces sensitive values in the state included in a repro
+// bundle.
+const redactedValue = "(redacted)"
+
+// WriteReproBundle writes a gzipped tar archive to w containing everything
+// needed to reproduce a problem seen in the working directory, for attaching
+// to a bug report: the configuration and variables files, the state with its
+// sensitive values redacted, the saved plan if any, the Terraform CLI version
+// and platform, and the log of all commands run so far.
+//
+// Use Helper.RestoreReproBundle to re-create a working directory from the
+// bundle. The saved plan may itself contain sensitive values, so review the
+// bundle before sharing it.
+func (wd *WorkingDir) WriteReproBundle(w io.Writer) error {
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	add := func(name string, content []byte) error {
+		err := tw.WriteHeader(&tar.Header{
+			Name:    name,
+			Mode:    0644,
+			Size:    int64(len(content)),
+			ModTime: now(),
+		})
+		if err != nil {
+			return err
+		}
+		_, err = tw.Write(content)
+		return err
+	}
+
+	files, err := ioutil.ReadDir(wd.baseDir)
+	if err != nil {
+		return err
+	}
+	for _, f := range files {
+		n := f.Name()
+		if !f.Mode().IsRegular() {
+			continue
+		}
+		isConfig := strings.HasSuffix(n, ".tf") || strings.HasSuffix(n, ".tf.json") || strings.HasSuffix(n, ".tfvars.json")
+		if !isConfig && n != PlanFileName {
+			continue
+		}
+		src, err := ioutil.ReadFile(filepath.Join(wd.baseDir, n))
+		if err != nil {
+			return err
+		}
+		if err := add(n, src); err != nil {
+			return err
+		}
+	}
+
+	if src, err := ioutil.ReadFile(wd.stateFilename()); err == nil {
+		src, err = redactState(src)
+		if err != nil {
+			return fmt.Errorf("failed to redact state: %s", err)
+		}
+		if err := add("terraform.tfstate", src); err != nil {
+			return err
+		}
+	}
+
+	version := fmt.Sprintf("Terraform v%s\non %s_%s\nworkspace %s\n", wd.h.terraformVersion, runtime.GOOS, runtime.GOARCH, wd.Workspace())
+	if err := add("version.txt", []byte(version)); err != nil {
+		return err
+	}
+	if err := add("commands.log", []byte(wd.commandLog())); err != nil {
+		return err
+	}
+
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+// RestoreReproBundle creates a new working directory containing the files
+// from a bundle written by WorkingDir.WriteReproBundle, ready to be
+// initialized to reproduce the problem. Redacted values in the state are
+// restored as the placeholder "(redacted)".
+func (h *Helper) RestoreReproBundle(r io.Reader) (_ *WorkingDir, err error) {
+	wd, err := h.NewWorkingDir()
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err != nil {
+			os.RemoveAll(wd.baseDir)
+		}
+	}()
+
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, fmt.Errorf("invalid repro bundle: %s", err)
+	}
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid repro bundle: %s", err)
+		}
+
+		name := path.Clean(hdr.Name)
+		if name == "commands.log" || name == "version.txt" {
+			continue
+		}
+		if strings.Contains(name, "/") || name == ".." {
+			return nil, fmt.Errorf("invalid repro bundle: unexpected file %s", hdr.Name)
+		}
+		src, err := ioutil.ReadAll(tr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid repro bundle: %s", err)
+		}
+		err = ioutil.WriteFile(filepath.Join(wd.baseDir, name), src, 0644)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return wd, nil
+}
+
+// RequireRestoreReproBundle is a variant of RestoreReproBundle that will fail
+// the test via the given TestControl if the bundle cannot be restored.
+func (h *Helper) RequireRestoreReproBundle(t TestControl, r io.Reader) *WorkingDir {
+	t.Helper()
+	wd, err := h.RestoreReproBundle(r)
+	if err != nil {
+		t := testingT{t}
+		t.Fatalf("failed to restore repro bundle: %s", err)
+	}
+	return wd
+}
+
+// storeReproBundle writes a repro bundle for a failed command to the
+// helper's artifact store, if FeatureReproBundles is enabled.
+func (wd *WorkingDir) storeReproBundle(cmd Command) {
+	if !wd.h.FeatureEnabled(FeatureReproBundles) {
+		return
+	}
+	if wd.h.artifactStore == nil {
+		fmt.Fprintf(os.Stderr, "WARNING: cannot write repro bundle for failed terraform %s because there is no artifact store\n", cmd.Name)
+		return
+	}
+
+	var buf bytes.Buffer
+	err := wd.WriteReproBundle(&buf)
+	if err == nil {
+		key := path.Join(wd.artifactPrefix(), fmt.Sprintf("repro-%d-%s.tar.gz", len(wd.history), strings.Replace(cmd.Name, " ", "-", -1)))
+		err = wd.h.artifactStore.StoreArtifact(key, buf.Bytes())
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "WARNING: failed to write repro bundle for failed terraform %s: %s\n", cmd.Name, err)
+	}
+}
+
+// redactState replaces the values of sensitive outputs and sensitive
+// resource attributes in the given state file with a placeholder.
+func redactState(src []byte) ([]byte, error) {
+	var state map[string]interface{}
+	err := json.Unmarshal(src, &state)
+	if err != nil {
+		return nil, err
+	}
+
+	outputs, _ := state["outputs"].(map[string]interface{})
+	for _, o := range outputs {
+		if output, _ := o.(map[string]interface{}); output != nil && output["sensitive"] == true {
+			output["value"] = redactedValue
+		}
+	}
+
+	resources, _ := state["resources"].([]interface{})
+	for _, r := range resources {
+		resource, _ := r.(map[string]interface{})
+		instances, _ := resource["instances"].([]interface{})
+		for _, i := range instances {
+			instance, _ := i.(map[string]interface{})
+			paths, _ := instance["sensitive_attributes"].([]interface{})
+			for _, p := range paths {
+				steps, _ := p.([]interface{})
+				redactPath(instance["attributes"], steps)
+			}
+		}
+	}
+
+	return json.MarshalIndent(state, "", "  ")
+}
+
+// redactPath replaces the value at the given path, in the form used for
+// sensitive_attributes in state, with a placeholder.
+func redactPath(v interface{}, steps []interface{}) interface{} {
+	if len(steps) == 0 {
+		if v == nil {
+			return nil
+		}
+		return redactedValue
+	}
+	step, _ := steps[0].(map[string]interface{})
+	switch tv := v.(type) {
+	case map[string]interface{}:
+		if key, ok := step["value"].(string); ok {
+			if _, exists := tv[key]; exists {
+				tv[key] = redactPath(tv[key], steps[1:])
+			}
+		}
+	case []interface{}:
+		if idx, ok := step["value"].(float64); ok && int(idx) >= 0 && int(idx) < len(tv) {
+			tv[int(idx)] = redactPath(tv[int(idx)], steps[1:])
+		}
+	}
+	return v
+}
@@ -0,0 +1,69 @@
+package tftest
+
+import (
+	"fmt"
+	"runtime"
+	"time"
+)
+
+// ResourceLimits are limits on the operating system resources available to
+// each Terraform process run in a working directory, and to the provider
+// plugin processes it starts, as set with SetResourceLimits.
+//
+// The limits are applied as resource limits (rlimits) of each individual
+// process, which the provider plugins inherit from Terraform. They are not a
+// budget shared by all of the processes together, as a cgroup would impose,
+// so for example a plan that starts three provider plugins may use up to
+// four times MemoryBytes in total. A zero value for any field leaves that
+// resource unlimited.
+type ResourceLimits struct {
+	// MemoryBytes limits the virtual address space of each process, in
+	// bytes. Go programs, including Terraform and most providers, reserve
+	// much more address space than they use, so this must be set well
+	// above the memory a process is expected to need.
+	MemoryBytes uint64
+
+	// CPUTime limits the processor time each process may use, rounded up to
+	// a whole number of seconds. A process that exceeds it is killed.
+	CPUTime time.Duration
+
+	// OpenFiles limits the number of files each process may have open at
+	// once, including network connections.
+	OpenFiles uint64
+}
+
+func (l ResourceLimits) isZero() bool {
+	return l == ResourceLimits{}
+}
+
+// SetResourceLimits sets limits on the resources available to the Terraform
+// processes run in the working directory from now on, and to the provider
+// plugins they start, so that a provider can be tested under constrained
+// conditions and a runaway provider process can't exhaust the resources of
+// the host. This is supported only on Linux, and returns an error on other
+// platforms.
+//
+// The limits apply to the commands which can call into providers, such as
+// init, plan, apply, destroy, refresh and import. While limits are set, these
+// commands run Terraform directly rather than via terraform-exec, in the
+// same way as their Context variants. Each is started via a copy of the test
+// program, which sets the limits on itself and then executes Terraform in its
+// place, so that the limits are in effect before Terraform starts.
+func (wd *WorkingDir) SetResourceLimits(limits ResourceLimits) error {
+	if !resourceLimitsSupported && !limits.isZero() {
+		return fmt.Errorf("resource limits are not supported on %s", runtime.GOOS)
+	}
+	wd.resourceLimits = limits
+	return nil
+}
+
+// SetResourceLimits sets the resource limits for the working directories
+// created by the helper from now on, as described for
+// WorkingDir.SetResourceLimits.
+func (h *Helper) SetResourceLimits(limits ResourceLimits) error {
+	if !resourceLimitsSupported && !limits.isZero() {
+		return fmt.Errorf("resource limits are not supported on %s", runtime.GOOS)
+	}
+	h.resourceLimits = limits
+	return nil
+}
@@ -0,0 +1,111 @@
+package tftest
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+const resourceLimitsSupported = true
+
+// resourceLimitsEnv is set in the environment of a copy of the test program
+// started by limitCommand, giving the limits it should set on itself before
+// executing the command in its arguments.
+const resourceLimitsEnv = "TF_ACC_RESOURCE_LIMITS_EXEC"
+
+// resourceLimitNames are the names of the resources in the value of
+// resourceLimitsEnv.
+var resourceLimitNames = map[string]int{
+	"as":     syscall.RLIMIT_AS,
+	"cpu":    syscall.RLIMIT_CPU,
+	"nofile": syscall.RLIMIT_NOFILE,
+}
+
+func init() {
+	spec, ok := os.LookupEnv(resourceLimitsEnv)
+	if !ok {
+		return
+	}
+	err := execWithResourceLimits(spec, os.Args[1:])
+	fmt.Fprintf(os.Stderr, "failed to run command with resource limits: %s\n", err)
+	os.Exit(1)
+}
+
+// limitCommand arranges for the given command, which must not yet have been
+// started, to run with the given limits from the moment it starts. It runs
+// the command via a copy of the test program, which sets the limits on
+// itself and then executes the command in its place, keeping the same
+// process ID.
+func limitCommand(cmd *exec.Cmd, limits ResourceLimits) error {
+	self, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("failed to find the test program: %s", err)
+	}
+	cpuSeconds := uint64((limits.CPUTime + 999999999) / 1000000000)
+	var spec []string
+	for name, limit := range map[string]uint64{
+		"as":     limits.MemoryBytes,
+		"cpu":    cpuSeconds,
+		"nofile": limits.OpenFiles,
+	} {
+		if limit != 0 {
+			spec = append(spec, fmt.Sprintf("%s=%d", name, limit))
+		}
+	}
+
+	cmd.Args = append([]string{self, cmd.Path}, cmd.Args[1:]...)
+	cmd.Path = self
+	if cmd.Env == nil {
+		cmd.Env = os.Environ()
+	}
+	cmd.Env = append(cmd.Env, resourceLimitsEnv+"="+strings.Join(spec, ","))
+	return nil
+}
+
+// execWithResourceLimits sets the limits described by the given value of
+// resourceLimitsEnv on the current process and then replaces it with the
+// given command. It returns only if that fails.
+func execWithResourceLimits(spec string, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("no command")
+	}
+	rlimits := map[int]uint64{}
+	for _, item := range strings.Split(spec, ",") {
+		if item == "" {
+			continue
+		}
+		parts := strings.SplitN(item, "=", 2)
+		resource, ok := resourceLimitNames[parts[0]]
+		if !ok || len(parts) != 2 {
+			return fmt.Errorf("invalid resource limit %q", item)
+		}
+		limit, err := strconv.ParseUint(parts[1], 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid resource limit %q", item)
+		}
+		rlimits[resource] = limit
+	}
+
+	path, err := exec.LookPath(args[0])
+	if err != nil {
+		return err
+	}
+	os.Unsetenv(resourceLimitsEnv)
+	env := os.Environ()
+
+	// The address space limit is set last, so that it constrains as little
+	// as possible of what this process does before it executes the command.
+	for _, resource := range []int{syscall.RLIMIT_NOFILE, syscall.RLIMIT_CPU, syscall.RLIMIT_AS} {
+		limit, ok := rlimits[resource]
+		if !ok {
+			continue
+		}
+		if err := syscall.Setrlimit(resource, &syscall.Rlimit{Cur: limit, Max: limit}); err != nil {
+			return fmt.Errorf("failed to set resource limit: %s", err)
+		}
+	}
+	return syscall.Exec(path, args, env)
+}
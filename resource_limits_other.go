@@ -0,0 +1,14 @@
+//go:build !linux
+// +build !linux
+
+package tftest
+
+import "os/exec"
+
+const resourceLimitsSupported = false
+
+// limitCommand does nothing on this platform, because SetResourceLimits
+// rejects any limits.
+func limitCommand(cmd *exec.Cmd, limits ResourceLimits) error {
+	return nil
+}
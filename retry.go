@@ -0,0 +1,133 @@
+package tftest
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// RetryPolicy controls whether a failed Terraform command is run again, for
+// working around transient failures of remote APIs, as set with
+// WorkingDir.SetRetryPolicy or Helper.SetRetryPolicy.
+//
+// Every command that succeeds only after retrying is recorded as a flaky
+// candidate and reported when the helper is closed, so that the tests that
+// most need stabilizing can be identified.
+type RetryPolicy struct {
+	// MaxAttempts is the most times a command is run, including the first
+	// attempt. Values less than 2 disable retries.
+	MaxAttempts int
+
+	// Delay is how long to wait between attempts.
+	Delay time.Duration
+
+	// Retryable decides whether the given failure is worth retrying. If nil,
+	// all Terraform command failures are retried. Errors detected before
+	// running a command are never retried.
+	Retryable func(err *TerraformError) bool
+}
+
+// SetRetryPolicy sets the policy for retrying the failed Terraform commands
+// run in the working directory from now on.
+//
+// A failed apply of a saved plan leaves that plan stale, so retrying is most
+// useful with commands that plan implicitly.
+func (wd *WorkingDir) SetRetryPolicy(policy RetryPolicy) {
+	wd.retryPolicy = policy
+}
+
+// SetRetryPolicy sets the retry policy for the working directories created
+// by the helper from now on, as described for WorkingDir.SetRetryPolicy.
+func (h *Helper) SetRetryPolicy(policy RetryPolicy) {
+	h.retryPolicy = policy
+}
+
+// retry returns whether the given failed attempt of a command should be
+// followed by another.
+func (p RetryPolicy) retry(attempt int, err error) bool {
+	var tfErr *TerraformError
+	if attempt >= p.MaxAttempts || !errors.As(err, &tfErr) {
+		return false
+	}
+	return p.Retryable == nil || p.Retryable(tfErr)
+}
+
+// FlakyCommand describes a Terraform command which succeeded only after
+// being retried.
+type FlakyCommand struct {
+	Test     string `json:"test"`
+	Command  string `json:"command"`
+	Attempts int    `json:"attempts"`
+
+	// FirstError is the message of the error from the first attempt.
+	FirstError string `json:"first_error"`
+}
+
+// recordFlaky adds a command that succeeded only after retrying to the
+// helper's flaky candidates.
+func (h *Helper) recordFlaky(flaky FlakyCommand) {
+	h.flakyMu.Lock()
+	defer h.flakyMu.Unlock()
+	h.flaky = append(h.flaky, flaky)
+}
+
+// FlakyCandidates returns all of the commands so far that succeeded only
+// after retrying, in the order they completed.
+func (h *Helper) FlakyCandidates() []FlakyCommand {
+	h.flakyMu.Lock()
+	defer h.flakyMu.Unlock()
+	ret := make([]FlakyCommand, len(h.flaky))
+	copy(ret, h.flaky)
+	return ret
+}
+
+// writeFlakyReport writes a summary of the flaky candidates to w, grouped by
+// test with the tests that retried most often first. If the environment
+// variable TF_ACC_FLAKY_REPORT_PATH is set, the full list is also written to
+// that file as JSON.
+func (h *Helper) writeFlakyReport(w io.Writer) error {
+	flaky := h.FlakyCandidates()
+	if len(flaky) == 0 {
+		return nil
+	}
+
+	byTest := map[string][]FlakyCommand{}
+	var tests []string
+	for _, f := range flaky {
+		if _, ok := byTest[f.Test]; !ok {
+			tests = append(tests, f.Test)
+		}
+		byTest[f.Test] = append(byTest[f.Test], f)
+	}
+	sort.SliceStable(tests, func(i, j int) bool {
+		return len(byTest[tests[i]]) > len(byTest[tests[j]])
+	})
+
+	fmt.Fprintf(w, "%d tests had commands that succeeded only after retrying (flaky candidates):\n", len(tests))
+	for _, test := range tests {
+		fmt.Fprintf(w, "  %s:\n", test)
+		for _, f := range byTest[test] {
+			firstLine := strings.SplitN(f.FirstError, "\n", 2)[0]
+			fmt.Fprintf(w, "    %s succeeded on attempt %d: %s\n", f.Command, f.Attempts, firstLine)
+		}
+	}
+
+	if p := os.Getenv("TF_ACC_FLAKY_REPORT_PATH"); p != "" {
+		src, err := json.MarshalIndent(flaky, "", "  ")
+		if err != nil {
+			return err
+		}
+		err = ioutil.WriteFile(p, src, 0644)
+		if err != nil {
+			return fmt.Errorf("failed to write flaky report: %w", err)
+		}
+		emitEvent(Event{Type: EventArtifactWritten, Path: p})
+	}
+	return nil
+}
@@ -0,0 +1,50 @@
+package tftest
+
+import (
+	"context"
+	"fmt"
+)
+
+// multiWordCommands are the Terraform subcommands which have subcommands of
+// their own, such as "state mv".
+var multiWordCommands = map[string]bool{
+	"providers": true,
+	"state":     true,
+	"workspace": true,
+}
+
+// RunCommand runs Terraform in the working directory with the given command
+// line arguments, starting with the subcommand, and returns everything it
+// wrote to stdout. This allows testing commands that the working directory
+// has no dedicated method for, such as when developing a backend or a
+// provisioner rather than a provider.
+//
+// The command gets the same treatment as those the dedicated methods run: it
+// is logged and recorded in the command history, subject to plan-only mode,
+// timeouts and the retry policy, and a failure is returned as a
+// *TerraformError with diagnostics. Pass -no-color for output that can be
+// parsed for diagnostics.
+func (wd *WorkingDir) RunCommand(args ...string) (string, error) {
+	if len(args) == 0 {
+		return "", fmt.Errorf("no Terraform subcommand given")
+	}
+	name := args[0]
+	if multiWordCommands[name] && len(args) > 1 {
+		name += " " + args[1]
+	}
+	return wd.runStdout(name, func() error {
+		return wd.runTerraform(context.Background(), args...)
+	})
+}
+
+// RequireRunCommand is a variant of RunCommand that will fail the test via the
+// given TestControl if the command fails.
+func (wd *WorkingDir) RequireRunCommand(t TestControl, args ...string) string {
+	t.Helper()
+	ret, err := wd.RunCommand(args...)
+	if err != nil {
+		t := testingT{t}
+		t.Fatalf("failed to run terraform: %s", err)
+	}
+	return ret
+}
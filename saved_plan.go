@@ -0,0 +1,93 @@
+package tftest
+
+import (
+	"fmt"
+	"path/filepath"
+)
+
+// ApplySavedPlan applies the plan saved by CreatePlan, or one of its
+// variants, exactly as it was planned, returning an error if there is no
+// saved plan rather than implicitly creating a new one as Apply does.
+//
+// This is needed to test for regressions where the provider produces a
+// final plan during apply that is inconsistent with the saved one, which an
+// implicit plan and apply can't detect.
+func (wd *WorkingDir) ApplySavedPlan() error {
+	if !wd.HasSavedPlan() {
+		return fmt.Errorf("there is no current saved plan; call CreatePlan first")
+	}
+	return wd.Apply()
+}
+
+// RequireApplySavedPlan is a variant of ApplySavedPlan that will fail the test
+// via the given TestControl if there is no saved plan or the apply fails.
+func (wd *WorkingDir) RequireApplySavedPlan(t TestControl) {
+	t.Helper()
+	if err := wd.ApplySavedPlan(); err != nil {
+		t := testingT{t}
+		t.Fatalf("failed to apply saved plan: %s", err)
+	}
+}
+
+// runPlan runs the given function, which creates a new saved plan, as the
+// Terraform command "plan", recording that the new plan hasn't been applied
+// if it succeeds.
+func (wd *WorkingDir) runPlan(f func() error) error {
+	err := wd.run("plan", f)
+	if err == nil {
+		wd.planApplied = false
+	}
+	return err
+}
+
+// checkPlanNotApplied returns an error if the current saved plan has already
+// been applied, in which case Terraform would reject it as stale.
+func (wd *WorkingDir) checkPlanNotApplied() error {
+	if wd.planApplied {
+		return fmt.Errorf("the saved plan has already been applied; call CreatePlan to plan again, or ClearPlan to apply with an implicit plan")
+	}
+	return nil
+}
+
+// markPlanApplied records that the current saved plan has been applied.
+func (wd *WorkingDir) markPlanApplied() {
+	wd.planApplied = true
+}
+
+// ApplyReplace creates a plan which forces the resource instances with the
+// given addresses to be replaced, as described for PlanOptions.Replace, and
+// applies it. This is equivalent to "terraform apply -replace", except that
+// the plan also passes through any plan gates and confirmation callback. The
+// plan replaces any existing saved plan, and is removed after the apply.
+//
+// This allows testing replacement on Terraform versions where Taint is
+// deprecated.
+func (wd *WorkingDir) ApplyReplace(addresses ...string) error {
+	if len(addresses) == 0 {
+		return fmt.Errorf("no addresses to replace")
+	}
+	if err := wd.CreatePlanWithOptions(PlanOptions{Replace: addresses}); err != nil {
+		return err
+	}
+	defer wd.ClearPlan()
+	return wd.ApplySavedPlan()
+}
+
+// RequireApplyReplace is a variant of ApplyReplace that will fail the test via
+// the given TestControl if the plan or apply fails.
+func (wd *WorkingDir) RequireApplyReplace(t TestControl, addresses ...string) {
+	t.Helper()
+	if err := wd.ApplyReplace(addresses...); err != nil {
+		t := testingT{t}
+		t.Fatalf("failed to apply with replacement: %s", err)
+	}
+}
+
+// isSavedPlanPath returns true if the given path, which may be relative to
+// the working directory, is that of the saved plan.
+func (wd *WorkingDir) isSavedPlanPath(path string) bool {
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(wd.baseDir, path)
+	}
+	return filepath.Clean(path) == wd.planFilename()
+}
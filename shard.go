@@ -0,0 +1,76 @@
+package tftest
+
+import (
+	"fmt"
+	"hash/fnv"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// ShardSkip is a test guard that will produce a log and call SkipNow on the
+// given TestControl unless the test belongs to the shard with the given
+// zero-based index, out of totalShards shards. This allows a long acceptance
+// suite to be split across several CI jobs, each running the same test
+// program with a different shard index, without maintaining lists of tests.
+//
+// Each test is assigned to a shard by a hash of its name, so the assignment
+// is deterministic and stays the same when other tests are added or removed.
+// Subtests are assigned by the name of their top-level test, so that they
+// always run in the same shard as it. The TestControl must have a Name
+// method, as *testing.T does.
+//
+// ShardSkip does nothing if totalShards is less than 2. It panics if
+// shardIndex is out of range, since that would silently skip every test.
+func ShardSkip(t TestControl, totalShards, shardIndex int) {
+	t.Helper()
+	if totalShards < 2 {
+		return
+	}
+	if shardIndex < 0 || shardIndex >= totalShards {
+		panic(fmt.Sprintf("shard index %d out of range for %d shards", shardIndex, totalShards))
+	}
+
+	if shard := testShard(testName(t), totalShards); shard != shardIndex {
+		tt := testingT{t}
+		tt.Logf("skipping test in shard %d of %d, because this run is shard %d", shard, totalShards, shardIndex)
+		tt.SkipNow()
+	}
+}
+
+// Shard is a variant of ShardSkip that reads the number of shards and the
+// index of the current shard from the environment variables
+// TF_ACC_SHARD_TOTAL and TF_ACC_SHARD_INDEX. It does nothing if
+// TF_ACC_SHARD_TOTAL isn't set, and fails the test if either variable is
+// invalid.
+func Shard(t TestControl) {
+	t.Helper()
+	totalStr := os.Getenv("TF_ACC_SHARD_TOTAL")
+	if totalStr == "" {
+		return
+	}
+	total, err := strconv.Atoi(totalStr)
+	if err != nil || total < 1 {
+		t := testingT{t}
+		t.Fatalf("invalid TF_ACC_SHARD_TOTAL %q: must be a positive integer", totalStr)
+		return
+	}
+	indexStr := os.Getenv("TF_ACC_SHARD_INDEX")
+	index, err := strconv.Atoi(indexStr)
+	if err != nil || index < 0 || index >= total {
+		t := testingT{t}
+		t.Fatalf("invalid TF_ACC_SHARD_INDEX %q: must be an integer from 0 to %d", indexStr, total-1)
+		return
+	}
+	ShardSkip(t, total, index)
+}
+
+// testShard returns the shard that the test with the given name belongs to.
+func testShard(name string, totalShards int) int {
+	if i := strings.Index(name, "/"); i >= 0 {
+		name = name[:i]
+	}
+	h := fnv.New32a()
+	h.Write([]byte(name))
+	return int(h.Sum32() % uint32(totalShards))
+}
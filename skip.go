@@ -0,0 +1,108 @@
+package tftest
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+)
+
+// SkipReason is a machine-readable category explaining why a test was
+// skipped, for use with Helper.Skip.
+type SkipReason string
+
+const (
+	// SkipMissingCredentials indicates that credentials needed to reach a
+	// remote system were not available.
+	SkipMissingCredentials SkipReason = "missing_credentials"
+
+	// SkipUnsupportedVersion indicates that the Terraform CLI version under
+	// test does not support the functionality the test covers.
+	SkipUnsupportedVersion SkipReason = "unsupported_version"
+
+	// SkipFeatureDisabled indicates that the test covers functionality
+	// that is currently switched off by a feature flag.
+	SkipFeatureDisabled SkipReason = "feature_disabled"
+)
+
+// SkippedTest describes a single call to Helper.Skip.
+type SkippedTest struct {
+	Test   string     `json:"test"`
+	Reason SkipReason `json:"reason"`
+	Detail string     `json:"detail,omitempty"`
+}
+
+// Skip logs the given reason and detail and calls SkipNow on the given
+// TestControl, like a test guard, and also records the skip so that Close can
+// report on all of the tests that were skipped during the run and why.
+//
+// The test name is included in the report if the TestControl has a Name
+// method, as *testing.T does.
+func (h *Helper) Skip(t TestControl, reason SkipReason, detail string) {
+	t.Helper()
+
+	skip := SkippedTest{
+		Test:   testName(t),
+		Reason: reason,
+		Detail: detail,
+	}
+
+	h.skipsMu.Lock()
+	h.skips = append(h.skips, skip)
+	h.skipsMu.Unlock()
+
+	tt := testingT{t}
+	if detail != "" {
+		tt.Skipf("skipping test (%s): %s", reason, detail)
+	} else {
+		tt.Skipf("skipping test (%s)", reason)
+	}
+}
+
+// SkippedTests returns the tests skipped so far using Skip, ordered by test
+// name.
+func (h *Helper) SkippedTests() []SkippedTest {
+	h.skipsMu.Lock()
+	defer h.skipsMu.Unlock()
+
+	ret := make([]SkippedTest, len(h.skips))
+	copy(ret, h.skips)
+	sort.SliceStable(ret, func(i, j int) bool {
+		return ret[i].Test < ret[j].Test
+	})
+	return ret
+}
+
+// writeSkipReport writes a human-readable summary of the skipped tests to w,
+// and also writes them as JSON to the file named in TF_ACC_SKIP_REPORT_PATH,
+// if set.
+func (h *Helper) writeSkipReport(w io.Writer) error {
+	skips := h.SkippedTests()
+	if len(skips) == 0 {
+		return nil
+	}
+
+	fmt.Fprintf(w, "%d tests were skipped:\n", len(skips))
+	for _, skip := range skips {
+		if skip.Detail != "" {
+			fmt.Fprintf(w, "  %s (%s): %s\n", skip.Test, skip.Reason, skip.Detail)
+		} else {
+			fmt.Fprintf(w, "  %s (%s)\n", skip.Test, skip.Reason)
+		}
+	}
+
+	if p := os.Getenv("TF_ACC_SKIP_REPORT_PATH"); p != "" {
+		src, err := json.MarshalIndent(skips, "", "  ")
+		if err != nil {
+			return err
+		}
+		err = ioutil.WriteFile(p, src, 0644)
+		if err != nil {
+			return fmt.Errorf("failed to write skip report: %w", err)
+		}
+		emitEvent(Event{Type: EventArtifactWritten, Path: p})
+	}
+	return nil
+}
@@ -0,0 +1,68 @@
+package tftest
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// DestroyStacks tears down a set of working directories that depend on each
+// other, such as a network in one directory and the instances using it in
+// another, given in the order they were applied, with each one depending
+// only on those before it.
+//
+// The directories are destroyed in reverse order. After each destroy, every
+// directory before it is planned again, with refresh, and must have no
+// changes. This detects a provider leaving something behind in an upstream
+// stack, or changing it, when destroying the objects that referred to it.
+//
+// Teardown continues after a failed destroy or verification, so that as
+// many remote objects as possible are destroyed, and the returned error
+// describes every failure.
+func DestroyStacks(stacks ...*WorkingDir) error {
+	var problems []string
+	for i := len(stacks) - 1; i >= 0; i-- {
+		if err := stacks[i].Destroy(); err != nil {
+			problems = append(problems, fmt.Sprintf("destroy of stack %d failed, so %s: %s", i, leakWarning, err))
+			continue
+		}
+		for j := 0; j < i; j++ {
+			if err := stacks[j].checkNoChanges(); err != nil {
+				problems = append(problems, fmt.Sprintf("after destroying stack %d, stack %d %s", i, j, err))
+			}
+		}
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("progressive destroy failed:\n  %s", strings.Join(problems, "\n  "))
+	}
+	return nil
+}
+
+// RequireDestroyStacks is a variant of DestroyStacks that will fail the test
+// via the given TestControl if any destroy or verification fails.
+func RequireDestroyStacks(t TestControl, stacks ...*WorkingDir) {
+	t.Helper()
+	if err := DestroyStacks(stacks...); err != nil {
+		t := testingT{t}
+		t.Fatalf("%s", err)
+	}
+}
+
+// checkNoChanges creates a plan, refreshing first, and returns an error
+// unless it has no resource changes. The plan replaces any saved plan.
+func (wd *WorkingDir) checkNoChanges() error {
+	err := wd.runPlan(func() error {
+		return wd.runTerraform(context.Background(), "plan", "-no-color", "-input=false", "-out="+PlanFileName)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to plan: %s", err)
+	}
+	summary, err := wd.planChangeSummary(PlanFileName)
+	if err != nil {
+		return err
+	}
+	if summary.Total() > 0 {
+		return fmt.Errorf("has %d planned changes: %s", summary.Total(), strings.Join(summary.Addresses, ", "))
+	}
+	return nil
+}
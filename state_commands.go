@@ -0,0 +1,117 @@
+package tftest
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// StateMv runs "terraform state mv" to move the object at the source address
+// in the state to the destination address, as when a resource is renamed or
+// moved into a module. This allows a test to simulate such a refactor and
+// then check that the next plan has no changes for the moved object.
+func (wd *WorkingDir) StateMv(src, dst string) error {
+	return wd.run("state mv", func() error {
+		return wd.runTerraform(context.Background(), "state", "mv", "-no-color", src, dst)
+	})
+}
+
+// RequireStateMv is a variant of StateMv that will fail the test via the
+// given TestControl if the object cannot be moved.
+func (wd *WorkingDir) RequireStateMv(t TestControl, src, dst string) {
+	t.Helper()
+	if err := wd.StateMv(src, dst); err != nil {
+		t := testingT{t}
+		t.Fatalf("failed to move %s to %s in state: %s", src, dst, err)
+	}
+}
+
+// StateRm runs "terraform state rm" to remove the objects at the given
+// addresses from the state, without destroying them. This allows a test to
+// simulate objects that Terraform has lost track of, for example to check
+// that they can be imported again.
+//
+// The removed objects still exist, so the test is responsible for
+// destroying them by other means.
+func (wd *WorkingDir) StateRm(addresses ...string) error {
+	if len(addresses) == 0 {
+		return fmt.Errorf("no addresses to remove from state")
+	}
+	args := append([]string{"state", "rm", "-no-color"}, addresses...)
+	return wd.run("state rm", func() error {
+		return wd.runTerraform(context.Background(), args...)
+	})
+}
+
+// RequireStateRm is a variant of StateRm that will fail the test via the
+// given TestControl if the objects cannot be removed.
+func (wd *WorkingDir) RequireStateRm(t TestControl, addresses ...string) {
+	t.Helper()
+	if err := wd.StateRm(addresses...); err != nil {
+		t := testingT{t}
+		t.Fatalf("failed to remove from state: %s", err)
+	}
+}
+
+// StatePull runs "terraform state pull" and returns the raw state in the
+// format of the state file, which unlike the representation returned by
+// RawState includes details such as each instance's schema version.
+func (wd *WorkingDir) StatePull() ([]byte, error) {
+	out, err := wd.runStdout("state pull", func() error {
+		return wd.runTerraform(context.Background(), "state", "pull")
+	})
+	if err != nil {
+		return nil, err
+	}
+	return []byte(out), nil
+}
+
+// RequireStatePull is a variant of StatePull that will fail the test via the
+// given TestControl if the state cannot be read.
+func (wd *WorkingDir) RequireStatePull(t TestControl) []byte {
+	t.Helper()
+	ret, err := wd.StatePull()
+	if err != nil {
+		t := testingT{t}
+		t.Fatalf("failed to pull state: %s", err)
+	}
+	return ret
+}
+
+// StatePush runs "terraform state push" to replace the current state with the
+// given state file content, such as a hand-crafted state or one created by an
+// older version of the provider, so that the next command runs the provider's
+// state upgrade logic against it.
+//
+// The state is pushed with -force, so that it replaces the current state even
+// if its lineage differs or its serial is lower.
+func (wd *WorkingDir) StatePush(state []byte) error {
+	f, err := ioutil.TempFile(wd.baseDir, ".push-*.tfstate")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	_, err = f.Write(state)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write state to push: %s", err)
+	}
+
+	return wd.run("state push", func() error {
+		return wd.runTerraform(context.Background(), "state", "push", "-force", filepath.Base(f.Name()))
+	})
+}
+
+// RequireStatePush is a variant of StatePush that will fail the test via the
+// given TestControl if the state cannot be pushed.
+func (wd *WorkingDir) RequireStatePush(t TestControl, state []byte) {
+	t.Helper()
+	if err := wd.StatePush(state); err != nil {
+		t := testingT{t}
+		t.Fatalf("failed to push state: %s", err)
+	}
+}
@@ -0,0 +1,265 @@
+package tftest
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+
+	tfjson "github.com/hashicorp/terraform-json"
+)
+
+// StateUpgrade describes how to rewrite the state of a single resource
+// instance so that it appears to have been created by an older version of
+// the provider, for testing the provider's state upgrade logic.
+type StateUpgrade struct {
+	// Address is the absolute address of the resource instance, such as
+	// "null_resource.test" or "module.foo.aws_instance.bar[0]".
+	Address string
+
+	// SchemaVersion is the older schema version to record for the instance.
+	SchemaVersion uint64
+
+	// Transform rewrites the instance's attribute values, as decoded from the
+	// state file, into the shape the older schema version used. Numbers are
+	// decoded as json.Number, so that large integers such as numeric IDs
+	// are written back exactly. It may be nil if only the schema version
+	// needs to change.
+	Transform func(attributes map[string]interface{}) error
+}
+
+// DowngradeState rewrites the local state file in the working directory
+// according to the given upgrades, so that the next Terraform command will
+// ask the provider to upgrade the affected resource instances from the older
+// schema versions.
+func (wd *WorkingDir) DowngradeState(upgrades ...StateUpgrade) error {
+	src, err := ioutil.ReadFile(wd.stateFilename())
+	if err != nil {
+		return fmt.Errorf("failed to read state: %s", err)
+	}
+	var state map[string]interface{}
+	dec := json.NewDecoder(bytes.NewReader(src))
+	dec.UseNumber()
+	err = dec.Decode(&state)
+	if err != nil {
+		return fmt.Errorf("failed to decode state: %s", err)
+	}
+
+	for _, upgrade := range upgrades {
+		instance, err := findStateFileInstance(state, upgrade.Address)
+		if err != nil {
+			return err
+		}
+		instance["schema_version"] = upgrade.SchemaVersion
+		if upgrade.Transform != nil {
+			attrs, _ := instance["attributes"].(map[string]interface{})
+			if attrs == nil {
+				return fmt.Errorf("%s has no attributes in state", upgrade.Address)
+			}
+			err := upgrade.Transform(attrs)
+			if err != nil {
+				return fmt.Errorf("failed to transform %s: %s", upgrade.Address, err)
+			}
+			instance["attributes"] = attrs
+		}
+	}
+
+	if serial, ok := state["serial"].(json.Number); ok {
+		n, err := strconv.ParseUint(string(serial), 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid state serial %s", serial)
+		}
+		state["serial"] = json.Number(strconv.FormatUint(n+1, 10))
+	}
+	src, err = json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(wd.stateFilename(), src, 0644)
+}
+
+// RequireDowngradeState is a variant of DowngradeState that will fail the test
+// via the given TestControl if the state cannot be rewritten.
+func (wd *WorkingDir) RequireDowngradeState(t TestControl, upgrades ...StateUpgrade) {
+	t.Helper()
+	if err := wd.DowngradeState(upgrades...); err != nil {
+		t := testingT{t}
+		t.Fatalf("failed to downgrade state: %s", err)
+	}
+}
+
+// CheckStateUpgrade checks that the provider correctly upgrades state written
+// by older versions of itself. The working directory must already have its
+// configuration set and be initialized.
+//
+// It applies the configuration with the current provider, records the
+// attributes of each instance named in the given upgrades, rewrites the
+// resulting state as described by those upgrades, and then creates a plan,
+// refreshing first so that the provider reads the upgraded objects,
+// returning an error if the plan proposes any changes. Finally, it refreshes
+// the state and returns an error if any of the instances still has the older
+// schema version, which would indicate that the provider didn't run its
+// upgrade logic at all, or if the upgraded attributes differ from those
+// recorded before the rewrite, as reported by Compare with the given options.
+// The saved plan is removed afterwards.
+func (wd *WorkingDir) CheckStateUpgrade(opts *CompareOptions, upgrades ...StateUpgrade) error {
+	if err := wd.ClearPlan(); err != nil {
+		return err
+	}
+	if err := wd.Apply(); err != nil {
+		return err
+	}
+	before := make(map[string]interface{}, len(upgrades))
+	for _, upgrade := range upgrades {
+		attrs, err := wd.ResourceAttributes(upgrade.Address)
+		if err != nil {
+			return err
+		}
+		before[upgrade.Address] = attrs
+	}
+	if err := wd.DowngradeState(upgrades...); err != nil {
+		return err
+	}
+
+	if err := wd.CreatePlanWithOptions(PlanOptions{Refresh: true}); err != nil {
+		return err
+	}
+	raw, err := wd.savedPlanJSON()
+	if err != nil {
+		return err
+	}
+	plan, err := decodePlan([]byte(raw))
+	if err != nil {
+		return err
+	}
+	var changed []string
+	for _, rc := range plan.ResourceChanges {
+		if rc.Change != nil && !rc.Change.Actions.NoOp() {
+			changed = append(changed, fmt.Sprintf("%s (%s)", rc.Address, describeActions(rc.Change.Actions)))
+		}
+	}
+	if len(changed) > 0 {
+		return fmt.Errorf("plan after state upgrade is not empty:\n  %s", strings.Join(changed, "\n  "))
+	}
+	if err := wd.ClearPlan(); err != nil {
+		return err
+	}
+
+	if err := wd.Refresh(); err != nil {
+		return err
+	}
+	state, err := wd.State()
+	if err != nil {
+		return err
+	}
+	after := make(map[string]interface{}, len(upgrades))
+	for _, upgrade := range upgrades {
+		r := findStateResource(state, upgrade.Address)
+		if r == nil {
+			return fmt.Errorf("after upgrade: no resource instance %s in state", upgrade.Address)
+		}
+		if r.SchemaVersion <= upgrade.SchemaVersion {
+			return fmt.Errorf("%s still has schema version %d after refresh, so its state was not upgraded", upgrade.Address, r.SchemaVersion)
+		}
+		attrs, err := wd.ResourceAttributes(upgrade.Address)
+		if err != nil {
+			return err
+		}
+		after[upgrade.Address] = attrs
+	}
+
+	diffs, err := Compare(before, after, opts)
+	if err != nil {
+		return err
+	}
+	if len(diffs) > 0 {
+		lines := make([]string, len(diffs))
+		for i, d := range diffs {
+			lines[i] = d.String()
+		}
+		return fmt.Errorf("upgraded state differs from the state before the downgrade:\n  %s", strings.Join(lines, "\n  "))
+	}
+	return nil
+}
+
+// RequireCheckStateUpgrade is a variant of CheckStateUpgrade that will fail the test
+// via the given TestControl if the state upgrade doesn't behave as expected.
+func (wd *WorkingDir) RequireCheckStateUpgrade(t TestControl, opts *CompareOptions, upgrades ...StateUpgrade) {
+	t.Helper()
+	if err := wd.CheckStateUpgrade(opts, upgrades...); err != nil {
+		t := testingT{t}
+		t.Fatalf("state upgrade failed: %s", err)
+	}
+}
+
+// findStateResource returns the resource instance with the given absolute
+// address in the state, searching child modules too, or nil if there is no
+// such instance.
+func findStateResource(state *tfjson.State, address string) *tfjson.StateResource {
+	if state == nil || state.Values == nil || state.Values.RootModule == nil {
+		return nil
+	}
+	modules := []*tfjson.StateModule{state.Values.RootModule}
+	for len(modules) > 0 {
+		module := modules[0]
+		modules = append(modules[1:], module.ChildModules...)
+		for _, r := range module.Resources {
+			if r.Address == address {
+				return r
+			}
+		}
+	}
+	return nil
+}
+
+// describeActions returns the plan actions as a comma-separated list.
+func describeActions(actions tfjson.Actions) string {
+	names := make([]string, len(actions))
+	for i, action := range actions {
+		names[i] = string(action)
+	}
+	return strings.Join(names, ", ")
+}
+
+// findStateFileInstance finds the resource instance object with the given
+// address in a decoded version 4 state file.
+func findStateFileInstance(state map[string]interface{}, address string) (map[string]interface{}, error) {
+	resources, _ := state["resources"].([]interface{})
+	for _, r := range resources {
+		resource, _ := r.(map[string]interface{})
+		if resource == nil {
+			continue
+		}
+		prefix := ""
+		if module, _ := resource["module"].(string); module != "" {
+			prefix = module + "."
+		}
+		if mode, _ := resource["mode"].(string); mode == "data" {
+			prefix += "data."
+		}
+		prefix += fmt.Sprintf("%s.%s", resource["type"], resource["name"])
+
+		instances, _ := resource["instances"].([]interface{})
+		for _, i := range instances {
+			instance, _ := i.(map[string]interface{})
+			if instance == nil {
+				continue
+			}
+			addr := prefix
+			switch key := instance["index_key"].(type) {
+			case json.Number:
+				addr += fmt.Sprintf("[%s]", key)
+			case float64:
+				addr += fmt.Sprintf("[%d]", int(key))
+			case string:
+				addr += fmt.Sprintf("[%s]", strconv.Quote(key))
+			}
+			if addr == address {
+				return instance, nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("no resource instance %s in state", address)
+}
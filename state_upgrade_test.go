@@ -0,0 +1,72 @@
+package tftest
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDowngradeStateLargeInteger(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tftest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	wd := &WorkingDir{baseDir: dir}
+	src := `{
+  "version": 4,
+  "serial": 9007199254740993,
+  "resources": [
+    {
+      "mode": "managed",
+      "type": "test_thing",
+      "name": "a",
+      "instances": [
+        {
+          "index_key": 0,
+          "schema_version": 2,
+          "attributes": {"id": 9007199254740993, "count": 9223372036854775807}
+        }
+      ]
+    }
+  ]
+}`
+	err = ioutil.WriteFile(filepath.Join(dir, "terraform.tfstate"), []byte(src), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = wd.DowngradeState(StateUpgrade{
+		Address:       "test_thing.a[0]",
+		SchemaVersion: 1,
+		Transform: func(attrs map[string]interface{}) error {
+			attrs["legacy_id"] = attrs["id"]
+			return nil
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := ioutil.ReadFile(filepath.Join(dir, "terraform.tfstate"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		`"serial": 9007199254740994`,
+		`"schema_version": 1`,
+		`"id": 9007199254740993`,
+		`"legacy_id": 9007199254740993`,
+		`"count": 9223372036854775807`,
+	} {
+		if !strings.Contains(string(got), want) {
+			t.Errorf("rewritten state does not contain %s:\n%s", want, got)
+		}
+	}
+	if !json.Valid(got) {
+		t.Errorf("rewritten state is not valid JSON:\n%s", got)
+	}
+}
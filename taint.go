@@ -0,0 +1,46 @@
+package tftest
+
+import (
+	"context"
+)
+
+// Taint runs "terraform taint" to mark the resource instance with the given
+// address as tainted, so that the next plan or apply replaces it.
+//
+// This allows a test to verify that the provider correctly handles
+// recreating an object, including the ordering of create_before_destroy
+// replacements. Taint returns an error if the instance is not in the state.
+func (wd *WorkingDir) Taint(address string) error {
+	return wd.run("taint", func() error {
+		return wd.runTerraform(context.Background(), "taint", "-no-color", address)
+	})
+}
+
+// RequireTaint is a variant of Taint that will fail the test via the given
+// TestControl if the instance cannot be tainted.
+func (wd *WorkingDir) RequireTaint(t TestControl, address string) {
+	t.Helper()
+	if err := wd.Taint(address); err != nil {
+		t := testingT{t}
+		t.Fatalf("failed to taint %s: %s", address, err)
+	}
+}
+
+// Untaint runs "terraform untaint" to remove the tainted mark from the
+// resource instance with the given address, including one that Terraform
+// tainted itself because its creation failed part way through.
+func (wd *WorkingDir) Untaint(address string) error {
+	return wd.run("untaint", func() error {
+		return wd.runTerraform(context.Background(), "untaint", "-no-color", address)
+	})
+}
+
+// RequireUntaint is a variant of Untaint that will fail the test via the
+// given TestControl if the instance cannot be untainted.
+func (wd *WorkingDir) RequireUntaint(t TestControl, address string) {
+	t.Helper()
+	if err := wd.Untaint(address); err != nil {
+		t := testingT{t}
+		t.Fatalf("failed to untaint %s: %s", address, err)
+	}
+}
@@ -0,0 +1,43 @@
+package tftest
+
+// PlanOption is an option for CreatePlan, such as Target.
+type PlanOption interface {
+	configurePlan(opts *PlanOptions)
+}
+
+// ApplyOption is an option for Apply, such as Target.
+type ApplyOption interface {
+	configureApply(opts *ApplyOptions)
+}
+
+// DestroyOption is an option for Destroy, such as Target.
+type DestroyOption interface {
+	configureDestroy(opts *DestroyOptions)
+}
+
+// TargetOption limits a plan, apply or destroy to a resource address, as
+// returned by Target.
+type TargetOption struct {
+	address string
+}
+
+// Target returns an option for CreatePlan, Apply or Destroy that limits the
+// operation to the resource with the given address, as with "terraform apply
+// -target", so that a configuration with several resources can be applied
+// and destroyed a resource at a time within one test. The option can be
+// given more than once to target several resources.
+func Target(address string) *TargetOption {
+	return &TargetOption{address: address}
+}
+
+func (o *TargetOption) configurePlan(opts *PlanOptions) {
+	opts.Targets = append(opts.Targets, o.address)
+}
+
+func (o *TargetOption) configureApply(opts *ApplyOptions) {
+	opts.Targets = append(opts.Targets, o.address)
+}
+
+func (o *TargetOption) configureDestroy(opts *DestroyOptions) {
+	opts.Targets = append(opts.Targets, o.address)
+}
@@ -0,0 +1,175 @@
+package tftest
+
+import (
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// terraformCacheLockTimeout is how long to wait for another process to
+// finish populating the Terraform CLI cache before assuming it crashed and
+// breaking its lock.
+const terraformCacheLockTimeout = 10 * time.Minute
+
+// terraformCacheDir returns the directory in which downloaded Terraform CLI
+// executables are cached across test runs, or an empty string if caching is
+// disabled.
+func terraformCacheDir() string {
+	if dir := os.Getenv("TF_ACC_TERRAFORM_CACHE_DIR"); dir != "" {
+		if dir == "off" {
+			return ""
+		}
+		return dir
+	}
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "tftest", "terraform")
+}
+
+// InstallTerraformCached is a variant of InstallTerraform which keeps the
+// downloaded executable in a persistent cache directory, keyed by version
+// and platform, and reuses it on subsequent calls instead of downloading
+// again. It is safe to call concurrently from several test processes, such
+// as when "go test" runs the tests of several packages in parallel.
+//
+// The cache directory defaults to tftest/terraform in the user's cache
+// directory, such as $XDG_CACHE_HOME on Linux, and can be overridden using
+// the environment variable TF_ACC_TERRAFORM_CACHE_DIR. Setting that variable
+// to "off" disables the cache, in which case the executable is installed
+// into fallbackDir as for InstallTerraform.
+//
+// The SHA-256 checksum of each executable is recorded alongside it when it is
+// downloaded, and DiscoverConfig considers a cached executable verified, for
+// RequireVerifiedTerraform, only if it still matches.
+//
+// DiscoverConfig uses this when TF_ACC_TERRAFORM_VERSION is set.
+func InstallTerraformCached(version, fallbackDir string) (string, error) {
+	execPath, _, err := installTerraformCached(version, fallbackDir)
+	return execPath, err
+}
+
+// installTerraformCached implements InstallTerraformCached, also returning
+// whether the executable is known to be the verified download: true if it
+// was just downloaded, or if it was found in the cache with the checksum
+// recorded when it was downloaded.
+func installTerraformCached(version, fallbackDir string) (string, bool, error) {
+	cacheDir := terraformCacheDir()
+	if cacheDir == "" {
+		execPath, err := InstallTerraform(version, fallbackDir)
+		return execPath, err == nil, err
+	}
+
+	dir := filepath.Join(cacheDir, version, runtime.GOOS+"_"+runtime.GOARCH)
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return "", false, fmt.Errorf("failed to create Terraform CLI cache directory: %s", err)
+	}
+
+	execName := "terraform"
+	if runtime.GOOS == "windows" {
+		execName += ".exe"
+	}
+	execPath := filepath.Join(dir, execName)
+	if _, err := os.Stat(execPath); err == nil {
+		return execPath, cachedChecksumMatches(execPath), nil
+	}
+
+	unlock, err := lockDir(dir)
+	if err != nil {
+		return "", false, err
+	}
+	defer unlock()
+
+	// Another process may have populated the cache while we waited.
+	if _, err := os.Stat(execPath); err == nil {
+		return execPath, cachedChecksumMatches(execPath), nil
+	}
+
+	tmpDir, err := ioutil.TempDir(dir, "download")
+	if err != nil {
+		return "", false, err
+	}
+	defer os.RemoveAll(tmpDir)
+	downloaded, err := InstallTerraform(version, tmpDir)
+	if err != nil {
+		return "", false, err
+	}
+
+	// The checksum is recorded before the executable appears, so that other
+	// processes can always check what they find.
+	sum, err := fileChecksum(downloaded)
+	if err != nil {
+		return "", false, fmt.Errorf("failed to add Terraform CLI to cache: %s", err)
+	}
+	err = ioutil.WriteFile(execPath+checksumSuffix, []byte(hex.EncodeToString(sum)), 0644)
+	if err != nil {
+		return "", false, fmt.Errorf("failed to add Terraform CLI to cache: %s", err)
+	}
+
+	// Renaming into place means that other processes never see a partially
+	// written executable.
+	err = os.Rename(downloaded, execPath)
+	if err != nil {
+		return "", false, fmt.Errorf("failed to add Terraform CLI to cache: %s", err)
+	}
+	return execPath, true, nil
+}
+
+// checksumSuffix is appended to the path of a cached executable to give the
+// path of the file recording its SHA-256 checksum, in hex, as downloaded.
+const checksumSuffix = ".sha256"
+
+// cachedChecksumMatches returns whether the cached executable at the given
+// path still has the checksum recorded when it was downloaded and verified.
+func cachedChecksumMatches(execPath string) bool {
+	want, err := ioutil.ReadFile(execPath + checksumSuffix)
+	if err != nil {
+		return false
+	}
+	got, err := fileChecksum(execPath)
+	if err != nil {
+		return false
+	}
+	return strings.TrimSpace(string(want)) == hex.EncodeToString(got)
+}
+
+// lockDir takes an exclusive lock on the given directory, shared between
+// processes, waiting for any other holder to release it. It returns a
+// function that releases the lock.
+func lockDir(dir string) (func(), error) {
+	return lockFile(filepath.Join(dir, ".lock"), currentClock(), terraformCacheLockTimeout)
+}
+
+// lockFile takes an exclusive lock represented by the file at the given
+// path, as described for lockDir, measuring time with the given clock. A
+// lock file older than staleTimeout is assumed to belong to a holder that
+// crashed, and is broken.
+//
+// The lock is a file created exclusively, rather than an OS-level file lock,
+// so that it works the same on all platforms.
+func lockFile(lockPath string, c Clock, staleTimeout time.Duration) (func(), error) {
+	for {
+		f, err := os.OpenFile(lockPath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+		if err == nil {
+			f.Close()
+			return func() { os.Remove(lockPath) }, nil
+		}
+		if !os.IsExist(err) {
+			return nil, fmt.Errorf("failed to create lock file %s: %s", lockPath, err)
+		}
+
+		if info, err := os.Stat(lockPath); err == nil && c.Now().Sub(info.ModTime()) > staleTimeout {
+			// The holder has presumably crashed.
+			os.Remove(lockPath)
+			continue
+		}
+		c.Sleep(500 * time.Millisecond)
+	}
+}
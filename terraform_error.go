@@ -0,0 +1,78 @@
+package tftest
+
+import (
+	"errors"
+	"os/exec"
+)
+
+// TerraformError is the error returned by the WorkingDir methods when a
+// Terraform CLI command fails, which can be retrieved from the returned error
+// using errors.As.
+//
+// Errors detected by the helper before running a command, such as a
+// missing configuration or a command forbidden in plan-only mode, are not
+// TerraformErrors.
+type TerraformError struct {
+	// Subcommand is the Terraform subcommand that failed, such as "apply".
+	Subcommand string
+
+	// Args are the complete command line arguments passed to Terraform,
+	// starting with the subcommand. They are not known for the commands
+	// run via terraform-exec, for which Args is nil.
+	Args []string
+
+	// ExitCode is the exit status of the Terraform process, or -1 if it did
+	// not exit normally, for example because it was killed, or if the
+	// command failed for some other reason such as invalid output. For a
+	// command run under a command wrapper, it is the wrapper's exit status.
+	ExitCode int
+
+	// Stdout and Stderr are everything the command wrote to its standard
+	// output and standard error streams, truncated to the output limit.
+	Stdout string
+	Stderr string
+
+	// Diagnostics are the errors and warnings Terraform reported, as far as
+	// they could be determined from its output.
+	Diagnostics []Diagnostic
+
+	// Err is the underlying error.
+	Err error
+}
+
+func (e *TerraformError) Error() string {
+	return "terraform " + e.Subcommand + ": " + e.Err.Error()
+}
+
+func (e *TerraformError) Unwrap() error {
+	return e.Err
+}
+
+// newTerraformError returns err, which was returned by the Terraform command
+// with the given name and its output, as a *TerraformError.
+func newTerraformError(name string, err error, stdout, stderr string) error {
+	var tfErr *TerraformError
+	if errors.As(err, &tfErr) {
+		// already described by runTerraform, which doesn't see stdout
+		tfErr.Stdout = stdout
+		return err
+	}
+
+	return &TerraformError{
+		Subcommand: name,
+		ExitCode:   exitCode(err),
+		Stdout:     stdout,
+		Stderr:     stderr,
+		Err:        err,
+	}
+}
+
+// exitCode returns the exit status of the process that produced the given
+// error, or -1 if it is not known.
+func exitCode(err error) int {
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.ExitCode()
+	}
+	return -1
+}
@@ -0,0 +1,89 @@
+package tftest
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"time"
+)
+
+// logTailInterval is how often the log of a running command is checked for
+// new lines to pass to the Terraform logger.
+const logTailInterval = 100 * time.Millisecond
+
+// SetTerraformLogger makes the working directory enable Terraform's log for
+// each command and pass each line of it to the given function as Terraform
+// writes it, prefixed with the name of the subcommand. Pass nil to stop.
+//
+// This is intended for sending the log to the test log, so that it appears
+// alongside the test that produced it, which FeatureLogToTest arranges
+// automatically when tests are run with "go test -v".
+//
+// Setting TF_ACC_LOG_PATH overrides the logger, so that Terraform's log is
+// written only to that file.
+func (wd *WorkingDir) SetTerraformLogger(logger func(line string)) {
+	wd.tfLogger = logger
+}
+
+// terraformLogger returns the logger set with SetTerraformLogger, unless it
+// is overridden by a configured log path.
+func (wd *WorkingDir) terraformLogger() func(string) {
+	if wd.configuredLogPath() != "" {
+		return nil
+	}
+	return wd.tfLogger
+}
+
+// tailLog passes each line written to the file at the given path to emit,
+// until the returned function is called, which emits any remaining lines
+// and returns once they have all been emitted.
+func tailLog(path string, emit func(line string)) func() {
+	stop := make(chan struct{})
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+		var offset int64
+		var partial []byte
+		read := func() {
+			f, err := os.Open(path)
+			if err != nil {
+				return
+			}
+			defer f.Close()
+			if _, err := f.Seek(offset, io.SeekStart); err != nil {
+				return
+			}
+			var buf bytes.Buffer
+			n, _ := io.Copy(&buf, f)
+			offset += n
+			partial = append(partial, buf.Bytes()...)
+			for {
+				i := bytes.IndexByte(partial, '\n')
+				if i < 0 {
+					break
+				}
+				emit(string(bytes.TrimRight(partial[:i], "\r")))
+				partial = partial[i+1:]
+			}
+		}
+
+		for {
+			select {
+			case <-stop:
+				read()
+				if len(partial) > 0 {
+					emit(string(partial))
+				}
+				return
+			case <-currentClock().After(logTailInterval):
+				read()
+			}
+		}
+	}()
+
+	return func() {
+		close(stop)
+		<-done
+	}
+}
@@ -0,0 +1,103 @@
+package tftest
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// throttle is a simple rate limiter shared by all of the working directories
+// that use a particular named bucket.
+type throttle struct {
+	mu       sync.Mutex
+	interval time.Duration
+	burst    int
+
+	// next is the time at which the bucket will next be completely empty,
+	// assuming no further reservations.
+	next time.Time
+}
+
+// reserve claims capacity for one command and returns how long the caller
+// must wait before running it.
+func (b *throttle) reserve(now time.Time) time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.next.Before(now) {
+		b.next = now
+	}
+	wait := b.next.Sub(now) - time.Duration(b.burst-1)*b.interval
+	b.next = b.next.Add(b.interval)
+	if wait < 0 {
+		return 0
+	}
+	return wait
+}
+
+// AddThrottle defines a named throttle bucket, which working directories can
+// opt in to using with WorkingDir.UseThrottle in order to keep large parallel
+// test suites within the API quotas of a remote system.
+//
+// Commands using the bucket may start in a burst of up to the given size,
+// after which the bucket admits one further command for each interval that
+// passes. Defining a bucket that already exists replaces its settings.
+func (h *Helper) AddThrottle(bucket string, interval time.Duration, burst int) {
+	if burst < 1 {
+		burst = 1
+	}
+
+	h.throttlesMu.Lock()
+	defer h.throttlesMu.Unlock()
+	if h.throttles == nil {
+		h.throttles = map[string]*throttle{}
+	}
+	h.throttles[bucket] = &throttle{
+		interval: interval,
+		burst:    burst,
+	}
+}
+
+func (h *Helper) throttle(bucket string) *throttle {
+	h.throttlesMu.Lock()
+	defer h.throttlesMu.Unlock()
+	return h.throttles[bucket]
+}
+
+// UseThrottle makes the given Terraform subcommands, such as "apply", wait
+// for capacity in the named throttle bucket before running in this working
+// directory. If no subcommands are given, "apply" and "destroy" are
+// throttled, since those are the commands that typically create and delete
+// remote objects.
+//
+// The bucket must have been defined on the working directory's Helper using
+// AddThrottle before any throttled command runs.
+func (wd *WorkingDir) UseThrottle(bucket string, subcommands ...string) {
+	if len(subcommands) == 0 {
+		subcommands = []string{"apply", "destroy"}
+	}
+
+	wd.AddPreCommandHook(func(cmd Command) error {
+		for _, name := range subcommands {
+			if cmd.Name != name {
+				continue
+			}
+
+			b := wd.h.throttle(bucket)
+			if b == nil {
+				return fmt.Errorf("no throttle bucket named %q", bucket)
+			}
+			wait := b.reserve(now())
+			currentClock().Sleep(wait)
+			wd.throttleWait += wait
+			return nil
+		}
+		return nil
+	})
+}
+
+// ThrottleWait returns the total time that commands in this working directory
+// have spent waiting for capacity in throttle buckets.
+func (wd *WorkingDir) ThrottleWait() time.Duration {
+	return wd.throttleWait
+}
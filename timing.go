@@ -0,0 +1,44 @@
+package tftest
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// CompletesWithin calls f, which should run one or more commands in the
+// working directory, and returns an error if f returns an error or takes
+// longer than the given duration overall. This can be used to guard against
+// performance regressions, such as a plan of a large configuration becoming
+// unacceptably slow.
+//
+// The error for a slow call includes the time taken by each of the commands
+// that f ran.
+func (wd *WorkingDir) CompletesWithin(d time.Duration, f func() error) error {
+	first := len(wd.history)
+	start := now()
+	err := f()
+	elapsed := since(start)
+	if err != nil {
+		return err
+	}
+	if elapsed <= d {
+		return nil
+	}
+
+	var breakdown strings.Builder
+	for _, cmd := range wd.history[first:] {
+		fmt.Fprintf(&breakdown, "\n  terraform %s: %s", cmd.Name, cmd.Duration)
+	}
+	return fmt.Errorf("took %s, exceeding the limit of %s%s", elapsed, d, breakdown.String())
+}
+
+// RequireCompletesWithin is a variant of CompletesWithin that will fail the
+// test via the given TestControl if f fails or is too slow.
+func (wd *WorkingDir) RequireCompletesWithin(t TestControl, d time.Duration, f func() error) {
+	t.Helper()
+	if err := wd.CompletesWithin(d, f); err != nil {
+		t := testingT{t}
+		t.Fatalf("%s", err)
+	}
+}
@@ -0,0 +1,141 @@
+package tftest
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// UIMessage is a single message from Terraform's machine-readable UI, which
+// it produces when running plan or apply with the -json option. This is
+// available in Terraform v0.15.3 and later.
+type UIMessage struct {
+	Level   string `json:"@level"`
+	Message string `json:"@message"`
+
+	// Type identifies the kind of message, such as "apply_start" or
+	// "ephemeral_op_complete".
+	Type string `json:"type"`
+
+	// Hook carries the details of lifecycle event messages, such as the
+	// address of the resource and the action taken.
+	Hook map[string]interface{} `json:"hook,omitempty"`
+
+	// Diagnostic is the error or warning carried by messages of type
+	// "diagnostic".
+	Diagnostic *Diagnostic `json:"diagnostic,omitempty"`
+
+	// Raw is the complete message, including any properties not otherwise
+	// represented in this struct.
+	Raw json.RawMessage `json:"-"`
+}
+
+// CreatePlanJSON is a variant of CreatePlan which runs Terraform with its
+// machine-readable UI enabled, returning the messages it produced.
+func (wd *WorkingDir) CreatePlanJSON() ([]UIMessage, error) {
+	msgs, err := wd.runUIJSON("plan", "plan", "-json", "-input=false", "-refresh=false", "-out="+PlanFileName)
+	if err == nil {
+		wd.planApplied = false
+	}
+	return msgs, err
+}
+
+// ApplyJSON is a variant of Apply which runs Terraform with its
+// machine-readable UI enabled, returning the messages it produced. This
+// allows asserting on events that do not leave any trace in the plan or
+// state, such as the opening and closing of ephemeral resources.
+func (wd *WorkingDir) ApplyJSON() ([]UIMessage, error) {
+	implicit, err := wd.planForConfirmation()
+	if err != nil {
+		return nil, err
+	}
+	if implicit {
+		defer wd.ClearPlan()
+	}
+	args := []string{"apply", "-json", "-auto-approve", "-input=false", "-refresh=false"}
+	saved := wd.HasSavedPlan()
+	if saved {
+		if err := wd.checkPlanNotApplied(); err != nil {
+			return nil, err
+		}
+		if err := wd.beforeApply(PlanFileName); err != nil {
+			return nil, err
+		}
+		args = append(args, PlanFileName)
+	}
+	msgs, err := wd.runUIJSON("apply", args...)
+	if err == nil && saved {
+		wd.markPlanApplied()
+	}
+	return msgs, err
+}
+
+// RequireApplyJSON is a variant of ApplyJSON that will fail the test via
+// the given TestControl if the apply operation fails.
+func (wd *WorkingDir) RequireApplyJSON(t TestControl) []UIMessage {
+	t.Helper()
+	ret, err := wd.ApplyJSON()
+	if err != nil {
+		t := testingT{t}
+		t.Fatalf("failed to apply: %s", err)
+	}
+	return ret
+}
+
+// EphemeralResourceEvents returns only the messages describing lifecycle
+// events of ephemeral resources, such as opening, renewing, and closing them.
+// Ephemeral resources are available in Terraform v1.10 and later.
+func EphemeralResourceEvents(msgs []UIMessage) []UIMessage {
+	var ret []UIMessage
+	for _, msg := range msgs {
+		if strings.HasPrefix(msg.Type, "ephemeral_op_") {
+			ret = append(ret, msg)
+		}
+	}
+	return ret
+}
+
+// runUIJSON runs a Terraform subcommand which produces machine-readable UI
+// output, and decodes the messages it produced. If the command fails, the
+// messages that were produced before it failed are returned along with the
+// error.
+func (wd *WorkingDir) runUIJSON(name string, args ...string) ([]UIMessage, error) {
+	stdout, err := wd.runStdout(name, func() error {
+		return wd.runTerraform(context.Background(), args...)
+	})
+
+	msgs, decodeErr := decodeUIMessages(stdout)
+	if err != nil {
+		var tfErr *TerraformError
+		if diags := UIDiagnostics(msgs); len(diags) > 0 && errors.As(err, &tfErr) {
+			tfErr.Diagnostics = diags
+		}
+		return msgs, err
+	}
+	return msgs, decodeErr
+}
+
+func decodeUIMessages(stdout string) ([]UIMessage, error) {
+	var ret []UIMessage
+
+	sc := bufio.NewScanner(strings.NewReader(stdout))
+	sc.Buffer(nil, 16*1024*1024)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" {
+			continue
+		}
+
+		var msg UIMessage
+		err := json.Unmarshal([]byte(line), &msg)
+		if err != nil {
+			return ret, fmt.Errorf("invalid machine-readable UI message %q: %w", line, err)
+		}
+		msg.Raw = json.RawMessage(line)
+		ret = append(ret, msg)
+	}
+	return ret, sc.Err()
+}
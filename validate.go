@@ -0,0 +1,72 @@
+package tftest
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// Validate runs "terraform validate -json" in the working directory, and
+// returns the diagnostics it reported, including the errors returned by the
+// providers' own validation of the configuration. This allows testing
+// schema-level validation without planning.
+//
+// An invalid configuration is not an error. Validate returns an error only if
+// the validation couldn't be run, for example because Init has not been run
+// to install the providers.
+func (wd *WorkingDir) Validate() ([]Diagnostic, error) {
+	if wd.h.LegacyMode() {
+		return wd.legacyValidate()
+	}
+	out, err := wd.runStdout("validate", func() error {
+		err := wd.runTerraform(context.Background(), "validate", "-json", "-no-color")
+		var tfErr *TerraformError
+		if errors.As(err, &tfErr) && tfErr.ExitCode == 1 {
+			// The configuration is invalid, as described by the
+			// diagnostics in the output.
+			return nil
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var result struct {
+		Diagnostics []Diagnostic `json:"diagnostics"`
+	}
+	err = json.Unmarshal([]byte(out), &result)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode validation result: %s", err)
+	}
+	return result.Diagnostics, nil
+}
+
+// legacyValidate implements Validate in legacy mode, where validate has no
+// JSON output, by parsing the diagnostics from its human-readable output.
+func (wd *WorkingDir) legacyValidate() ([]Diagnostic, error) {
+	var diags []Diagnostic
+	_, err := wd.runStdout("validate", func() error {
+		err := wd.runTerraform(context.Background(), "validate", "-no-color")
+		var tfErr *TerraformError
+		if errors.As(err, &tfErr) && tfErr.ExitCode == 1 {
+			diags = parseDiagnostics(tfErr.Stderr)
+			return nil
+		}
+		return err
+	})
+	return diags, err
+}
+
+// RequireValidate is a variant of Validate that will fail the test via the
+// given TestControl if the validation cannot be run.
+func (wd *WorkingDir) RequireValidate(t TestControl) []Diagnostic {
+	t.Helper()
+	ret, err := wd.Validate()
+	if err != nil {
+		t := testingT{t}
+		t.Fatalf("failed to validate: %s", err)
+	}
+	return ret
+}
@@ -0,0 +1,79 @@
+package tftest
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// VariablesFileName is the name of the file in which a working directory
+// passes the values set with SetVariable to Terraform.
+//
+// Terraform loads files with this suffix automatically for every command
+// that evaluates the configuration, and unlike with the -var option it
+// reports values for variables the configuration doesn't declare only as
+// warnings, so the same values can be used with many configurations.
+const VariablesFileName = "terraform_plugin_test.auto.tfvars.json"
+
+// SetVariable sets the value of a root module input variable for all
+// subsequent commands in the working directory. The value can be anything
+// that can be encoded as JSON in a way that Terraform can convert to the
+// variable's type.
+func (wd *WorkingDir) SetVariable(name string, value interface{}) {
+	if wd.variables == nil {
+		wd.variables = map[string]interface{}{}
+	}
+	wd.variables[name] = value
+}
+
+// SetVariable sets the value of a root module input variable for every
+// command in every working directory created by the helper, such as a
+// region or project ID shared by all of the tests in a package. This is a
+// way to set values once in TestMain instead of using TF_VAR_ environment
+// variables, which terraform-exec does not allow a working directory to set.
+//
+// A value set with WorkingDir.SetVariable takes precedence over a value set
+// for the same variable on the helper.
+func (h *Helper) SetVariable(name string, value interface{}) {
+	h.variablesMu.Lock()
+	defer h.variablesMu.Unlock()
+	if h.variables == nil {
+		h.variables = map[string]interface{}{}
+	}
+	h.variables[name] = value
+}
+
+// UnsetVariable removes a variable value previously set with SetVariable.
+func (wd *WorkingDir) UnsetVariable(name string) {
+	delete(wd.variables, name)
+}
+
+// writeVariables writes the current variable values to the variables file,
+// or removes the file if there are none.
+func (wd *WorkingDir) writeVariables() error {
+	variables := map[string]interface{}{}
+	wd.h.variablesMu.Lock()
+	for k, v := range wd.h.variables {
+		variables[k] = v
+	}
+	wd.h.variablesMu.Unlock()
+	for k, v := range wd.variables {
+		variables[k] = v
+	}
+
+	filename := filepath.Join(wd.baseDir, VariablesFileName)
+	if len(variables) == 0 {
+		err := os.Remove(filename)
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	src, err := json.MarshalIndent(variables, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filename, src, 0600)
+}
@@ -0,0 +1,89 @@
+package tftest
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Warnings returns the warning diagnostics reported by the command, as parsed
+// from its human-readable output.
+func (c Command) Warnings() []Diagnostic {
+	var ret []Diagnostic
+	for _, diag := range parseDiagnostics(c.Stdout + "\n" + c.Stderr) {
+		if diag.Severity == "warning" {
+			ret = append(ret, diag)
+		}
+	}
+	return ret
+}
+
+// CreatePlanExpectWarning runs CreatePlan and then checks that Terraform
+// reported a warning with the given summary and, unless detail is empty, a
+// detail containing detail. This ensures that warnings the provider adds,
+// such as for deprecated arguments, actually reach the user through the CLI.
+//
+// It returns an error if the plan fails or if there is no such warning,
+// listing the warnings that were reported.
+func (wd *WorkingDir) CreatePlanExpectWarning(summary, detail string) error {
+	if err := wd.CreatePlan(); err != nil {
+		return err
+	}
+	return wd.checkLastWarning(summary, detail)
+}
+
+// RequireCreatePlanExpectWarning is a variant of CreatePlanExpectWarning that
+// will fail the test via the given TestControl if the plan fails or doesn't
+// report the warning.
+func (wd *WorkingDir) RequireCreatePlanExpectWarning(t TestControl, summary, detail string) {
+	t.Helper()
+	if err := wd.CreatePlanExpectWarning(summary, detail); err != nil {
+		t := testingT{t}
+		t.Fatalf("%s", err)
+	}
+}
+
+// ApplyExpectWarning runs Apply and then checks for a warning as described for
+// CreatePlanExpectWarning. Terraform doesn't repeat the warnings from planning
+// when applying a saved plan, so use it without a saved plan to check for
+// warnings produced during planning.
+func (wd *WorkingDir) ApplyExpectWarning(summary, detail string) error {
+	if err := wd.Apply(); err != nil {
+		return err
+	}
+	return wd.checkLastWarning(summary, detail)
+}
+
+// RequireApplyExpectWarning is a variant of ApplyExpectWarning that will fail
+// the test via the given TestControl if the apply fails or doesn't report the
+// warning.
+func (wd *WorkingDir) RequireApplyExpectWarning(t TestControl, summary, detail string) {
+	t.Helper()
+	if err := wd.ApplyExpectWarning(summary, detail); err != nil {
+		t := testingT{t}
+		t.Fatalf("%s", err)
+	}
+}
+
+// checkLastWarning returns an error unless the last command run reported a
+// matching warning.
+func (wd *WorkingDir) checkLastWarning(summary, detail string) error {
+	if len(wd.history) == 0 {
+		return fmt.Errorf("no command has been run")
+	}
+	cmd := wd.history[len(wd.history)-1]
+	warnings := cmd.Warnings()
+	for _, w := range warnings {
+		if w.Summary == summary && strings.Contains(w.Detail, detail) {
+			return nil
+		}
+	}
+
+	if len(warnings) == 0 {
+		return fmt.Errorf("terraform %s reported no warnings, but expected %q", cmd.Name, summary)
+	}
+	got := make([]string, len(warnings))
+	for i, w := range warnings {
+		got[i] = fmt.Sprintf("%q: %s", w.Summary, w.Detail)
+	}
+	return fmt.Errorf("terraform %s did not report the expected warning %q; it reported:\n  %s", cmd.Name, summary, strings.Join(got, "\n  "))
+}
@@ -1,14 +1,17 @@
 package tftest
 
 import (
-	"bytes"
 	"context"
 	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"strings"
+	"time"
 
+	"github.com/hashicorp/go-version"
 	"github.com/hashicorp/terraform-exec/tfexec"
 	tfjson "github.com/hashicorp/terraform-json"
 )
@@ -25,6 +28,13 @@ const (
 type WorkingDir struct {
 	h *Helper
 
+	// testName is the name of the test that created the working directory,
+	// if known
+	testName string
+
+	// testFailed reports whether that test has failed, if known
+	testFailed func() bool
+
 	// baseDir is the root of the working directory tree
 	baseDir string
 
@@ -42,16 +52,170 @@ type WorkingDir struct {
 	reattachInfo tfexec.ReattachInfo
 
 	env map[string]string
+
+	// variables are the input variable values set with SetVariable
+	variables map[string]interface{}
+
+	// mockEndpoints are stopped when the working directory is closed
+	mockEndpoints []MockEndpoint
+
+	// history records each Terraform command run in the working directory.
+	// preCommandHooks are called before each one starts, and commandHooks
+	// after each one completes.
+	history         []Command
+	preCommandHooks []func(Command) error
+	commandHooks    []func(Command)
+
+	// runStdoutW and runStderrW are where the command currently being run
+	// should write its output, for commands not run via terraform-exec,
+	// and runStdinR is where it reads its input from, if anywhere
+	runStdoutW io.Writer
+	runStderrW io.Writer
+	runStdinR  io.Reader
+
+	// captureProviderOutput enables collecting provider stderr from
+	// Terraform's log into providerOutput. commandLogPath is the log file
+	// for the command currently running, if it differs from the default.
+	captureProviderOutput bool
+	providerOutput        strings.Builder
+	commandLogPath        string
+
+	// diskQuota is the maximum size in bytes of the working directory, or
+	// zero if there is no limit
+	diskQuota int64
+
+	// outputLimit is the maximum number of bytes of each stream of command
+	// output to retain, or zero if there is no limit
+	outputLimit int
+
+	// allowRemoteBackend disables the backend check made by Init
+	allowRemoteBackend bool
+
+	// throttleWait is the total time spent waiting for throttle buckets
+	throttleWait time.Duration
+
+	// jsonDecodeHooks are called with each raw plan or state document
+	jsonDecodeHooks []JSONDecodeHook
+
+	// planGates are called before applying a saved plan
+	planGates []PlanGate
+
+	// workspace is the workspace selected with SetWorkspace, or empty for
+	// the default workspace
+	workspace string
+
+	// planOnly forbids commands which could change remote objects
+	planOnly bool
+
+	// labels are the metadata attached with SetLabel
+	labels map[string]string
+
+	// confirm is called before applies and destroys that would change more
+	// than confirmThreshold resource instances
+	confirm          ConfirmFunc
+	confirmThreshold int
+
+	// commandTimeout is the longest time each command may run, or zero if
+	// there is no limit
+	commandTimeout time.Duration
+
+	// resourceLimits are applied to each Terraform process
+	resourceLimits ResourceLimits
+
+	// commandWrapper is the command line prefixed to each Terraform
+	// command, and wrapperOutput is what the wrapper wrote to its output
+	// file during the command currently running
+	commandWrapper []string
+	wrapperOutput  string
+
+	// providerBinaries are the provider executables installed in the
+	// directory, initially those registered with the helper
+	providerBinaries []ProviderBinary
+
+	// streamStdoutW and streamStderrW receive copies of each command's
+	// output as it runs, if set with SetOutputWriters
+	streamStdoutW io.Writer
+	streamStderrW io.Writer
+
+	// tfLogger receives the lines of Terraform's log, if set with
+	// SetTerraformLogger
+	tfLogger func(line string)
+
+	// retryPolicy controls the retrying of failed commands
+	retryPolicy RetryPolicy
+
+	// templateURLs are the URLs of the template endpoints started for
+	// the working directory, by name
+	templateURLs map[string]string
+
+	// planApplied is set once the current saved plan has been applied
+	planApplied bool
+
+	// initSum is the checksum of the configuration when init last
+	// succeeded, or nil if it hasn't, and autoInit makes commands that need
+	// init run it first
+	initSum  []byte
+	autoInit bool
+
+	// cleanupPolicy controls the handling of cleanup failures, and leaked
+	// is set once the directory is recorded as having leaked objects
+	cleanupPolicy CleanupPolicy
+	leaked        bool
+
+	// fileWatchW receives the changes to the directory's files during
+	// each command, if set with WatchFiles
+	fileWatchW io.Writer
 }
 
 // Close deletes the directories and files created to represent the receiving
-// working directory. After this method is called, the working directory object
-// is invalid and may no longer be used.
+// working directory, and stops any mock endpoints started for it. After this
+// method is called, the working directory object is invalid and may no longer
+// be used.
+//
+// If the helper has an artifact store and the test that created the working
+// directory has failed, Close first saves the directory's artifacts using
+// StoreArtifacts. If FeaturePersistOnFailure is enabled then the directory of
+// a failed test is kept rather than deleted, as is a directory recorded by
+// CleanupRecordLeak.
 func (wd *WorkingDir) Close() error {
-	return os.RemoveAll(wd.baseDir)
+	failed := wd.testFailed != nil && wd.testFailed()
+	if failed && wd.h.artifactStore != nil {
+		if err := wd.StoreArtifacts(); err != nil {
+			fmt.Fprintf(os.Stderr, "WARNING: %s\n", err)
+		}
+	}
+
+	stopErr := wd.stopMockEndpoints()
+	if failed && wd.h.FeatureEnabled(FeaturePersistOnFailure) {
+		fmt.Fprintf(os.Stderr, "keeping working directory of failed test at %s\n", wd.baseDir)
+		wd.h.persistDir(wd.baseDir)
+		return stopErr
+	}
+	if wd.leaked {
+		return stopErr
+	}
+	err := os.RemoveAll(wd.baseDir)
+	if err != nil {
+		return err
+	}
+	return stopErr
+}
+
+// RequireClose is a variant of Close that handles any failure according to
+// the working directory's cleanup policy, which by default fails the test via
+// the given TestControl.
+func (wd *WorkingDir) RequireClose(t TestControl) {
+	t.Helper()
+	if err := wd.Close(); err != nil {
+		wd.handleCleanupFailure(t, "close working directory", err)
+	}
 }
 
 // Setenv sets an environment variable on the WorkingDir.
+//
+// Variables that terraform-exec manages itself, such as TF_LOG or TF_VAR_*,
+// cannot be set this way, and once any variable has been set those are no
+// longer inherited from the test process environment either.
 func (wd *WorkingDir) Setenv(envVar, val string) {
 	if wd.env == nil {
 		wd.env = map[string]string{}
@@ -59,15 +223,43 @@ func (wd *WorkingDir) Setenv(envVar, val string) {
 	wd.env[envVar] = val
 }
 
+// SetPluginEnv sets an environment variable intended only for the provider
+// plugin processes that Terraform launches, such as a feature flag or the
+// URL of a mock API endpoint.
+//
+// Terraform passes its whole environment on to the plugins it launches, so
+// the variable is delivered by setting it for Terraform itself. To make sure
+// it cannot also change the behavior of Terraform, SetPluginEnv returns an
+// error for the names of variables that Terraform reads, which all begin
+// with "TF_", or with "CHECKPOINT_" for its version check.
+//
+// Providers served from the test process using SetReattachInfo are not
+// launched by Terraform and so do not see variables set this way.
+func (wd *WorkingDir) SetPluginEnv(envVar, val string) error {
+	if strings.HasPrefix(envVar, "TF_") || strings.HasPrefix(envVar, "CHECKPOINT_") {
+		return fmt.Errorf("cannot use %s as a plugin environment variable, because Terraform itself would read it", envVar)
+	}
+	wd.Setenv(envVar, val)
+	return nil
+}
+
 // Unsetenv removes an environment variable from the WorkingDir.
 func (wd *WorkingDir) Unsetenv(envVar string) {
 	delete(wd.env, envVar)
 }
 
+// SetReattachInfo makes Terraform attach to already-running provider
+// processes, such as the local build of the provider under test, instead of
+// installing providers itself.
 func (wd *WorkingDir) SetReattachInfo(reattachInfo tfexec.ReattachInfo) {
 	wd.reattachInfo = reattachInfo
 }
 
+// UnsetReattachInfo reverses the effect of SetReattachInfo, so that
+// Terraform installs every provider as normal, using the version constraints
+// in the configuration. A test can use this on one of two working
+// directories to compare a released version of the provider, pinned in the
+// configuration, with the local build.
 func (wd *WorkingDir) UnsetReattachInfo() {
 	wd.reattachInfo = nil
 }
@@ -99,7 +291,7 @@ func (wd *WorkingDir) SetConfig(cfg string) error {
 		return err
 	}
 
-	if p := os.Getenv("TF_ACC_LOG_PATH"); p != "" {
+	if p := wd.configuredLogPath(); p != "" {
 		wd.tf.SetLogPath(p)
 	}
 
@@ -121,12 +313,37 @@ func (wd *WorkingDir) RequireSetConfig(t TestControl, cfg string) {
 	}
 }
 
+// ClearConfig deletes the configuration previously set with SetConfig, along
+// with any saved plan created from it.
+//
+// SetConfig must be called again before any further call to Init, Plan,
+// Apply, or Destroy. To test the removal of every resource from the
+// configuration, call SetConfig with an empty string instead, because
+// Terraform refuses to plan without any configuration files.
+func (wd *WorkingDir) ClearConfig() error {
+	err := os.Remove(wd.configFilename())
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return wd.ClearPlan()
+}
+
+// RequireClearConfig is a variant of ClearConfig that will fail the test via
+// the given TestControl if the configuration cannot be cleared.
+func (wd *WorkingDir) RequireClearConfig(t TestControl) {
+	t.Helper()
+	if err := wd.ClearConfig(); err != nil {
+		t := testingT{t}
+		t.Fatalf("failed to clear config: %s", err)
+	}
+}
+
 // ClearState deletes any Terraform state present in the working directory.
 //
 // Any remote objects tracked by the state are not destroyed first, so this
 // will leave them dangling in the remote system.
 func (wd *WorkingDir) ClearState() error {
-	err := os.Remove(filepath.Join(wd.baseDir, "terraform.tfstate"))
+	err := os.Remove(wd.stateFilename())
 	if os.IsNotExist(err) {
 		return nil
 	}
@@ -145,6 +362,7 @@ func (wd *WorkingDir) RequireClearState(t TestControl) {
 
 // ClearPlan deletes any saved plan present in the working directory.
 func (wd *WorkingDir) ClearPlan() error {
+	wd.planApplied = false
 	err := os.Remove(wd.planFilename())
 	if os.IsNotExist(err) {
 		return nil
@@ -164,12 +382,30 @@ func (wd *WorkingDir) RequireClearPlan(t TestControl) {
 
 // Init runs "terraform init" for the given working directory, forcing Terraform
 // to use the current version of the plugin under test.
+//
+// Init does nothing if it already succeeded and the configuration has not
+// changed since. Use ReInit to run it again regardless.
 func (wd *WorkingDir) Init() error {
+	if wd.runDirect() {
+		return wd.InitContext(context.Background())
+	}
 	if _, err := os.Stat(wd.configFilename()); err != nil {
 		return fmt.Errorf("must call SetConfig before Init")
 	}
+	if wd.initCurrent() {
+		return nil
+	}
+	if err := wd.checkBackend(); err != nil {
+		return err
+	}
 
-	return wd.tf.Init(context.Background(), tfexec.Reattach(wd.reattachInfo))
+	err := wd.run("init", func() error {
+		return wd.tf.Init(context.Background(), tfexec.Reattach(wd.reattachInfo))
+	})
+	if err == nil {
+		wd.markInitialized()
+	}
+	return err
 }
 
 func (wd *WorkingDir) configFilename() string {
@@ -192,16 +428,129 @@ func (wd *WorkingDir) planFilename() string {
 
 // CreatePlan runs "terraform plan" to create a saved plan file, which if successful
 // will then be used for the next call to Apply.
-func (wd *WorkingDir) CreatePlan() error {
-	_, err := wd.tf.Plan(context.Background(), tfexec.Reattach(wd.reattachInfo), tfexec.Refresh(false), tfexec.Out(PlanFileName))
-	return err
+//
+// Options such as Target customize the plan in the same way as the
+// corresponding PlanOptions for CreatePlanWithOptions.
+//
+// If FeatureCheckPlanDeterminism is enabled then CreatePlan plans twice with
+// the same options, and returns a *PlanNondeterminismError if the plans
+// differ.
+func (wd *WorkingDir) CreatePlan(opts ...PlanOption) error {
+	plan := wd.createPlan
+	if len(opts) > 0 {
+		var po PlanOptions
+		for _, opt := range opts {
+			opt.configurePlan(&po)
+		}
+		plan = func() error {
+			return wd.CreatePlanWithOptions(po)
+		}
+	}
+	if err := plan(); err != nil {
+		return err
+	}
+	if wd.h.FeatureEnabled(FeatureCheckPlanDeterminism) {
+		return wd.checkPlanDeterminism(plan)
+	}
+	return nil
+}
+
+// createPlan implements CreatePlan, without the determinism check.
+func (wd *WorkingDir) createPlan() error {
+	if wd.runDirect() {
+		return wd.CreatePlanContext(context.Background())
+	}
+	return wd.runPlan(func() error {
+		_, err := wd.tf.Plan(context.Background(), tfexec.Reattach(wd.reattachInfo), tfexec.Refresh(false), tfexec.Out(PlanFileName))
+		return err
+	})
 }
 
 // RequireCreatePlan is a variant of CreatePlan that will fail the test via
 // the given TestControl if plan creation fails.
-func (wd *WorkingDir) RequireCreatePlan(t TestControl) {
+func (wd *WorkingDir) RequireCreatePlan(t TestControl, opts ...PlanOption) {
 	t.Helper()
-	if err := wd.CreatePlan(); err != nil {
+	if err := wd.CreatePlan(opts...); err != nil {
+		t := testingT{t}
+		t.Fatalf("failed to create plan: %s", err)
+	}
+}
+
+// PlanOptions customizes the behavior of CreatePlanWithOptions. The zero
+// value gives the same behavior as CreatePlan.
+type PlanOptions struct {
+	// AllowDeferral permits Terraform to defer changes which cannot yet be
+	// fully planned, rather than failing. This requires a Terraform
+	// version which supports deferred actions.
+	AllowDeferral bool
+
+	// RefreshOnly creates a refresh-only plan, which proposes only to
+	// update the state to match the remote objects, never to change them.
+	// Unlike other plans this refreshes the state first, so a test can
+	// check from the plan that the provider's read reports out-of-band
+	// changes as drift. This requires Terraform v0.15.4 or later.
+	RefreshOnly bool
+
+	// Refresh refreshes the state before planning, as Terraform does by
+	// default, rather than planning against the state as last recorded.
+	Refresh bool
+
+	// Replace forces the resource instances with the given addresses to be
+	// replaced even if their configuration hasn't changed, as with
+	// "terraform plan -replace". This is the modern alternative to Taint,
+	// and requires Terraform v0.15.2 or later.
+	Replace []string
+
+	// Targets, if set, limits the plan to the given resource addresses and
+	// the resources they depend on, as with "terraform plan -target".
+	Targets []string
+}
+
+// replaceVersion is the first version of Terraform CLI that supports the
+// -replace option.
+var replaceVersion = version.Must(version.NewVersion("0.15.2"))
+
+// refreshOnlyVersion is the first version of Terraform CLI that can create
+// refresh-only plans.
+var refreshOnlyVersion = version.Must(version.NewVersion("0.15.4"))
+
+// CreatePlanWithOptions is a variant of CreatePlan that allows customizing
+// the plan operation.
+func (wd *WorkingDir) CreatePlanWithOptions(opts PlanOptions) error {
+	args := []string{"plan", "-no-color", "-input=false", "-out=" + PlanFileName}
+	if opts.RefreshOnly {
+		if v := wd.h.TerraformVersion(); v != nil && v.LessThan(refreshOnlyVersion) {
+			return fmt.Errorf("refresh-only plans require Terraform v%s or later, but this is v%s", refreshOnlyVersion, v)
+		}
+		args = append(args, "-refresh-only")
+	} else if !opts.Refresh {
+		args = append(args, "-refresh=false")
+	}
+	if len(opts.Replace) > 0 {
+		if v := wd.h.TerraformVersion(); v != nil && v.LessThan(replaceVersion) {
+			return fmt.Errorf("forced replacement requires Terraform v%s or later, but this is v%s", replaceVersion, v)
+		}
+		for _, address := range opts.Replace {
+			args = append(args, "-replace="+address)
+		}
+	}
+	for _, target := range opts.Targets {
+		args = append(args, "-target="+target)
+	}
+	if opts.AllowDeferral {
+		args = append(args, "-allow-deferral")
+	}
+
+	return wd.runPlan(func() error {
+		return wd.runTerraform(context.Background(), args...)
+	})
+}
+
+// RequireCreatePlanWithOptions is a variant of CreatePlanWithOptions that
+// will fail the test via the given TestControl if plan creation fails.
+func (wd *WorkingDir) RequireCreatePlanWithOptions(t TestControl, opts PlanOptions) {
+	t.Helper()
+	if err := wd.CreatePlanWithOptions(opts); err != nil {
 		t := testingT{t}
 		t.Fatalf("failed to create plan: %s", err)
 	}
@@ -210,28 +559,193 @@ func (wd *WorkingDir) RequireCreatePlan(t TestControl) {
 // CreateDestroyPlan runs "terraform plan -destroy" to create a saved plan
 // file, which if successful will then be used for the next call to Apply.
 func (wd *WorkingDir) CreateDestroyPlan() error {
-	_, err := wd.tf.Plan(context.Background(), tfexec.Reattach(wd.reattachInfo), tfexec.Refresh(false), tfexec.Out(PlanFileName), tfexec.Destroy(true))
-	return err
+	if wd.runDirect() {
+		return wd.CreateDestroyPlanContext(context.Background())
+	}
+	return wd.runPlan(func() error {
+		_, err := wd.tf.Plan(context.Background(), tfexec.Reattach(wd.reattachInfo), tfexec.Refresh(false), tfexec.Out(PlanFileName), tfexec.Destroy(true))
+		return err
+	})
 }
 
 // Apply runs "terraform apply". If CreatePlan has previously completed
 // successfully and the saved plan has not been cleared in the meantime then
 // this will apply the saved plan. Otherwise, it will implicitly create a new
-// plan and apply it.
-func (wd *WorkingDir) Apply() error {
+// plan and apply it. Use ApplySavedPlan to require a saved plan.
+//
+// A saved plan can be applied only once, so Apply returns an error if the
+// saved plan has already been applied.
+//
+// Options such as Target customize the apply as described for
+// ApplyWithOptions.
+func (wd *WorkingDir) Apply(opts ...ApplyOption) error {
+	if len(opts) > 0 {
+		var ao ApplyOptions
+		for _, opt := range opts {
+			opt.configureApply(&ao)
+		}
+		return wd.ApplyWithOptions(ao)
+	}
+	if wd.runDirect() {
+		return wd.ApplyContext(context.Background())
+	}
+	implicit, err := wd.planForConfirmation()
+	if err != nil {
+		return err
+	}
+	if implicit {
+		defer wd.ClearPlan()
+	}
 	args := []tfexec.ApplyOption{tfexec.Reattach(wd.reattachInfo), tfexec.Refresh(false)}
-	if wd.HasSavedPlan() {
+	saved := wd.HasSavedPlan()
+	if saved {
+		if err := wd.checkPlanNotApplied(); err != nil {
+			return err
+		}
+		if err := wd.beforeApply(PlanFileName); err != nil {
+			return err
+		}
 		args = append(args, tfexec.DirOrPlan(PlanFileName))
 	}
 
-	return wd.tf.Apply(context.Background(), args...)
+	err = wd.run("apply", func() error {
+		return wd.tf.Apply(context.Background(), args...)
+	})
+	if err == nil && saved {
+		wd.markPlanApplied()
+	}
+	return err
 }
 
 // RequireApply is a variant of Apply that will fail the test via
 // the given TestControl if the apply operation fails.
-func (wd *WorkingDir) RequireApply(t TestControl) {
+func (wd *WorkingDir) RequireApply(t TestControl, opts ...ApplyOption) {
+	t.Helper()
+	if err := wd.Apply(opts...); err != nil {
+		t := testingT{t}
+		t.Fatalf("failed to apply: %s", err)
+	}
+}
+
+// ApplyOptions customizes the behavior of ApplyWithOptions. The zero value
+// gives the same behavior as Apply.
+type ApplyOptions struct {
+	// Targets, if set, limits the apply to the given resource addresses and
+	// the resources they depend on, as with "terraform apply -target".
+	Targets []string
+}
+
+// ApplyWithOptions is a variant of Apply that allows customizing the apply
+// operation, for example to apply a configuration with several resources
+// one resource at a time.
+//
+// Targets can't be applied to a saved plan, so with Targets set
+// ApplyWithOptions returns an error if there is a saved plan, and otherwise
+// creates a targeted plan with CreatePlanWithOptions and applies it. The
+// targeted plan is removed after the apply, so that targeted applies can be
+// repeated.
+func (wd *WorkingDir) ApplyWithOptions(opts ApplyOptions) error {
+	if len(opts.Targets) == 0 {
+		return wd.Apply()
+	}
+	if wd.HasSavedPlan() {
+		return fmt.Errorf("targets can't be applied to a saved plan; pass them to CreatePlanWithOptions instead")
+	}
+	if err := wd.CreatePlanWithOptions(PlanOptions{Targets: opts.Targets}); err != nil {
+		return err
+	}
+	defer wd.ClearPlan()
+	return wd.ApplySavedPlan()
+}
+
+// RequireApplyWithOptions is a variant of ApplyWithOptions that will fail the
+// test via the given TestControl if the apply operation fails.
+func (wd *WorkingDir) RequireApplyWithOptions(t TestControl, opts ApplyOptions) {
+	t.Helper()
+	if err := wd.ApplyWithOptions(opts); err != nil {
+		t := testingT{t}
+		t.Fatalf("failed to apply: %s", err)
+	}
+}
+
+// SavedPlanPath returns the path of the saved plan file created by CreatePlan,
+// so that external tools such as policy checkers can inspect the plan before
+// it is applied. The file exists only while HasSavedPlan returns true.
+func (wd *WorkingDir) SavedPlanPath() string {
+	return wd.planFilename()
+}
+
+// ApplyPlanFile runs "terraform apply" to apply the saved plan file at the
+// given path, which may have been created outside of this working directory,
+// for example by an external tool that modifies or re-creates plans. The path
+// may be absolute or relative to the working directory.
+func (wd *WorkingDir) ApplyPlanFile(path string) error {
+	saved := wd.isSavedPlanPath(path)
+	if saved {
+		if err := wd.checkPlanNotApplied(); err != nil {
+			return err
+		}
+	}
+	if err := wd.beforeApply(path); err != nil {
+		return err
+	}
+	var err error
+	if wd.runDirect() {
+		err = wd.run("apply", func() error {
+			return wd.runTerraform(context.Background(), "apply", "-no-color", "-auto-approve", "-input=false", "-refresh=false", path)
+		})
+	} else {
+		err = wd.run("apply", func() error {
+			return wd.tf.Apply(context.Background(), tfexec.Reattach(wd.reattachInfo), tfexec.Refresh(false), tfexec.DirOrPlan(path))
+		})
+	}
+	if err == nil && saved {
+		wd.markPlanApplied()
+	}
+	return err
+}
+
+// RequireApplyPlanFile is a variant of ApplyPlanFile that will fail the test
+// via the given TestControl if the apply operation fails.
+func (wd *WorkingDir) RequireApplyPlanFile(t TestControl, path string) {
+	t.Helper()
+	if err := wd.ApplyPlanFile(path); err != nil {
+		t := testingT{t}
+		t.Fatalf("failed to apply plan file %s: %s", path, err)
+	}
+}
+
+// ApplyWithTimeout is a variant of Apply that interrupts Terraform if the
+// apply operation has not completed within the given timeout.
+//
+// After an interruption, Terraform is given the opportunity to finish
+// persisting state for any objects it already created, and then
+// ApplyWithTimeout attempts to destroy them so that a hanging provider does
+// not leave remote objects behind. An error is returned when a timeout
+// occurs, whether or not the destroy succeeded.
+func (wd *WorkingDir) ApplyWithTimeout(timeout time.Duration) error {
+	ctx, cancel, _ := withTimeout(context.Background(), timeout)
+	defer cancel()
+
+	// Only the timeout cancels ctx, but it may fire just as the apply
+	// completes, so the apply counts as timed out only if it was actually
+	// interrupted.
+	err := wd.ApplyContext(ctx)
+	if ctxErr := ctx.Err(); ctxErr == nil || !errors.Is(err, ctxErr) {
+		return err
+	}
+
+	if destroyErr := wd.Destroy(); destroyErr != nil {
+		return fmt.Errorf("apply timed out after %s and the subsequent destroy failed, so remote objects may still exist: %s", timeout, destroyErr)
+	}
+	return fmt.Errorf("apply timed out after %s; objects created before the timeout were destroyed", timeout)
+}
+
+// RequireApplyWithTimeout is a variant of ApplyWithTimeout that will fail the
+// test via the given TestControl if the apply operation fails or times out.
+func (wd *WorkingDir) RequireApplyWithTimeout(t TestControl, timeout time.Duration) {
 	t.Helper()
-	if err := wd.Apply(); err != nil {
+	if err := wd.ApplyWithTimeout(timeout); err != nil {
 		t := testingT{t}
 		t.Fatalf("failed to apply: %s", err)
 	}
@@ -242,8 +756,49 @@ func (wd *WorkingDir) RequireApply(t TestControl) {
 //
 // If destroy fails then remote objects might still exist, and continue to
 // exist after a particular test is concluded.
-func (wd *WorkingDir) Destroy() error {
-	return wd.tf.Destroy(context.Background(), tfexec.Reattach(wd.reattachInfo), tfexec.Refresh(false))
+//
+// Options such as Target customize the destroy in the same way as the
+// corresponding DestroyOptions for DestroyWithOptions.
+func (wd *WorkingDir) Destroy(opts ...DestroyOption) error {
+	var do DestroyOptions
+	for _, opt := range opts {
+		opt.configureDestroy(&do)
+	}
+	return wd.DestroyWithOptions(do)
+}
+
+// DestroyOptions customizes the behavior of DestroyWithOptions. The zero
+// value gives the same behavior as Destroy.
+type DestroyOptions struct {
+	// Refresh, if set, makes Terraform refresh the state before destroying.
+	// This is skipped by default so that teardown of large configurations
+	// is fast.
+	Refresh bool
+
+	// Targets, if set, limits the destroy to the given resource addresses
+	// and the resources that depend on them, as with "terraform destroy
+	// -target".
+	Targets []string
+}
+
+// DestroyWithOptions is a variant of Destroy that allows customizing the
+// destroy operation, for example to destroy only some resources so that a
+// test can verify the partial destroy behavior of dependent resources.
+func (wd *WorkingDir) DestroyWithOptions(opts DestroyOptions) error {
+	if wd.runDirect() {
+		return wd.DestroyWithOptionsContext(context.Background(), opts)
+	}
+	if err := wd.beforeDestroy(); err != nil {
+		return err
+	}
+	args := []tfexec.DestroyOption{tfexec.Reattach(wd.reattachInfo), tfexec.Refresh(opts.Refresh)}
+	for _, target := range opts.Targets {
+		args = append(args, tfexec.Target(target))
+	}
+
+	return wd.run("destroy", func() error {
+		return wd.tf.Destroy(context.Background(), args...)
+	})
 }
 
 // RequireDestroy is a variant of Destroy that will fail the test via
@@ -251,12 +806,19 @@ func (wd *WorkingDir) Destroy() error {
 //
 // If destroy fails then remote objects might still exist, and continue to
 // exist after a particular test is concluded.
-func (wd *WorkingDir) RequireDestroy(t TestControl) {
+func (wd *WorkingDir) RequireDestroy(t TestControl, opts ...DestroyOption) {
 	t.Helper()
-	if err := wd.Destroy(); err != nil {
-		t := testingT{t}
-		t.Logf("WARNING: destroy failed, so remote objects may still exist and be subject to billing")
-		t.Fatalf("failed to destroy: %s", err)
+	if err := wd.Destroy(opts...); err != nil {
+		wd.handleCleanupFailure(t, "destroy", err)
+	}
+}
+
+// RequireDestroyWithOptions is a variant of DestroyWithOptions that will fail
+// the test via the given TestControl if the destroy operation fails.
+func (wd *WorkingDir) RequireDestroyWithOptions(t TestControl, opts DestroyOptions) {
+	t.Helper()
+	if err := wd.DestroyWithOptions(opts); err != nil {
+		wd.handleCleanupFailure(t, "destroy", err)
 	}
 }
 
@@ -275,8 +837,21 @@ func (wd *WorkingDir) SavedPlan() (*tfjson.Plan, error) {
 	if !wd.HasSavedPlan() {
 		return nil, fmt.Errorf("there is no current saved plan")
 	}
+	if err := wd.checkJSONOutput("reading the plan"); err != nil {
+		return nil, err
+	}
+
+	var ret *tfjson.Plan
+	raw, err := wd.runStdout("show", func() error {
+		var err error
+		ret, err = wd.tf.ShowPlanFile(context.Background(), wd.planFilename(), tfexec.Reattach(wd.reattachInfo))
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
 
-	return wd.tf.ShowPlanFile(context.Background(), wd.planFilename(), tfexec.Reattach(wd.reattachInfo))
+	return ret, wd.runJSONDecodeHooks(JSONKindPlan, raw)
 }
 
 // RequireSavedPlan is a variant of SavedPlan that will fail the test via
@@ -300,16 +875,10 @@ func (wd *WorkingDir) SavedPlanStdout() (string, error) {
 		return "", fmt.Errorf("there is no current saved plan")
 	}
 
-	var ret bytes.Buffer
-
-	wd.tf.SetStdout(&ret)
-	defer wd.tf.SetStdout(ioutil.Discard)
-	_, err := wd.tf.ShowPlanFileRaw(context.Background(), wd.planFilename(), tfexec.Reattach(wd.reattachInfo))
-	if err != nil {
-		return "", err
-	}
-
-	return ret.String(), nil
+	return wd.runStdout("show", func() error {
+		_, err := wd.tf.ShowPlanFileRaw(context.Background(), wd.planFilename(), tfexec.Reattach(wd.reattachInfo))
+		return err
+	})
 }
 
 // RequireSavedPlanStdout is a variant of SavedPlanStdout that will fail the test via
@@ -324,11 +893,26 @@ func (wd *WorkingDir) RequireSavedPlanStdout(t TestControl) string {
 	return ret
 }
 
-// State returns an object describing the current state.
+// State returns an object describing the current state, in which each
+// resource instance includes its mode, provider name, schema version and
+// attribute values.
 //
 // If the state cannot be read, State returns an error.
 func (wd *WorkingDir) State() (*tfjson.State, error) {
-	return wd.tf.Show(context.Background(), tfexec.Reattach(wd.reattachInfo))
+	if err := wd.checkJSONOutput("reading the state"); err != nil {
+		return nil, err
+	}
+	var ret *tfjson.State
+	raw, err := wd.runStdout("show", func() error {
+		var err error
+		ret, err = wd.tf.Show(context.Background(), tfexec.Reattach(wd.reattachInfo))
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return ret, wd.runJSONDecodeHooks(JSONKindState, raw)
 }
 
 // RequireState is a variant of State that will fail the test via
@@ -343,9 +927,111 @@ func (wd *WorkingDir) RequireState(t TestControl) *tfjson.State {
 	return ret
 }
 
+// CopyStateFrom replaces the state in the working directory with a copy of
+// the state in another working directory, which is typically one configured
+// to use a different version of the provider under test. Any saved plan is
+// cleared, because it was created from the previous state.
+//
+// The state is copied as-is, so the provider in this working directory must be
+// able to upgrade any resource data written by the other.
+func (wd *WorkingDir) CopyStateFrom(other *WorkingDir) error {
+	src, err := ioutil.ReadFile(other.stateFilename())
+	if err != nil {
+		return fmt.Errorf("failed to read source state: %w", err)
+	}
+	err = ioutil.WriteFile(wd.stateFilename(), src, 0600)
+	if err != nil {
+		return err
+	}
+	return wd.ClearPlan()
+}
+
+// RequireCopyStateFrom is a variant of CopyStateFrom that will fail the test
+// via the given TestControl if the state cannot be copied.
+func (wd *WorkingDir) RequireCopyStateFrom(t TestControl, other *WorkingDir) {
+	t.Helper()
+	if err := wd.CopyStateFrom(other); err != nil {
+		t := testingT{t}
+		t.Fatalf("failed to copy state: %s", err)
+	}
+}
+
+// RemoteStateConfig returns a configuration snippet declaring a
+// terraform_remote_state data source with the given name, which reads the
+// outputs of this working directory's state. Include the snippet in the
+// configuration of another working directory to test a scenario where one
+// configuration depends on another.
+//
+// For example, if the snippet is created with name "network" then the other
+// configuration can refer to this working directory's output "vpc_id" as
+// data.terraform_remote_state.network.outputs.vpc_id.
+func (wd *WorkingDir) RemoteStateConfig(name string) string {
+	return fmt.Sprintf(`
+data "terraform_remote_state" %q {
+  backend = "local"
+
+  config = {
+    path = %q
+  }
+}
+`, name, filepath.ToSlash(wd.stateFilename()))
+}
+
+// RawState returns the current state as the exact JSON document produced by
+// "terraform show -json", for assertions that the typed State result does not
+// cover. Unlike State, this does not depend on the state format version being
+// one that terraform-json understands.
+//
+// If the state cannot be read, RawState returns an error.
+func (wd *WorkingDir) RawState() ([]byte, error) {
+	if err := wd.checkJSONOutput("reading the state"); err != nil {
+		return nil, err
+	}
+	ret, err := wd.runStdout("show", func() error {
+		return wd.runTerraform(context.Background(), "show", "-json")
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return []byte(ret), nil
+}
+
+// RequireRawState is a variant of RawState that will fail the test via
+// the given TestControl if the state cannot be read.
+func (wd *WorkingDir) RequireRawState(t TestControl) []byte {
+	t.Helper()
+	ret, err := wd.RawState()
+	if err != nil {
+		t := testingT{t}
+		t.Fatalf("failed to read state: %s", err)
+	}
+	return ret
+}
+
+// StatePath returns the path of the local state file in the working
+// directory, for use by external tools. The file does not exist until
+// Terraform has written some state.
+func (wd *WorkingDir) StatePath() string {
+	return wd.stateFilename()
+}
+
+func (wd *WorkingDir) stateFilename() string {
+	if wd.workspace != "" {
+		// the local backend keeps non-default workspaces separately
+		return filepath.Join(wd.baseDir, "terraform.tfstate.d", wd.workspace, "terraform.tfstate")
+	}
+	return filepath.Join(wd.baseDir, "terraform.tfstate")
+}
+
 // Import runs terraform import
 func (wd *WorkingDir) Import(resource, id string) error {
-	return wd.tf.Import(context.Background(), resource, id, tfexec.Config(wd.baseDir), tfexec.Reattach(wd.reattachInfo))
+	if wd.runDirect() {
+		return wd.ImportContext(context.Background(), resource, id)
+	}
+	return wd.run("import", func() error {
+		return wd.tf.Import(context.Background(), resource, id, tfexec.Config(wd.baseDir), tfexec.Reattach(wd.reattachInfo))
+	})
 }
 
 // RequireImport is a variant of Import that will fail the test via
@@ -360,7 +1046,12 @@ func (wd *WorkingDir) RequireImport(t TestControl, resource, id string) {
 
 // Refresh runs terraform refresh
 func (wd *WorkingDir) Refresh() error {
-	return wd.tf.Refresh(context.Background(), tfexec.Reattach(wd.reattachInfo), tfexec.State(filepath.Join(wd.baseDir, "terraform.tfstate")))
+	if wd.runDirect() {
+		return wd.RefreshContext(context.Background())
+	}
+	return wd.run("refresh", func() error {
+		return wd.tf.Refresh(context.Background(), tfexec.Reattach(wd.reattachInfo), tfexec.State(wd.stateFilename()))
+	})
 }
 
 // RequireRefresh is a variant of Refresh that will fail the test via
@@ -377,7 +1068,16 @@ func (wd *WorkingDir) RequireRefresh(t TestControl) {
 //
 // If the schemas cannot be read, Schemas returns an error.
 func (wd *WorkingDir) Schemas() (*tfjson.ProviderSchemas, error) {
-	return wd.tf.ProvidersSchema(context.Background())
+	if err := wd.checkJSONOutput("reading provider schemas"); err != nil {
+		return nil, err
+	}
+	var ret *tfjson.ProviderSchemas
+	err := wd.run("providers schema", func() error {
+		var err error
+		ret, err = wd.tf.ProvidersSchema(context.Background())
+		return err
+	})
+	return ret, err
 }
 
 // RequireSchemas is a variant of Schemas that will fail the test via
@@ -392,3 +1092,70 @@ func (wd *WorkingDir) RequireSchemas(t TestControl) *tfjson.ProviderSchemas {
 	}
 	return ret
 }
+
+// WriteOnlyAttributesUnset returns an error unless each of the given
+// attributes of the resource instance with the given address is null in the
+// current state, as is required of write-only attributes, which are
+// available in Terraform v1.11 and later.
+func (wd *WorkingDir) WriteOnlyAttributesUnset(address string, attrs ...string) error {
+	values, err := wd.ResourceAttributes(address)
+	if err != nil {
+		return err
+	}
+	for _, attr := range attrs {
+		if v := values[attr]; v != nil {
+			return fmt.Errorf("write-only attribute %s.%s is set in state", address, attr)
+		}
+	}
+	return nil
+}
+
+// RequireWriteOnlyAttributesUnset is a variant of WriteOnlyAttributesUnset
+// that will fail the test via the given TestControl if any of the attributes
+// are set in state.
+func (wd *WorkingDir) RequireWriteOnlyAttributesUnset(t TestControl, address string, attrs ...string) {
+	t.Helper()
+	if err := wd.WriteOnlyAttributesUnset(address, attrs...); err != nil {
+		t := testingT{t}
+		t.Fatalf("%s", err)
+	}
+}
+
+// jsonStateModule is the subset of the JSON state representation of a module
+// needed to find resource attribute values without depending on the state
+// format version being one that terraform-json understands.
+type jsonStateModule struct {
+	Resources []struct {
+		Address string                 `json:"address"`
+		Mode    string                 `json:"mode"`
+		Values  map[string]interface{} `json:"values"`
+	} `json:"resources"`
+	ChildModules []jsonStateModule `json:"child_modules"`
+}
+
+func (m jsonStateModule) resourceValues(address string) (map[string]interface{}, bool) {
+	for _, r := range m.Resources {
+		if r.Address == address {
+			return r.Values, true
+		}
+	}
+	for _, child := range m.ChildModules {
+		if values, ok := child.resourceValues(address); ok {
+			return values, true
+		}
+	}
+	return nil, false
+}
+
+// allResourceValues adds the attribute values of each managed resource
+// instance in the module and its descendants to values, by address.
+func (m jsonStateModule) allResourceValues(values map[string]interface{}) {
+	for _, r := range m.Resources {
+		if r.Mode == "managed" {
+			values[r.Address] = r.Values
+		}
+	}
+	for _, child := range m.ChildModules {
+		child.allResourceValues(values)
+	}
+}
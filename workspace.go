@@ -0,0 +1,167 @@
+package tftest
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// SetWorkspace makes all subsequent Terraform commands in the working
+// directory run in the given workspace, as if TF_WORKSPACE were set in their
+// environment. Pass "default" or an empty string to return to the default
+// workspace.
+//
+// The workspace must already exist, and SetWorkspace returns an error listing
+// the available workspaces if it doesn't. The working directory must
+// therefore already be initialized.
+func (wd *WorkingDir) SetWorkspace(name string) error {
+	if name == "" || name == "default" {
+		wd.workspace = ""
+		return nil
+	}
+
+	workspaces, err := wd.ListWorkspaces()
+	if err != nil {
+		return err
+	}
+	for _, ws := range workspaces {
+		if ws == name {
+			wd.workspace = name
+			return nil
+		}
+	}
+	return fmt.Errorf("workspace %q does not exist; available workspaces are %s", name, strings.Join(workspaces, ", "))
+}
+
+// RequireSetWorkspace is a variant of SetWorkspace that will fail the test via
+// the given TestControl if the workspace cannot be selected.
+func (wd *WorkingDir) RequireSetWorkspace(t TestControl, name string) {
+	t.Helper()
+	if err := wd.SetWorkspace(name); err != nil {
+		t := testingT{t}
+		t.Fatalf("failed to set workspace: %s", err)
+	}
+}
+
+// Workspace returns the name of the workspace that commands in the working
+// directory run in.
+func (wd *WorkingDir) Workspace() string {
+	if wd.workspace == "" {
+		return "default"
+	}
+	return wd.workspace
+}
+
+// SelectWorkspace is an alias of SetWorkspace, named after "terraform
+// workspace select".
+func (wd *WorkingDir) SelectWorkspace(name string) error {
+	return wd.SetWorkspace(name)
+}
+
+// RequireSelectWorkspace is a variant of SelectWorkspace that will fail the
+// test via the given TestControl if the workspace cannot be selected.
+func (wd *WorkingDir) RequireSelectWorkspace(t TestControl, name string) {
+	t.Helper()
+	wd.RequireSetWorkspace(t, name)
+}
+
+// NewWorkspace runs "terraform workspace new" to create a workspace with the
+// given name, which then becomes the workspace that subsequent commands run
+// in, as with SetWorkspace. Each workspace has its own state, so this allows
+// a test to isolate several scenarios within one working directory, or to
+// test configuration that refers to terraform.workspace.
+func (wd *WorkingDir) NewWorkspace(name string) error {
+	err := wd.run("workspace new", func() error {
+		return wd.tf.WorkspaceNew(context.Background(), name)
+	})
+	if err != nil {
+		return err
+	}
+	wd.workspace = name
+	return nil
+}
+
+// RequireNewWorkspace is a variant of NewWorkspace that will fail the test via
+// the given TestControl if the workspace cannot be created.
+func (wd *WorkingDir) RequireNewWorkspace(t TestControl, name string) {
+	t.Helper()
+	if err := wd.NewWorkspace(name); err != nil {
+		t := testingT{t}
+		t.Fatalf("failed to create workspace: %s", err)
+	}
+}
+
+// DeleteWorkspace runs "terraform workspace delete" to delete the workspace
+// with the given name. Terraform refuses to delete a workspace whose state
+// still tracks objects, so destroy them first.
+//
+// The workspace that commands currently run in cannot be deleted; select
+// another with SetWorkspace first.
+func (wd *WorkingDir) DeleteWorkspace(name string) error {
+	if name == wd.Workspace() {
+		return fmt.Errorf("cannot delete workspace %q because it is selected", name)
+	}
+	return wd.run("workspace delete", func() error {
+		return wd.runTerraform(context.Background(), "workspace", "delete", "-no-color", name)
+	})
+}
+
+// RequireDeleteWorkspace is a variant of DeleteWorkspace that will fail the
+// test via the given TestControl if the workspace cannot be deleted.
+func (wd *WorkingDir) RequireDeleteWorkspace(t TestControl, name string) {
+	t.Helper()
+	if err := wd.DeleteWorkspace(name); err != nil {
+		t := testingT{t}
+		t.Fatalf("failed to delete workspace: %s", err)
+	}
+}
+
+// ListWorkspaces returns the names of all of the workspaces, including
+// "default".
+func (wd *WorkingDir) ListWorkspaces() ([]string, error) {
+	var workspaces []string
+	err := wd.run("workspace list", func() error {
+		var err error
+		workspaces, _, err = wd.tf.WorkspaceList(context.Background())
+		return err
+	})
+	return workspaces, err
+}
+
+// RequireListWorkspaces is a variant of ListWorkspaces that will fail the test
+// via the given TestControl if the workspaces cannot be listed.
+func (wd *WorkingDir) RequireListWorkspaces(t TestControl) []string {
+	t.Helper()
+	ret, err := wd.ListWorkspaces()
+	if err != nil {
+		t := testingT{t}
+		t.Fatalf("failed to list workspaces: %s", err)
+	}
+	return ret
+}
+
+// applyWorkspace makes the workspace chosen with SetWorkspace the selected
+// workspace for the next command.
+//
+// terraform-exec always clears TF_WORKSPACE, so instead we record the choice
+// in the same file that "terraform workspace select" would, which has the
+// same effect.
+func (wd *WorkingDir) applyWorkspace() error {
+	p := filepath.Join(wd.baseDir, ".terraform", "environment")
+	if wd.workspace == "" {
+		err := os.Remove(p)
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	err := os.MkdirAll(filepath.Dir(p), 0755)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(p, []byte(wd.workspace), 0644)
+}